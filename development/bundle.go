@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"recommendation-service/master"
+	"recommendation-service/model"
+	"recommendation-service/syncutils"
+	"strings"
+)
+
+// Junta el modelo entrenado con el catálogo de películas en la configuración
+// del master, en reemplazo de update_model_config.ipynb:
+//
+//	go run bundle.go -model model/model.bin -slaves 10.0.0.2,10.0.0.3
+func main() {
+	modelFile := flag.String("model", "model/model.json", "trained model (JSON or binary)")
+	catalogFile := flag.String("catalog", "config/master.json", "config with movieTitles, movieGenreNames and movieGenreIds (see importer.go)")
+	slaves := flag.String("slaves", "", "comma separated slave IPs (default: the ones in the catalog)")
+	out := flag.String("out", "config/master.json", "output master config")
+	flag.Parse()
+
+	modelConfig, err := model.LoadModelFile(*modelFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var catalog master.MasterConfig
+	err = syncutils.LoadJsonFile(*catalogFile, &catalog)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slaveIps := catalog.SlaveIps
+	if *slaves != "" {
+		slaveIps = strings.Split(*slaves, ",")
+	}
+
+	config, err := master.BuildMasterConfig(modelConfig, &catalog, slaveIps)
+	if err != nil {
+		log.Fatalf("ERROR: bundle: Invalid bundle: %v", err)
+	}
+	bytes, err := json.MarshalIndent(&config, "", "    ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Dir(*out), 0755)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = os.WriteFile(*out, bytes, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("INFO: Master config with %d movies and %d slaves written to %s", len(config.MovieTitles), len(config.SlaveIps), *out)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"recommendation-service/master"
+	"recommendation-service/movielens"
+	"recommendation-service/syncutils"
+)
+
+// Importa un dataset MovieLens y genera el CSV de ratings para training.go y el
+// catálogo de config/master.json, en reemplazo de clean_dataset.ipynb:
+//
+//	go run importer.go -dir dataset/ml-latest-small
+func main() {
+	dir := flag.String("dir", "dataset", "MovieLens directory (ml-100k or ml-latest layout)")
+	layout := flag.String("layout", "", "ml-100k or ml-latest (detected from the files if empty)")
+	ratingsFile := flag.String("ratings", "dataset/clean_ratings.csv", "output ratings file")
+	configFile := flag.String("config", "config/master.json", "master config to create or update")
+	genresFile := flag.String("genres", "dataset/genres.json", "output genres file for the frontend")
+	minMovieRatings := flag.Int("min-movie-ratings", 10, "drop movies with this many ratings or fewer")
+	minUserRatings := flag.Int("min-user-ratings", 0, "drop users with this many ratings or fewer")
+	maxUsers := flag.Int("max-users", 1600, "keep only the most active users (0 = all)")
+	maxMovies := flag.Int("max-movies", 1600, "keep only the most rated movies (0 = all)")
+	flag.Parse()
+
+	if *layout == "" {
+		var err error
+		*layout, err = movielens.DetectLayout(*dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Printf("INFO: Importing %s (%s)", *dir, *layout)
+	result, err := movielens.Import(*dir, *layout, movielens.CleanConfig{
+		MinMovieRatings: *minMovieRatings,
+		MinUserRatings:  *minUserRatings,
+		MaxUsers:        *maxUsers,
+		MaxMovies:       *maxMovies,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("INFO: %d ratings, %d users, %d movies, %d genres", len(result.Ratings), len(result.UserIds), len(result.MovieIds), len(result.MovieGenreNames))
+
+	err = os.MkdirAll(filepath.Dir(*ratingsFile), 0755)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = movielens.WriteRatings(*ratingsFile, result.Ratings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("INFO: Ratings written to %s", *ratingsFile)
+
+	// Se conserva la lista de slaves de la configuración existente; el modelo
+	// anterior queda invalidado por el reindexado.
+	var config master.MasterConfig
+	if _, err := os.Stat(*configFile); !errors.Is(err, os.ErrNotExist) {
+		err = syncutils.LoadJsonFile(*configFile, &config)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(config.ModelConfig.Q) > 0 || config.ModelFile != "" {
+		log.Println("INFO: Removing the previous model from the config, bundle a model trained on the new ratings")
+	}
+	master.ImportCatalog(&config, result)
+	err = writeJson(*configFile, &config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("INFO: Master config written to %s", *configFile)
+
+	err = writeJson(*genresFile, map[string][]string{"genres": result.MovieGenreNames})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("INFO: Genres written to %s", *genresFile)
+}
+
+func writeJson(filename string, object any) error {
+	bytes, err := json.MarshalIndent(object, "", "    ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, bytes, 0644)
+}
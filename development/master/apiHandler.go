@@ -2,8 +2,16 @@ package master
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+)
+
+const (
+	defaultSimilarMovies = 10
+	maxSimilarMovies     = 100
 )
 
 func (master *Master) genresHandler(w http.ResponseWriter, r *http.Request) {
@@ -51,3 +59,72 @@ func (master *Master) getMoviesByGenresHandler(w http.ResponseWriter, r *http.Re
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(movies)
 }
+
+// similarMoviesHandler atiende GET /movies/{id}/similar?k=N&genreIds=1,2 con
+// las películas de factores más parecidos a la pedida.
+func (master *Master) similarMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	movieId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || movieId < 0 || movieId >= len(master.movieTitles) {
+		http.Error(w, "Película no encontrada", http.StatusNotFound)
+		return
+	}
+	k := defaultSimilarMovies
+	if value := r.URL.Query().Get("k"); value != "" {
+		k, err = strconv.Atoi(value)
+		if err != nil || k <= 0 || k > maxSimilarMovies {
+			http.Error(w, "El parámetro k tiene que ser un entero entre 1 y "+strconv.Itoa(maxSimilarMovies), http.StatusBadRequest)
+			return
+		}
+	}
+	genreIds, err := master.parseGenreIds(r.URL.Query().Get("genreIds"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	predictions, err := master.handleModelSimilar(movieId, k, genreIds)
+	if err != nil {
+		log.Printf("ERROR: %s: %v", handleModelSimilarPrefix, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	response := MasterSimilarResponse{
+		MovieId: movieId,
+		Title:   master.movieTitles[movieId],
+		Similar: make([]SimilarMovie, len(predictions)),
+	}
+	for i, prediction := range predictions {
+		response.Similar[i] = SimilarMovie{
+			Id:         prediction.MovieId,
+			Title:      master.movieTitles[prediction.MovieId],
+			Genres:     master.movieGenres(prediction.MovieId),
+			Similarity: prediction.Rating,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (master *Master) movieGenres(movieId int) []string {
+	genres := make([]string, len(master.movieGenreIds[movieId]))
+	for i, genreId := range master.movieGenreIds[movieId] {
+		genres[i] = master.movieGenreNames[genreId]
+	}
+	return genres
+}
+
+// parseGenreIds lee una lista de ids de género separados por comas.
+func (master *Master) parseGenreIds(value string) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var genreIds []int
+	for _, field := range strings.Split(value, ",") {
+		genreId, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || genreId < 0 || genreId >= len(master.movieGenreNames) {
+			return nil, fmt.Errorf("Género inválido: %s", field)
+		}
+		genreIds = append(genreIds, genreId)
+	}
+	return genreIds, nil
+}
@@ -0,0 +1,110 @@
+package master
+
+import (
+	"fmt"
+	"log"
+	"recommendation-service/model"
+	"recommendation-service/movielens"
+	"strconv"
+)
+
+// BuildMasterConfig arma la configuración del master a partir de un modelo
+// entrenado y del catálogo de películas (títulos, géneros e ids de catalog) y la
+// valida. Los ratings, P y los sesgos de usuario se descartan: el master solo
+// necesita Q para repartirla entre los slaves.
+func BuildMasterConfig(modelConfig model.ModelConfig, catalog *MasterConfig, slaveIps []string) (MasterConfig, error) {
+	modelConfig.Ratings = nil
+	modelConfig.R = nil
+	modelConfig.P = nil
+	modelConfig.UserBias = nil
+	modelConfig.UserIds = nil
+
+	config := MasterConfig{
+		SlaveIps:        slaveIps,
+		MovieTitles:     catalog.MovieTitles,
+		MovieGenreNames: catalog.MovieGenreNames,
+		MovieGenreIds:   catalog.MovieGenreIds,
+		MovieIds:        catalog.MovieIds,
+		MovieLensIds:    catalog.MovieLensIds,
+		ModelConfig:     modelConfig,
+	}
+	if config.SlaveIps == nil {
+		config.SlaveIps = []string{}
+	}
+	err := ValidateMasterConfig(&config)
+	if err != nil {
+		return MasterConfig{}, err
+	}
+	return config, nil
+}
+
+// ImportCatalog reemplaza el catálogo de config por el de un import de
+// MovieLens y descarta el modelo, que queda invalidado por el reindexado. Los
+// MovieIds son los ids que movielens.WriteRatings escribe en el CSV, así que
+// coinciden con los ItemIds de un modelo entrenado con o sin -map-ids.
+func ImportCatalog(config *MasterConfig, result *movielens.Result) {
+	config.MovieTitles = result.MovieTitles
+	config.MovieGenreNames = result.MovieGenreNames
+	config.MovieGenreIds = result.MovieGenreIds
+	config.MovieIds = make([]string, len(result.MovieIds))
+	for i := range result.MovieIds {
+		config.MovieIds[i] = strconv.Itoa(i)
+	}
+	config.MovieLensIds = result.MovieIds
+	config.ModelConfig = model.ModelConfig{}
+	config.ModelFile = ""
+	if config.SlaveIps == nil {
+		config.SlaveIps = []string{}
+	}
+}
+
+// ValidateMasterConfig comprueba que el modelo, los títulos y los géneros estén
+// alineados película por película, con ModelConfig ya cargado desde ModelFile.
+func ValidateMasterConfig(config *MasterConfig) error {
+	modelConfig := &config.ModelConfig
+	numMovies := len(config.MovieTitles)
+	if len(modelConfig.Q) != numMovies {
+		return fmt.Errorf("Model has %d items but there are %d movie titles", len(modelConfig.Q), numMovies)
+	}
+	if len(config.MovieGenreIds) != numMovies {
+		return fmt.Errorf("There are %d movie titles but %d movie genre lists", numMovies, len(config.MovieGenreIds))
+	}
+	for movieId, genreIds := range config.MovieGenreIds {
+		for _, genreId := range genreIds {
+			if genreId < 0 || genreId >= len(config.MovieGenreNames) {
+				return fmt.Errorf("Movie %d has genre id %d out of range [0, %d)", movieId, genreId, len(config.MovieGenreNames))
+			}
+		}
+	}
+	for movieId, factors := range modelConfig.Q {
+		if len(factors) != modelConfig.NumFeatures {
+			return fmt.Errorf("Movie %d has %d factors, expected %d", movieId, len(factors), modelConfig.NumFeatures)
+		}
+	}
+	if modelConfig.Biased && len(modelConfig.ItemBias) != numMovies {
+		return fmt.Errorf("Model has %d item biases but there are %d movie titles", len(modelConfig.ItemBias), numMovies)
+	}
+	if config.MovieIds != nil && len(config.MovieIds) != numMovies {
+		return fmt.Errorf("There are %d movie titles but %d movie ids", numMovies, len(config.MovieIds))
+	}
+	if config.MovieLensIds != nil && len(config.MovieLensIds) != numMovies {
+		return fmt.Errorf("There are %d movie titles but %d MovieLens ids", numMovies, len(config.MovieLensIds))
+	}
+
+	if modelConfig.ItemIds == nil {
+		return nil
+	}
+	if len(modelConfig.ItemIds) != numMovies {
+		return fmt.Errorf("Model has %d item ids but there are %d movie titles", len(modelConfig.ItemIds), numMovies)
+	}
+	if config.MovieIds == nil {
+		log.Println("INFO: validateConfig: Model has item ids but the config has no movieIds, alignment not verified")
+		return nil
+	}
+	for i, movieId := range config.MovieIds {
+		if modelConfig.ItemIds[i] != movieId {
+			return fmt.Errorf("Model row %d is movie %s but title %d is movie %s", i, modelConfig.ItemIds[i], i, movieId)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package master
+
+import (
+	"os"
+	"path/filepath"
+	"recommendation-service/model"
+	"recommendation-service/movielens"
+	"strconv"
+	"testing"
+)
+
+// writeMovieLens arma un ml-latest chico con ids de película salteados, para
+// que los ids de MovieLens no coincidan con los índices. Cada película tiene
+// una cantidad distinta de ratings, que se devuelve junto con los títulos.
+func writeMovieLens(t *testing.T, dir string) (map[string]string, map[string]int) {
+	t.Helper()
+	titles := map[string]string{"7": "Seven (1995)", "25": "Leaving Las Vegas (1995)", "3": "Grumpier Old Men (1995)", "110": "Braveheart (1995)"}
+	movies := "movieId,title,genres\n"
+	for _, id := range []string{"110", "25", "3", "7"} {
+		movies += id + "," + titles[id] + ",Drama|Comedy\n"
+	}
+	counts := map[string]int{}
+	ratings := "userId,movieId,rating,timestamp\n"
+	for user := 1; user <= 4; user++ {
+		for i, id := range []string{"7", "25", "3", "110"} {
+			if user <= i+1 {
+				ratings += strconv.Itoa(user) + "," + id + "," + strconv.Itoa(1+(user*i)%5) + ",100\n"
+				counts[id]++
+			}
+		}
+	}
+	for name, data := range map[string]string{"movies.csv": movies, "ratings.csv": ratings} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return titles, counts
+}
+
+// TestImportTrainBundle recorre importer.go, training.go train (con y sin
+// -map-ids) y bundle.go, y comprueba que cada fila de Q se haya entrenado con
+// los ratings de la película de su título.
+func TestImportTrainBundle(t *testing.T) {
+	dir := t.TempDir()
+	titles, counts := writeMovieLens(t, dir)
+	result, err := movielens.Import(dir, movielens.LayoutLatest, movielens.CleanConfig{})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	ratingsFile := filepath.Join(dir, "clean_ratings.csv")
+	err = movielens.WriteRatings(ratingsFile, result.Ratings)
+	if err != nil {
+		t.Fatalf("WriteRatings: %v", err)
+	}
+	var catalog MasterConfig
+	ImportCatalog(&catalog, result)
+
+	for _, mapIds := range []bool{false, true} {
+		datasetConfig := model.DefaultDatasetConfig()
+		datasetConfig.MapIds = mapIds
+		dataset, err := model.LoadDataset(ratingsFile, datasetConfig)
+		if err != nil {
+			t.Fatalf("mapIds %v: LoadDataset: %v", mapIds, err)
+		}
+		trained, err := model.NewModelFromConfig(&model.ModelConfig{NumFeatures: 2, Epochs: 2, LearningRate: 0.01}, dataset.Ratings, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = trained.SetIdMaps(dataset.Users, dataset.Items)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trained.Train()
+		modelFile := filepath.Join(dir, "model.json")
+		err = trained.ParamsToJson(modelFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modelConfig, err := model.LoadModelFile(modelFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := BuildMasterConfig(modelConfig, &catalog, nil)
+		if err != nil {
+			t.Fatalf("mapIds %v: BuildMasterConfig: %v", mapIds, err)
+		}
+		if mapIds && len(config.ModelConfig.ItemIds) != len(config.MovieTitles) {
+			t.Errorf("mapIds %v: model has %d item ids for %d titles", mapIds, len(config.ModelConfig.ItemIds), len(config.MovieTitles))
+		}
+		rowCounts := make([]int, len(config.ModelConfig.Q))
+		for _, rating := range dataset.Ratings.Entries() {
+			rowCounts[rating.ItemId]++
+		}
+		for row := range config.ModelConfig.Q {
+			movieLensId := config.MovieLensIds[row]
+			if config.MovieTitles[row] != titles[movieLensId] || rowCounts[row] != counts[movieLensId] {
+				t.Errorf("mapIds %v: row %d with %d ratings has title %q, MovieLens %s has %d ratings and title %q", mapIds, row, rowCounts[row], config.MovieTitles[row], movieLensId, counts[movieLensId], titles[movieLensId])
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package feedback
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	EventRating = "rating"
+	EventClick  = "click"
+)
+
+type Event struct {
+	UserId  int     `json:"userId"`
+	MovieId int     `json:"movieId"`
+	Type    string  `json:"type"`
+	Rating  float64 `json:"rating,omitempty"`
+	Time    int64   `json:"time"`
+}
+
+// Log es el registro de eventos de feedback: un JSON por línea, solo se
+// agregan líneas al final. Los lectores avanzan por offset en bytes.
+type Log struct {
+	mu       sync.Mutex
+	filename string
+	file     *os.File
+}
+
+// OpenLog abre (o crea) el registro. Si la última línea quedó incompleta por
+// una escritura cortada se descarta.
+func OpenLog(filename string) (*Log, error) {
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("feedbackLogError: Error creating directory: %v", err)
+	}
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("feedbackLogError: Error opening %s: %v", filename, err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("feedbackLogError: Error reading %s: %v", filename, err)
+	}
+	if complete := int64(bytes.LastIndexByte(data, '\n') + 1); complete < int64(len(data)) {
+		err = file.Truncate(complete)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("feedbackLogError: Error truncating %s: %v", filename, err)
+		}
+	}
+	_, err = file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("feedbackLogError: Error seeking %s: %v", filename, err)
+	}
+	return &Log{filename: filename, file: file}, nil
+}
+
+func (log *Log) Append(event Event) error {
+	line, err := json.Marshal(&event)
+	if err != nil {
+		return fmt.Errorf("feedbackLogError: Error encoding event: %v", err)
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	_, err = log.file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("feedbackLogError: Error writing event: %v", err)
+	}
+	return nil
+}
+
+// ReadFrom devuelve los eventos escritos desde offset, el offset siguiente y
+// la cantidad de líneas inválidas, que se saltean para que no traben a los
+// lectores.
+func (log *Log) ReadFrom(offset int64) ([]Event, int64, int, error) {
+	file, err := os.Open(log.filename)
+	if err != nil {
+		return nil, offset, 0, fmt.Errorf("feedbackLogError: Error opening %s: %v", log.filename, err)
+	}
+	defer file.Close()
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, offset, 0, fmt.Errorf("feedbackLogError: Error seeking %s: %v", log.filename, err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, offset, 0, fmt.Errorf("feedbackLogError: Error reading %s: %v", log.filename, err)
+	}
+	// Una línea sin salto todavía se está escribiendo
+	data = data[:bytes.LastIndexByte(data, '\n')+1]
+	var events []Event
+	invalid := 0
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		var event Event
+		err = json.Unmarshal(data[:end], &event)
+		if err != nil {
+			invalid++
+		} else {
+			events = append(events, event)
+		}
+		offset += int64(end + 1)
+		data = data[end+1:]
+	}
+	return events, offset, invalid, nil
+}
+
+func (log *Log) Close() error {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return log.file.Close()
+}
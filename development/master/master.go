@@ -6,12 +6,12 @@ import (
 	"io"
 	"log"
 	"math/rand"
-	"net"
 	"net/http"
+	"recommendation-service/master/feedback"
 	"recommendation-service/master/safecounts"
+	"recommendation-service/master/userstore"
 	"recommendation-service/model"
 	"recommendation-service/syncutils"
-	"sort"
 	"sync"
 	"time"
 )
@@ -21,19 +21,77 @@ type Master struct {
 	movieTitles     []string
 	movieGenreNames []string
 	movieGenreIds   [][]int
-	modelConfig     model.ModelConfig
-	slaveIps        []string
-	slavesInfo      safecounts.SafeCounts
+	// modelMu protege las filas de Q y ItemBias, que el entrenamiento
+	// incremental reemplaza mientras se atienden pedidos.
+	modelMu        sync.RWMutex
+	modelConfig    model.ModelConfig
+	modelVersion   int
+	annConfig      *model.AnnConfig
+	slaveIps       []string
+	slavesInfo     safecounts.SafeCounts
+	users          *userstore.Store
+	feedbackConfig FeedbackConfig
+	events         *feedback.Log
+	// hello es el que se envía a los slaves; ModelVersion se completa al
+	// conectar.
+	hello syncutils.Hello
+	// Último modelo codificado para las sincronizaciones en chunks; se
+	// mantiene para retomar las transferencias cortadas.
+	transferMu    sync.Mutex
+	transfers     map[int]encodedTransfer
+	syncChunkSize int
+	sharding      *ShardingConfig
 }
 
 type MasterConfig struct {
-	SlaveIps        []string          `json:"slaveIps"`
-	MovieTitles     []string          `json:"movieTitles"`
-	MovieGenreNames []string          `json:"movieGenreNames"`
-	MovieGenreIds   [][]int           `json:"movieGenreIds"`
-	ModelConfig     model.ModelConfig `json:"modelConfig"`
+	SlaveIps        []string `json:"slaveIps"`
+	MovieTitles     []string `json:"movieTitles"`
+	MovieGenreNames []string `json:"movieGenreNames"`
+	MovieGenreIds   [][]int  `json:"movieGenreIds"`
+	// MovieIds es el id externo de cada título; si el modelo tiene ItemIds
+	// tienen que coincidir uno a uno.
+	MovieIds []string `json:"movieIds,omitempty"`
+	// MovieLensIds es el id original de MovieLens de cada título; importer.go
+	// reindexa las películas, así que MovieIds son los índices del CSV.
+	MovieLensIds []string          `json:"movieLensIds,omitempty"`
+	ModelConfig  model.ModelConfig `json:"modelConfig"`
+	// ModelFile, si se indica, reemplaza a ModelConfig por un modelo guardado
+	// aparte (binario o JSON).
+	ModelFile string `json:"modelFile,omitempty"`
+	// Ann configura el índice aproximado de los slaves; sin él recorren todo
+	// su rango de películas.
+	Ann *model.AnnConfig `json:"ann,omitempty"`
+	// UserStoreFile es el log de perfiles de usuario, por defecto
+	// data/users.log.
+	UserStoreFile string `json:"userStoreFile,omitempty"`
+	// Feedback configura el registro de POST /feedback y el entrenamiento
+	// incremental.
+	Feedback *FeedbackConfig `json:"feedback,omitempty"`
+	// Codecs son los codecs que se ofrecen a los slaves en orden de
+	// preferencia; por defecto binary, gob y json.
+	Codecs []string `json:"codecs,omitempty"`
+	// Compression son las compresiones que se ofrecen, por defecto deflate;
+	// una lista vacía la desactiva.
+	Compression []string `json:"compression,omitempty"`
+	// NodeId identifica al master en el hello, por defecto master-<ip>.
+	NodeId string `json:"nodeId,omitempty"`
+	// MinProtocolVersion rechaza a los slaves con un protocolo anterior; por
+	// defecto se habla con todos, incluso los que no envían hello.
+	MinProtocolVersion int `json:"minProtocolVersion,omitempty"`
+	// SyncChunkSize es el tamaño en bytes de los chunks de la sincronización
+	// completa, por defecto 1 MiB.
+	SyncChunkSize int `json:"syncChunkSize,omitempty"`
+	// MaxFrameSize es el mensaje más grande que se envía o acepta, por
+	// defecto 8 MiB más dos chunks; viaja en el hello para que los slaves
+	// usen el mismo límite.
+	MaxFrameSize int `json:"maxFrameSize,omitempty"`
+	// Sharding, si se indica, reparte Q entre los slaves en vez de enviarles
+	// el modelo completo a todos.
+	Sharding *ShardingConfig `json:"sharding,omitempty"`
 }
 
+const defaultUserStoreFile = "data/users.log"
+
 func (master *Master) handleSyncronization() {
 	log.Println("INFO: Start synchronization")
 	var wg sync.WaitGroup
@@ -76,50 +134,164 @@ func (master *Master) handleSyncronization() {
 	}
 }
 
+// Intentos de una sincronización completa; las transferencias en chunks se
+// retoman desde el último chunk confirmado.
+const syncAttempts = 3
+
+const syncTimeout = 20 * time.Second
+
 func (master *Master) handleSlaveSync(slaveId int, ip string) error {
+	var err error
+	for attempt := 1; attempt <= syncAttempts; attempt++ {
+		var resumable bool
+		resumable, err = master.syncSlave(slaveId, ip)
+		if err == nil {
+			master.slavesInfo.WriteStatusByIndex(true, slaveId)
+			log.Println("INFO: Slave", slaveId, "synchronized")
+			return nil
+		}
+		if !resumable || attempt == syncAttempts {
+			break
+		}
+		log.Printf("INFO: Slave %d: Sync attempt %d failed, retrying: %v", slaveId, attempt, err)
+		time.Sleep(time.Second)
+	}
+	master.slavesInfo.WriteStatusByIndex(false, slaveId)
+	return err
+}
 
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", ip, syncutils.SyncronizationPort))
+// syncSlave envía el modelo completo, en chunks si el slave lo soporta.
+// Indica si vale la pena reintentar enseguida, es decir si el slave
+// respondió el hello.
+func (master *Master) syncSlave(slaveId int, ip string) (bool, error) {
+	conn, err := master.dialSlave(ip, syncutils.SyncronizationPort)
 	if err != nil {
-		master.slavesInfo.WriteStatusByIndex(false, slaveId)
-		return fmt.Errorf("syncError: Slave %d connection error: %v", slaveId, err)
+		return false, fmt.Errorf("syncError: Slave %d connection error: %v", slaveId, err)
 	}
 	defer conn.Close()
 
-	timeout := 20 * time.Second
-	conn.SetDeadline(time.Now().Add(timeout))
+	conn.SetDeadline(time.Now().Add(syncTimeout))
+	log.Printf("INFO: Slave %d: Connected to %s, codec %s", slaveId, conn.Peer(), conn.Codec().Name())
 
-	err = master.sendSyncRequest(&conn)
-	if err != nil {
-		master.slavesInfo.WriteStatusByIndex(false, slaveId)
-		return fmt.Errorf("syncError: Slave %d sync request error: %v", slaveId, err)
+	shard := master.slaveShard(slaveId)
+	if shard != nil {
+		err = conn.CheckFeatures([]string{syncutils.FeatureSharding})
+		if err != nil {
+			return false, fmt.Errorf("syncError: Slave %d: %v", slaveId, err)
+		}
 	}
 	var response syncutils.SlaveSyncResponse
-	master.receiveSyncResponse(&conn, &response)
-	if response.Status == 0 {
-		master.slavesInfo.WriteStatusByIndex(true, slaveId)
+	if conn.Peer().Supports(syncutils.FeatureChunkedSync) {
+		err = master.sendModelChunks(conn, slaveId, shard, &response)
+		if err != nil {
+			return true, err
+		}
+	} else {
+		err = master.sendSyncRequest(conn, shard)
+		if err != nil {
+			return false, fmt.Errorf("syncError: Slave %d sync request error: %v", slaveId, err)
+		}
+		err = master.receiveSyncResponse(conn, &response)
+		if err != nil {
+			return false, fmt.Errorf("syncError: Slave %d sync response error: %v", slaveId, err)
+		}
+	}
+	if response.Status != syncutils.SyncStatusOk {
+		return response.Status == syncutils.SyncStatusDigestMismatch, fmt.Errorf("syncError: Slave %d rejected the model, status %d", slaveId, response.Status)
+	}
+	return false, nil
+}
+
+func (master *Master) loadHello(config *MasterConfig) error {
+	master.hello = syncutils.NewHello(config.NodeId)
+	if master.hello.NodeId == "" {
+		master.hello.NodeId = "master-" + master.ip
+	}
+	for _, name := range config.Codecs {
+		if syncutils.CodecByName(name) == nil {
+			return fmt.Errorf("Unknown codec %s", name)
+		}
+	}
+	if len(config.Codecs) > 0 {
+		master.hello.Codecs = config.Codecs
+	}
+	if config.Compression != nil {
+		master.hello.Compression = config.Compression
+	}
+	if config.MinProtocolVersion > syncutils.ProtocolVersion {
+		return fmt.Errorf("minProtocolVersion %d is newer than protocol %d", config.MinProtocolVersion, syncutils.ProtocolVersion)
+	}
+	if config.MinProtocolVersion > 0 {
+		master.hello.MinProtocolVersion = config.MinProtocolVersion
+	}
+	master.syncChunkSize = config.SyncChunkSize
+	if master.syncChunkSize == 0 {
+		master.syncChunkSize = syncutils.DefaultChunkSize
+	}
+	master.hello.MaxFrameSize = config.MaxFrameSize
+	if master.hello.MaxFrameSize == 0 {
+		master.hello.MaxFrameSize = syncutils.DefaultMaxFrameSize
+	}
+	if master.hello.MaxFrameSize < 0 {
+		return fmt.Errorf("Invalid maxFrameSize %d", config.MaxFrameSize)
+	}
+	if master.syncChunkSize < 0 || syncutils.ChunkFrameSize(master.syncChunkSize) > master.hello.MaxFrameSize {
+		return fmt.Errorf("Invalid syncChunkSize %d for maxFrameSize %d", config.SyncChunkSize, master.hello.MaxFrameSize)
+	}
+	return nil
+}
+
+// dialSlave conecta con un slave y hace el hello con la versión actual del
+// modelo.
+func (master *Master) dialSlave(ip string, port int) (*syncutils.Conn, error) {
+	hello := master.hello
+	master.modelMu.RLock()
+	hello.ModelVersion = master.modelVersion
+	master.modelMu.RUnlock()
+	hello.HasModel = true
+	return syncutils.DialNode(syncutils.JoinAddress(ip, port), hello)
+}
+
+func (master *Master) sendSyncRequest(conn *syncutils.Conn, shard *syncutils.Shard) error {
+	request := master.fullSyncRequest(shard)
+	err := conn.Send(&request)
+	if err != nil {
+		return fmt.Errorf("syncRequestErr: Error sending request object as json: %v", err)
 	}
-	log.Println("INFO: Slave", slaveId, "synchronized")
 	return nil
 }
 
-func (master *Master) sendSyncRequest(conn *net.Conn) error {
+// fullSyncRequest arma la sincronización completa con el modelo actual, o
+// solo con las películas de shard. Las filas de Q no se modifican una vez
+// publicadas, así que el pedido se puede codificar sin el lock.
+func (master *Master) fullSyncRequest(shard *syncutils.Shard) syncutils.MasterSyncRequest {
+	master.modelMu.RLock()
+	defer master.modelMu.RUnlock()
 	request := syncutils.MasterSyncRequest{
 		MasterIp:      master.ip,
 		MovieGenreIds: master.movieGenreIds,
 		ModelConfig:   master.modelConfig,
+		Ann:           master.annConfig,
+		ModelVersion:  master.modelVersion,
 	}
+	request.ModelConfig.Ratings = nil
 	request.ModelConfig.R = nil
 	request.ModelConfig.P = nil
-
-	err := syncutils.SendObjectAsJsonMessage(&request, conn)
-	if err != nil {
-		return fmt.Errorf("syncRequestErr: Error sending request object as json: %v", err)
-	}
-	return nil
+	request.ModelConfig.UserBias = nil
+	request.ModelConfig.UserIds = nil
+	request.ModelConfig.ItemIds = nil
+	if shard != nil {
+		movieIds := shard.MovieIds()
+		request.Shard = shard
+		request.ModelConfig.Q = shardRows(request.ModelConfig.Q, movieIds)
+		request.ModelConfig.ItemBias = shardRows(request.ModelConfig.ItemBias, movieIds)
+		request.MovieGenreIds = shardRows(request.MovieGenreIds, movieIds)
+	}
+	return request
 }
 
-func (master *Master) receiveSyncResponse(conn *net.Conn, response *syncutils.SlaveSyncResponse) error {
-	err := syncutils.ReceiveJsonMessageAsObject(response, conn)
+func (master *Master) receiveSyncResponse(conn *syncutils.Conn, response *syncutils.SlaveSyncResponse) error {
+	err := conn.Receive(response)
 	if err != nil {
 		return fmt.Errorf("syncResponseError: Error reading data: %v", err)
 	}
@@ -133,10 +305,48 @@ func (master *Master) loadConfig(filename string) error {
 		return fmt.Errorf("loadConfig: Error loading config file: %v", err)
 	}
 	master.slaveIps = config.SlaveIps
+	err = master.loadHello(&config)
+	if err != nil {
+		return fmt.Errorf("loadConfig: %v", err)
+	}
 	master.movieTitles = config.MovieTitles
 	master.movieGenreNames = config.MovieGenreNames
 	master.movieGenreIds = config.MovieGenreIds
+	err = master.loadSharding(&config)
+	if err != nil {
+		return fmt.Errorf("loadConfig: %v", err)
+	}
+	if config.ModelFile != "" {
+		config.ModelConfig, err = model.LoadModelFile(config.ModelFile)
+		if err != nil {
+			return fmt.Errorf("loadConfig: Error loading model file: %v", err)
+		}
+		log.Printf("INFO: Model loaded from %s\n", config.ModelFile)
+	}
+	err = ValidateMasterConfig(&config)
+	if err != nil {
+		return fmt.Errorf("loadConfig: Invalid config: %v", err)
+	}
 	master.modelConfig = config.ModelConfig
+	master.annConfig = config.Ann
+	if config.UserStoreFile == "" {
+		config.UserStoreFile = defaultUserStoreFile
+	}
+	master.users, err = userstore.Open(config.UserStoreFile)
+	if err != nil {
+		return fmt.Errorf("loadConfig: Error opening user store: %v", err)
+	}
+	log.Printf("INFO: %d user profiles loaded from %s\n", master.users.Len(), config.UserStoreFile)
+	if config.Feedback != nil {
+		master.feedbackConfig = *config.Feedback
+	}
+	if master.feedbackConfig.EventLogFile == "" {
+		master.feedbackConfig.EventLogFile = defaultEventLogFile
+	}
+	master.events, err = feedback.OpenLog(master.feedbackConfig.EventLogFile)
+	if err != nil {
+		return fmt.Errorf("loadConfig: Error opening feedback log: %v", err)
+	}
 	log.Println("INFO: Config loaded")
 	return nil
 }
@@ -160,6 +370,13 @@ func (master *Master) Run() error {
 	defer log.Println("INFO: Stopped")
 
 	master.handleSyncronization()
+	if master.feedbackConfig.TrainInterval > 0 {
+		if master.modelConfig.Algorithm == model.AlgorithmImplicitALS {
+			log.Println("INFO: Incremental training is not available for implicit feedback models")
+		} else {
+			go master.runIncrementalTrainer()
+		}
+	}
 	master.handleService()
 
 	return nil
@@ -173,6 +390,13 @@ func (master *Master) handleService() {
 	http.HandleFunc("/genres", master.genresHandler)
 	http.HandleFunc("/genres/movies", master.getMoviesByGenresHandler)
 	http.HandleFunc("/movies/genres", master.MoviesGenresHandler)
+	http.HandleFunc("GET /movies/{id}/similar", master.similarMoviesHandler)
+	http.HandleFunc("GET /users/{id}/ratings", master.userRatingsHandler)
+	http.HandleFunc("PUT /users/{id}/ratings/{movieId}", master.setUserRatingHandler)
+	http.HandleFunc("DELETE /users/{id}/ratings/{movieId}", master.deleteUserRatingHandler)
+	http.HandleFunc("DELETE /users/{id}", master.deleteUserHandler)
+	http.HandleFunc("GET /users/{id}/recommendations", master.userRecommendationsHandler)
+	http.HandleFunc("POST /feedback", master.feedbackHandler)
 
 	serviceAdress := syncutils.JoinAddress(master.ip, syncutils.ServicePort)
 
@@ -214,9 +438,15 @@ func (master *Master) handleRecommendation(apiResponse *http.ResponseWriter, api
 		Quantity: request.Quantity,
 		GenreIds: request.GenreIds,
 	}
-	moviesTitle := MoviesTitles{Title: master.movieTitles}
-
-	clientRecRequest.Ratings = MappRatingsClient(request.MoviesRatings, &moviesTitle)
+	clientRecRequest.Ratings = MappRatingsClient(request.MoviesRatings)
+	// Sin ratings se usa el historial guardado del usuario
+	profile, useProfile := master.users.Profile(request.UserId)
+	useProfile = useProfile && clientRecRequest.Ratings.Len() == 0
+	if useProfile {
+		clientRecRequest.Ratings = profileRatings(&profile)
+		clientRecRequest.UserFactors = profile.Factors
+		clientRecRequest.UserBias = profile.Bias
+	}
 
 	var response syncutils.MasterRecResponse
 	err = master.processRecommendationRequest(apiResponse, &response, &clientRecRequest)
@@ -224,6 +454,9 @@ func (master *Master) handleRecommendation(apiResponse *http.ResponseWriter, api
 		log.Printf("ERROR: %s: %v\n", handleRecommendationPrefix, err)
 		return
 	}
+	if useProfile {
+		master.saveUserFactors(&clientRecRequest)
+	}
 	err = respondRecommendationRequest(apiResponse, &response)
 	if err != nil {
 		log.Printf("ERROR: %s: %v\n", handleRecommendationPrefix, err)
@@ -251,9 +484,10 @@ func (master *Master) processRecommendationRequest(apiResponse *http.ResponseWri
 	var min float64
 	var count int
 
-	if len(request.Ratings) != len(master.modelConfig.Q) {
+	numMovies := len(master.movieTitles)
+	if n := request.Ratings.Len(); n > 0 && (request.Ratings.Indices[0] < 0 || request.Ratings.Indices[n-1] >= numMovies) {
 		http.Error(*apiResponse, "Invalid request payload", http.StatusBadRequest)
-		return fmt.Errorf("%s: Incorrect ratings quantity", processRecommendationRequestPrefix)
+		return fmt.Errorf("%s: Rated movie id out of range", processRecommendationRequestPrefix)
 	}
 
 	err := master.handleModelRecommendation(&predictions, &sum, &max, &min, &count, request)
@@ -315,44 +549,38 @@ func (master *Master) handleModelRecommendation(predictions *[]syncutils.Predict
 	beginStatus := master.slavesInfo.ReadStatus()
 	log.Printf("INFO: %s: Slaves status: %v", handleModelRecommendationPrefix, beginStatus)
 
-	nBatches := 0
-	for _, active := range beginStatus {
-		if active {
-			nBatches++
-		}
+	// ready se cierra cuando masterUserFactors tiene los factores finales
+	ready := make(chan struct{})
+	masterUserFactors := syncutils.MasterUserFactors{
+		UserId:      request.UserId,
+		UserFactors: request.UserFactors,
+		UserBias:    request.UserBias,
 	}
-	if nBatches == 0 {
-		return fmt.Errorf("RecRequestErr: No active slaves")
+	if len(masterUserFactors.UserFactors) != master.modelConfig.NumFeatures {
+		masterUserFactors.UserFactors = initializeUserFactors(master.modelConfig.NumFeatures)
+		masterUserFactors.UserBias = 0
 	}
-	log.Printf("INFO: %s: Created (%d) batches:", handleModelRecommendationPrefix, nBatches)
 
-	cond := sync.NewCond(&sync.Mutex{})
+	batches, slaveIds, err := master.planBatches(request.UserId, request.Ratings, request.Quantity, request.GenreIds, masterUserFactors.UserFactors)
+	if err != nil {
+		return fmt.Errorf("RecRequestErr: %v", err)
+	}
+	nBatches := len(batches)
+	log.Printf("INFO: %s: Created (%d) batches:", handleModelRecommendationPrefix, nBatches)
 	partialUserFactorsCh := make(chan *syncutils.SlavePartialUserFactors, nBatches)
 	partialRecommendationCh := make(chan *syncutils.SlaveRecResponse, nBatches)
-	masterUserFactors := syncutils.MasterUserFactors{
-		UserId:      request.UserId,
-		UserFactors: initializeUserFactors(master.modelConfig.NumFeatures),
+	for i := range batches {
+		batches[i].UserBias = masterUserFactors.UserBias
 	}
 
-	batches := master.createBatches(nBatches, request.UserId, request.Ratings, request.Quantity, request.GenreIds, masterUserFactors.UserFactors)
-
-	activeSlaveIds := master.slavesInfo.GetActiveIdsByStatus(true)
-
-	go func() {
-		for batchId, batch := range batches {
-			go func(batchId int) {
-				var slaveId int
-				if batchId < len(activeSlaveIds) {
-					slaveId = activeSlaveIds[batchId]
-				} else {
-					slaveId = master.slavesInfo.GetMinCountIdByStatus(true)
-				}
-				master.handleRecommendationRequestBatch(cond, partialUserFactorsCh, partialRecommendationCh, batchId, slaveId, &batch, &masterUserFactors)
-			}(batchId)
-		}
-	}()
+	for batchId := range batches {
+		go master.handleRecommendationRequestBatch(ready, partialUserFactorsCh, partialRecommendationCh, batchId, slaveIds[batchId], &batches[batchId], &masterUserFactors)
+	}
 
-	userFactorsGrads := make([]float64, master.modelConfig.NumFeatures)
+	numFeatures := master.modelConfig.NumFeatures
+	userFactorsGrads := make([]float64, numFeatures)
+	userBiasGrad := 0.0
+	var gram, rhs []float64
 	weightCount := 0
 
 	for i := 0; i < nBatches; i++ {
@@ -360,27 +588,58 @@ func (master *Master) handleModelRecommendation(predictions *[]syncutils.Predict
 		for j := range partialUserFactors.WeightedGrad {
 			userFactorsGrads[j] += partialUserFactors.WeightedGrad[j]
 		}
+		userBiasGrad += partialUserFactors.WeightedBiasGrad
+		if gram == nil && len(partialUserFactors.Gram) > 0 {
+			gram = make([]float64, len(partialUserFactors.Gram))
+			rhs = make([]float64, len(partialUserFactors.Rhs))
+		}
+		for j := range partialUserFactors.Gram {
+			gram[j] += partialUserFactors.Gram[j]
+		}
+		for j := range partialUserFactors.Rhs {
+			rhs[j] += partialUserFactors.Rhs[j]
+		}
 		weightCount += partialUserFactors.Count
 	}
 
-	if weightCount != 0 {
-		for i := range userFactorsGrads {
-			userFactorsGrads[i] /= float64(weightCount)
+	switch master.modelConfig.Algorithm {
+	case model.AlgorithmALS:
+		solution, err := model.SolveUserNormalEquations(gram, rhs, weightCount, master.modelConfig.Regularization)
+		if err != nil {
+			log.Printf("ERROR: %s: Error solving user factors: %v", handleModelRecommendationPrefix, err)
+			solution = make([]float64, numFeatures)
+		}
+		userFactorsGrads, userBiasGrad = model.SplitUserSolution(solution, numFeatures)
+	case model.AlgorithmImplicitALS:
+		solution, err := model.SolveImplicitUserNormalEquations(gram, rhs, master.modelConfig.Regularization)
+		if err != nil {
+			log.Printf("ERROR: %s: Error solving user factors: %v", handleModelRecommendationPrefix, err)
+			solution = make([]float64, numFeatures)
+		}
+		userFactorsGrads = solution
+	default:
+		if weightCount != 0 {
+			for i := range userFactorsGrads {
+				userFactorsGrads[i] /= float64(weightCount)
+			}
+			userBiasGrad /= float64(weightCount)
 		}
 	}
 
 	masterUserFactors.UserId = request.UserId
 	masterUserFactors.UserFactors = userFactorsGrads
+	masterUserFactors.UserBias = userBiasGrad
+	request.UserFactors = userFactorsGrads
+	request.UserBias = userBiasGrad
 
 	log.Printf("INFO: %s: User factors updated", handleModelRecommendationPrefix)
-	cond.L.Lock()
-	cond.Broadcast()
-	cond.L.Unlock()
+	close(ready)
 
+	partialPredictions := make([][]syncutils.Prediction, 0, nBatches)
 	for i := 0; i < nBatches; i++ {
 		partialRecommendation := <-partialRecommendationCh
 		if partialRecommendation.Count > 0 {
-			*predictions = append(*predictions, partialRecommendation.Predictions...)
+			partialPredictions = append(partialPredictions, partialRecommendation.Predictions)
 			*sum += partialRecommendation.Sum
 			*count += partialRecommendation.Count
 			if partialRecommendation.Max > *max {
@@ -389,77 +648,91 @@ func (master *Master) handleModelRecommendation(predictions *[]syncutils.Predict
 			if partialRecommendation.Min < *min {
 				*min = partialRecommendation.Min
 			}
-			if i > 0 {
-				sort.Slice(*predictions, func(i, j int) bool {
-					return (*predictions)[i].Rating > (*predictions)[j].Rating
-				})
-				if len(*predictions) > request.Quantity {
-					*predictions = (*predictions)[:request.Quantity]
-				}
-			}
 		}
 	}
+	// Cada slave responde sus predicciones ordenadas
+	*predictions = syncutils.MergeTopK(partialPredictions, request.Quantity)
 
 	return nil
 }
 
-func (master *Master) handleRecommendationRequestBatch(cond *sync.Cond, partialUserFactorsCh chan *syncutils.SlavePartialUserFactors, partialRecommendationCh chan *syncutils.SlaveRecResponse, batchId, slaveId int, batch *syncutils.MasterRecRequest, masterUserFactors *syncutils.MasterUserFactors) {
+// handleRecommendationRequestBatch atiende el batch con slaveId y, si falla,
+// lo reintenta con otro slave que pueda atenderlo. Si no queda ninguno el
+// batch se da por vacío y la recomendación queda incompleta.
+func (master *Master) handleRecommendationRequestBatch(ready <-chan struct{}, partialUserFactorsCh chan *syncutils.SlavePartialUserFactors, partialRecommendationCh chan *syncutils.SlaveRecResponse, batchId, slaveId int, batch *syncutils.MasterRecRequest, masterUserFactors *syncutils.MasterUserFactors) {
 	var err error
-	var conn net.Conn
+	var conn *syncutils.Conn
 	log.Printf("INFO: RequestBatch: Handling batch (%d).\n", batchId)
 	defer log.Printf("INFO: RequestBatch: Batch (%d) handled.\n", batchId)
+	factorsSent := false
 	for {
+		if slaveId == -1 {
+			log.Printf("ERROR: RequestBatchErr: No active slave for batch %d, recommendation will be incomplete", batchId)
+			if !factorsSent {
+				partialUserFactorsCh <- &syncutils.SlavePartialUserFactors{UserId: batch.UserId}
+			}
+			partialRecommendationCh <- &syncutils.SlaveRecResponse{}
+			return
+		}
 		log.Printf("INFO: Trying to connect batch to slaveId (%d)\n", slaveId)
-		conn, err = net.Dial("tcp", fmt.Sprintf("%s:%d", master.slaveIps[slaveId], syncutils.RecommendationPort))
+		conn, err = master.dialSlave(master.slaveIps[slaveId], syncutils.RecommendationPort)
 		if err != nil {
 			master.slavesInfo.WriteStatusByIndex(false, slaveId)
 			log.Printf("ERROR: RequestBatchErr: Error connecting to slave node %d for batch %d: %v", slaveId, batchId, err)
 			log.Printf("Slaves status: %v", master.slavesInfo.ReadStatus())
-			slaveId = master.slavesInfo.GetMinCountIdByStatus(true)
+			slaveId = master.pickSlave(batchId)
 			continue
 		}
 		timeout := 20 * time.Second
 		conn.SetDeadline(time.Now().Add(timeout))
 
-		err = master.handlePartialRecommendation(&conn, cond, partialUserFactorsCh, partialRecommendationCh, slaveId, batchId, batch, masterUserFactors)
+		err = master.handlePartialRecommendation(conn, ready, partialUserFactorsCh, partialRecommendationCh, slaveId, batchId, batch, masterUserFactors, &factorsSent)
+		conn.Close()
 		if err != nil {
 			log.Println("ERROR: RequestBatchErr: ", err)
 			master.slavesInfo.WriteStatusByIndex(false, slaveId)
-			slaveId = master.slavesInfo.GetMinCountIdByStatus(true)
+			slaveId = master.pickSlave(batchId)
 			continue
 		}
 		break
 	}
 }
 
-func (master *Master) handlePartialRecommendation(conn *net.Conn, cond *sync.Cond, partialUserFactorsCh chan *syncutils.SlavePartialUserFactors, partialRecommendationCh chan *syncutils.SlaveRecResponse, slaveId, batchId int, batch *syncutils.MasterRecRequest, masterUserFactors *syncutils.MasterUserFactors) error {
+// handlePartialRecommendation hace el intercambio completo con un slave. Si
+// es un reintento y los factores parciales ya se entregaron, los del nuevo
+// slave se descartan.
+func (master *Master) handlePartialRecommendation(conn *syncutils.Conn, ready <-chan struct{}, partialUserFactorsCh chan *syncutils.SlavePartialUserFactors, partialRecommendationCh chan *syncutils.SlaveRecResponse, slaveId, batchId int, batch *syncutils.MasterRecRequest, masterUserFactors *syncutils.MasterUserFactors, factorsSent *bool) error {
+	err := conn.CheckFeatures(batch.RequiredFeatures())
+	if err != nil {
+		return fmt.Errorf("partialRecommendErr: Slave node (%d): %v", slaveId, err)
+	}
 	// sendRequest
-	err := syncutils.SendObjectAsJsonMessage(batch, conn)
+	err = conn.Send(batch)
 	if err != nil {
 		return fmt.Errorf("partialRecommendErr: Error sending batch to slave node (%d) for batch (%d): %v", slaveId, batchId, err)
 	}
 	// ReceivePartialUserFactors
 	var partialUserFactors syncutils.SlavePartialUserFactors
-	err = syncutils.ReceiveJsonMessageAsObject(&partialUserFactors, conn)
+	err = conn.Receive(&partialUserFactors)
 	if err != nil {
 		return fmt.Errorf("partialRecommendErr: Error receiving partial user factors from slave node (%d): %v", slaveId, err)
 	}
 
-	partialUserFactorsCh <- &partialUserFactors
-
-	cond.L.Lock()
-	cond.Wait()
-	cond.L.Unlock()
+	if !*factorsSent {
+		partialUserFactorsCh <- &partialUserFactors
+		*factorsSent = true
+	}
+	<-ready
 
 	// SendUserFactors
-	err = syncutils.SendObjectAsJsonMessage(masterUserFactors, conn)
+	err = conn.Send(masterUserFactors)
 	if err != nil {
 		return fmt.Errorf("partialRecommendErr: Error sending user factors to slave node (%d): %v", slaveId, err)
 	}
 
 	// ReceivePartialRecommendation
 	var response syncutils.SlaveRecResponse
-	err = syncutils.ReceiveJsonMessageAsObject(&response, conn)
+	err = conn.Receive(&response)
 	if err != nil {
 		return fmt.Errorf("partialRecommendErr: Error receiving response from slave node (%d): %v", slaveId, err)
 	}
@@ -467,7 +740,91 @@ func (master *Master) handlePartialRecommendation(conn *net.Conn, cond *sync.Con
 	return nil
 }
 
-func (master *Master) createBatches(nBatches, userId int, ratings []float64, quantity int, genreIds []int, userFactors []float64) []syncutils.MasterRecRequest {
+const handleModelSimilarPrefix = "handleModelSimilar"
+
+// handleModelSimilar reparte la búsqueda de las k películas más parecidas a
+// movieId entre los slaves activos, cada uno sobre su rango de películas, y
+// junta los resultados parciales.
+func (master *Master) handleModelSimilar(movieId, k int, genreIds []int) ([]syncutils.Prediction, error) {
+	log.Printf("INFO: %s: Handling similar movies of %d", handleModelSimilarPrefix, movieId)
+	defer log.Printf("INFO: %s: Similar movies handled", handleModelSimilarPrefix)
+
+	batches, slaveIds, err := master.planBatches(0, model.SparseVector{}, k, genreIds, nil)
+	if err != nil {
+		return nil, fmt.Errorf("similarRequestErr: %v", err)
+	}
+	nBatches := len(batches)
+	master.modelMu.RLock()
+	movieFactors := master.modelConfig.Q[movieId]
+	master.modelMu.RUnlock()
+	responseCh := make(chan *syncutils.SlaveRecResponse, nBatches)
+	for batchId := range batches {
+		batches[batchId].Type = syncutils.RequestSimilar
+		batches[batchId].MovieId = movieId
+		batches[batchId].MovieFactors = movieFactors
+		go func(batchId int) {
+			responseCh <- master.handleSimilarRequestBatch(batchId, slaveIds[batchId], &batches[batchId])
+		}(batchId)
+	}
+
+	partialPredictions := make([][]syncutils.Prediction, 0, nBatches)
+	failed := 0
+	for range batches {
+		response := <-responseCh
+		if response == nil {
+			failed++
+			continue
+		}
+		partialPredictions = append(partialPredictions, response.Predictions)
+	}
+	if failed > 0 {
+		return nil, fmt.Errorf("similarRequestErr: %d of %d batches failed", failed, nBatches)
+	}
+	predictions := syncutils.MergeTopK(partialPredictions, k)
+	return predictions, nil
+}
+
+// handleSimilarRequestBatch envía el batch al slave y, si falla, lo reintenta
+// con el resto de los slaves que pueden atenderlo. Devuelve nil si no queda
+// ninguno.
+func (master *Master) handleSimilarRequestBatch(batchId, slaveId int, batch *syncutils.MasterRecRequest) *syncutils.SlaveRecResponse {
+	for slaveId != -1 {
+		response, err := master.requestSimilarBatch(slaveId, batch)
+		if err == nil {
+			return response
+		}
+		log.Printf("ERROR: %s: Batch (%d): %v", handleModelSimilarPrefix, batchId, err)
+		master.slavesInfo.WriteStatusByIndex(false, slaveId)
+		slaveId = master.pickSlave(batchId)
+	}
+	return nil
+}
+
+func (master *Master) requestSimilarBatch(slaveId int, batch *syncutils.MasterRecRequest) (*syncutils.SlaveRecResponse, error) {
+	conn, err := master.dialSlave(master.slaveIps[slaveId], syncutils.RecommendationPort)
+	if err != nil {
+		return nil, fmt.Errorf("similarBatchErr: Error connecting to slave node (%d): %v", slaveId, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(20 * time.Second))
+
+	err = conn.CheckFeatures(batch.RequiredFeatures())
+	if err != nil {
+		return nil, fmt.Errorf("similarBatchErr: Slave node (%d): %v", slaveId, err)
+	}
+	err = conn.Send(batch)
+	if err != nil {
+		return nil, fmt.Errorf("similarBatchErr: Error sending batch to slave node (%d): %v", slaveId, err)
+	}
+	var response syncutils.SlaveRecResponse
+	err = conn.Receive(&response)
+	if err != nil {
+		return nil, fmt.Errorf("similarBatchErr: Error receiving response from slave node (%d): %v", slaveId, err)
+	}
+	return &response, nil
+}
+
+func (master *Master) createBatches(nBatches, userId int, ratings model.SparseVector, quantity int, genreIds []int, userFactors []float64) []syncutils.MasterRecRequest {
 	batches := make([]syncutils.MasterRecRequest, nBatches)
 	var rangeSize int = len(master.movieTitles) / nBatches
 	var startMovieId int = 0
@@ -478,7 +835,7 @@ func (master *Master) createBatches(nBatches, userId int, ratings []float64, qua
 		}
 		batches[i] = syncutils.MasterRecRequest{
 			UserId:       userId,
-			UserRatings:  ratings[startMovieId:endMovieId],
+			UserRatings:  ratings.Range(startMovieId, endMovieId),
 			StartMovieId: startMovieId,
 			EndMovieId:   endMovieId,
 			Quantity:     quantity,
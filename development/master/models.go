@@ -40,3 +40,31 @@ type ClientRecToSend struct {
 	GenreIds      []int                `json:"genreIds"`
 	MoviesRatings []MovieRatingsClient `json:"moviesRatings"`
 }
+
+type SimilarMovie struct {
+	Id         int      `json:"id"`
+	Title      string   `json:"title"`
+	Genres     []string `json:"genres"`
+	Similarity float64  `json:"similarity"`
+}
+
+type MasterSimilarResponse struct {
+	MovieId int            `json:"movieId"`
+	Title   string         `json:"title"`
+	Similar []SimilarMovie `json:"similar"`
+}
+
+type UserRating struct {
+	MovieId int     `json:"movieId"`
+	Title   string  `json:"title"`
+	Rating  float64 `json:"rating"`
+}
+
+type UserRatings struct {
+	UserId  int          `json:"userId"`
+	Ratings []UserRating `json:"ratings"`
+}
+
+type SetRatingRequest struct {
+	Rating float64 `json:"rating"`
+}
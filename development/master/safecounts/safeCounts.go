@@ -107,3 +107,19 @@ func (sd *SafeCounts) GetActiveIdsByStatus(status bool) []int {
 	}
 	return ids
 }
+
+func (sd *SafeCounts) GetMinCountIdByStatusAmong(status bool, ids []int) int {
+	sd.CountsMu.RLock()
+	defer sd.CountsMu.RUnlock()
+	sd.StatusMu.RLock()
+	defer sd.StatusMu.RUnlock()
+	min := 0
+	minIndex := -1
+	for _, i := range ids {
+		if (minIndex == -1 || sd.Counts[i] < min) && sd.Status[i] == status {
+			min = sd.Counts[i]
+			minIndex = i
+		}
+	}
+	return minIndex
+}
@@ -0,0 +1,118 @@
+package master
+
+import (
+	"fmt"
+	"recommendation-service/model"
+	"recommendation-service/syncutils"
+)
+
+// ShardingConfig reparte las películas entre los slaves: cada uno recibe solo
+// las filas de Q de su shard. El slave i tiene el shard i % NumShards, así
+// con menos shards que slaves cada shard queda replicado.
+type ShardingConfig struct {
+	// Mode es range (rangos contiguos) o hash
+	Mode string `json:"mode"`
+	// NumShards, por defecto uno por slave
+	NumShards int `json:"numShards,omitempty"`
+}
+
+func (master *Master) loadSharding(config *MasterConfig) error {
+	if config.Sharding == nil {
+		return nil
+	}
+	sharding := *config.Sharding
+	if sharding.Mode != syncutils.ShardingRange && sharding.Mode != syncutils.ShardingHash {
+		return fmt.Errorf("Unknown sharding mode %q", sharding.Mode)
+	}
+	if sharding.NumShards == 0 {
+		sharding.NumShards = len(config.SlaveIps)
+	}
+	if sharding.NumShards <= 0 || sharding.NumShards > len(config.SlaveIps) {
+		return fmt.Errorf("Invalid number of shards %d for %d slaves", sharding.NumShards, len(config.SlaveIps))
+	}
+	master.sharding = &sharding
+	return nil
+}
+
+// slaveShard devuelve el shard del slave, nil si no hay sharding.
+func (master *Master) slaveShard(slaveId int) *syncutils.Shard {
+	if master.sharding == nil {
+		return nil
+	}
+	return &syncutils.Shard{
+		Mode:      master.sharding.Mode,
+		Index:     slaveId % master.sharding.NumShards,
+		NumShards: master.sharding.NumShards,
+		NumMovies: len(master.movieTitles),
+	}
+}
+
+// pickSlave elige el slave activo para el batch: en modo sharding uno de los
+// dueños del shard batchId, si no cualquiera. Devuelve -1 si no hay.
+func (master *Master) pickSlave(batchId int) int {
+	if master.sharding == nil {
+		return master.slavesInfo.GetMinCountIdByStatus(true)
+	}
+	var owners []int
+	for slaveId := batchId; slaveId < len(master.slaveIps); slaveId += master.sharding.NumShards {
+		owners = append(owners, slaveId)
+	}
+	return master.slavesInfo.GetMinCountIdByStatusAmong(true, owners)
+}
+
+// planBatches arma los batches de un pedido y el slave de cada uno. Sin
+// sharding hay un batch por slave activo sobre rangos de películas; con
+// sharding uno por shard, con los ratings de sus películas.
+func (master *Master) planBatches(userId int, ratings model.SparseVector, quantity int, genreIds []int, userFactors []float64) ([]syncutils.MasterRecRequest, []int, error) {
+	if master.sharding == nil {
+		activeSlaveIds := master.slavesInfo.GetActiveIdsByStatus(true)
+		if len(activeSlaveIds) == 0 {
+			return nil, nil, fmt.Errorf("No active slaves")
+		}
+		return master.createBatches(len(activeSlaveIds), userId, ratings, quantity, genreIds, userFactors), activeSlaveIds, nil
+	}
+
+	batches := make([]syncutils.MasterRecRequest, master.sharding.NumShards)
+	slaveIds := make([]int, master.sharding.NumShards)
+	for i := range batches {
+		slaveIds[i] = master.pickSlave(i)
+		if slaveIds[i] == -1 {
+			return nil, nil, fmt.Errorf("No active slaves for shard %d", i)
+		}
+		shard := master.slaveShard(i)
+		start, end := shard.Bounds()
+		batches[i] = syncutils.MasterRecRequest{
+			UserId:       userId,
+			UserRatings:  shard.Ratings(ratings),
+			StartMovieId: start,
+			EndMovieId:   end,
+			Quantity:     quantity,
+			GenreIds:     genreIds,
+			UserFactors:  userFactors,
+		}
+	}
+	return batches, slaveIds, nil
+}
+
+// shardRows devuelve las filas de rows de las películas del shard.
+func shardRows[T any](rows []T, movieIds []int) []T {
+	if rows == nil {
+		return nil
+	}
+	result := make([]T, len(movieIds))
+	for i, movieId := range movieIds {
+		result[i] = rows[movieId]
+	}
+	return result
+}
+
+// shardItemUpdates deja solo las actualizaciones de películas del shard.
+func shardItemUpdates(updates []syncutils.ItemUpdate, shard *syncutils.Shard) []syncutils.ItemUpdate {
+	result := []syncutils.ItemUpdate{}
+	for _, update := range updates {
+		if shard.Contains(update.MovieId) {
+			result = append(result, update)
+		}
+	}
+	return result
+}
@@ -0,0 +1,327 @@
+package master
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"recommendation-service/master/feedback"
+	"recommendation-service/model"
+	"recommendation-service/syncutils"
+	"sort"
+	"time"
+)
+
+type FeedbackConfig struct {
+	// Registro de eventos, por defecto data/feedback.log
+	EventLogFile string `json:"eventLogFile,omitempty"`
+	// Segundos entre rondas de entrenamiento incremental; 0 lo desactiva y
+	// los eventos solo se registran. Cada ronda entrena solo con los ratings
+	// registrados desde la anterior; los clicks solo se registran, no se usan
+	// para entrenar.
+	TrainInterval int                     `json:"trainInterval,omitempty"`
+	Training      model.IncrementalConfig `json:"training"`
+}
+
+const defaultEventLogFile = "data/feedback.log"
+
+type FeedbackRequest struct {
+	UserId  int     `json:"userId"`
+	MovieId int     `json:"movieId"`
+	Type    string  `json:"type"`
+	Rating  float64 `json:"rating"`
+}
+
+const feedbackHandlerPrefix = "feedbackHandler"
+
+// feedbackHandler atiende POST /feedback. Los ratings también se guardan en el
+// historial del usuario; los clicks solo quedan registrados.
+func (master *Master) feedbackHandler(w http.ResponseWriter, r *http.Request) {
+	var request FeedbackRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if request.Type == "" {
+		request.Type = feedback.EventRating
+	}
+	if request.MovieId < 0 || request.MovieId >= len(master.movieTitles) {
+		http.Error(w, "Película no encontrada", http.StatusNotFound)
+		return
+	}
+	switch request.Type {
+	case feedback.EventRating:
+		if request.Rating <= 0 || math.IsInf(request.Rating, 0) || math.IsNaN(request.Rating) {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+	case feedback.EventClick:
+		request.Rating = 0
+	default:
+		http.Error(w, "Tipo de evento desconocido: "+request.Type, http.StatusBadRequest)
+		return
+	}
+
+	event := feedback.Event{
+		UserId:  request.UserId,
+		MovieId: request.MovieId,
+		Type:    request.Type,
+		Rating:  request.Rating,
+		Time:    time.Now().Unix(),
+	}
+	err = master.events.Append(event)
+	if err == nil && event.Type == feedback.EventRating {
+		err = master.users.SetRating(event.UserId, event.MovieId, event.Rating)
+	}
+	if err != nil {
+		log.Printf("ERROR: %s: %v", feedbackHandlerPrefix, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+const incrementalTrainerPrefix = "incrementalTrainer"
+
+// maxIncrementalFailures es la cantidad de rondas seguidas que pueden fallar
+// con los mismos eventos antes de descartarlos; entre una y otra la espera se
+// duplica.
+const maxIncrementalFailures = 3
+
+// runIncrementalTrainer entrena cada TrainInterval segundos con los eventos
+// nuevos. Q se carga del modelo entrenado sin el feedback, así que la primera
+// ronda recorre el registro completo una vez para volver a incluir el
+// feedback anterior a un reinicio.
+func (master *Master) runIncrementalTrainer() {
+	config := master.feedbackConfig
+	log.Printf("INFO: %s: Training every %d seconds", incrementalTrainerPrefix, config.TrainInterval)
+
+	// El trainer trabaja sobre su propia copia de Q; el master solo ve las
+	// filas nuevas cuando se publican.
+	master.modelMu.RLock()
+	trainedConfig := master.modelConfig
+	trainedConfig.Q = copyMatrix(master.modelConfig.Q)
+	trainedConfig.ItemBias = append([]float64(nil), master.modelConfig.ItemBias...)
+	master.modelMu.RUnlock()
+	trained := model.LoadModel(&trainedConfig)
+
+	offset := int64(0)
+	failures := 0
+	for round := 1; ; round++ {
+		time.Sleep(time.Duration(config.TrainInterval) * time.Second << failures)
+		offset, failures = master.incrementalStep(&trained, offset, round, failures)
+	}
+}
+
+// incrementalStep lee los eventos desde offset y corre una ronda con ellos.
+// Devuelve el offset y los fallos seguidos para la próxima: si la ronda falla
+// se reintenta con los mismos eventos hasta maxIncrementalFailures veces y
+// después se descartan.
+func (master *Master) incrementalStep(trained *model.Model, offset int64, round, failures int) (int64, int) {
+	events, next, invalid, err := master.events.ReadFrom(offset)
+	if err != nil {
+		log.Printf("ERROR: %s: %v", incrementalTrainerPrefix, err)
+		return offset, min(failures+1, maxIncrementalFailures)
+	}
+	if invalid > 0 {
+		log.Printf("ERROR: %s: Skipped %d invalid events between offsets %d and %d", incrementalTrainerPrefix, invalid, offset, next)
+	}
+	err = master.incrementalRound(trained, events, round)
+	if err == nil {
+		return next, 0
+	}
+	failures++
+	if failures < maxIncrementalFailures {
+		log.Printf("ERROR: %s: %v (attempt %d of %d)", incrementalTrainerPrefix, err, failures, maxIncrementalFailures)
+		return offset, failures
+	}
+	log.Printf("ERROR: %s: %v, skipping %d events between offsets %d and %d after %d attempts", incrementalTrainerPrefix, err, len(events), offset, next, failures)
+	return next, 0
+}
+
+func (master *Master) incrementalRound(trained *model.Model, events []feedback.Event, round int) error {
+	// Si el usuario calificó la misma película más de una vez en la ronda
+	// cuenta el último rating. Los eventos que no se pueden usar (por ejemplo
+	// de películas que ya no están en el catálogo) se descartan.
+	newRatings := map[int]map[int]float64{}
+	skipped := 0
+	for _, event := range events {
+		if event.Type != feedback.EventRating {
+			continue
+		}
+		if !validRating(trained, event.MovieId, event.Rating) {
+			skipped++
+			continue
+		}
+		if newRatings[event.UserId] == nil {
+			newRatings[event.UserId] = map[int]float64{}
+		}
+		newRatings[event.UserId][event.MovieId] = event.Rating
+	}
+	if skipped > 0 {
+		log.Printf("ERROR: %s: Skipped %d ratings with unknown movies or invalid values", incrementalTrainerPrefix, skipped)
+	}
+	if len(newRatings) == 0 {
+		return nil
+	}
+
+	users := make([]model.UserHistory, 0, len(newRatings))
+	for userId, ratings := range newRatings {
+		profile, ok := master.users.Profile(userId)
+		if !ok || len(profile.Ratings) == 0 {
+			continue
+		}
+		history := map[int]float64{}
+		for movieId, rating := range profile.Ratings {
+			if validRating(trained, movieId, rating) {
+				history[movieId] = rating
+			}
+		}
+		users = append(users, model.UserHistory{
+			UserId:  userId,
+			Ratings: sparseRatings(history),
+			New:     sparseRatings(ratings),
+			Factors: profile.Factors,
+			Bias:    profile.Bias,
+		})
+	}
+	config := master.feedbackConfig.Training
+	config.RandomState += round
+	start := time.Now()
+	changed, err := trained.UpdateFromFeedback(users, config)
+	if err != nil {
+		return fmt.Errorf("Error updating model: %v", err)
+	}
+	for _, user := range users {
+		err = master.users.SetFactors(user.UserId, user.Factors, user.Bias)
+		if err != nil {
+			log.Printf("ERROR: %s: Error saving user factors: %v", incrementalTrainerPrefix, err)
+		}
+	}
+
+	updates := make([]syncutils.ItemUpdate, len(changed))
+	for i, movieId := range changed {
+		updates[i] = syncutils.ItemUpdate{MovieId: movieId, Factors: append([]float64(nil), trained.Q[movieId]...)}
+		if trained.ItemBias != nil {
+			updates[i].Bias = trained.ItemBias[movieId]
+		}
+	}
+	baseVersion, version := master.publishItemUpdates(updates)
+	log.Printf("INFO: %s: %d events, %d users, %d movies updated in %v, model version %d", incrementalTrainerPrefix, len(events), len(users), len(changed), time.Since(start), version)
+	master.pushItemUpdates(baseVersion, version, updates)
+	return nil
+}
+
+// validRating indica si el rating se puede usar para entrenar el modelo.
+func validRating(trained *model.Model, movieId int, rating float64) bool {
+	return movieId >= 0 && movieId < len(trained.Q) && rating > 0 && !math.IsInf(rating, 0) && !math.IsNaN(rating)
+}
+
+// publishItemUpdates reemplaza las filas de Q del master (sin modificar las
+// anteriores, que pueden estar en uso) y avanza la versión del modelo.
+func (master *Master) publishItemUpdates(updates []syncutils.ItemUpdate) (int, int) {
+	master.modelMu.Lock()
+	defer master.modelMu.Unlock()
+	q := append([][]float64(nil), master.modelConfig.Q...)
+	var itemBias []float64
+	if master.modelConfig.ItemBias != nil {
+		itemBias = append([]float64(nil), master.modelConfig.ItemBias...)
+	}
+	for _, update := range updates {
+		q[update.MovieId] = update.Factors
+		if itemBias != nil {
+			itemBias[update.MovieId] = update.Bias
+		}
+	}
+	master.modelConfig.Q = q
+	master.modelConfig.ItemBias = itemBias
+	baseVersion := master.modelVersion
+	master.modelVersion++
+	return baseVersion, master.modelVersion
+}
+
+const pushItemUpdatesPrefix = "pushItemUpdates"
+
+// pushItemUpdates envía las filas nuevas a los slaves activos por el canal de
+// sincronización (en modo sharding solo las de su shard, aunque sean
+// ninguna, para que avancen de versión); los que no pueden aplicarlas
+// reciben el modelo completo.
+func (master *Master) pushItemUpdates(baseVersion, version int, updates []syncutils.ItemUpdate) {
+	request := syncutils.MasterSyncRequest{
+		MasterIp:     master.ip,
+		ModelVersion: version,
+		Delta:        true,
+		BaseVersion:  baseVersion,
+		ItemUpdates:  updates,
+	}
+	for _, slaveId := range master.slavesInfo.GetActiveIdsByStatus(true) {
+		slaveRequest := request
+		if shard := master.slaveShard(slaveId); shard != nil {
+			slaveRequest.ItemUpdates = shardItemUpdates(updates, shard)
+		}
+		status, err := master.sendItemUpdates(slaveId, &slaveRequest)
+		if err == nil && status == syncutils.SyncStatusOk {
+			continue
+		}
+		if err != nil {
+			log.Printf("ERROR: %s: Slave %d: %v", pushItemUpdatesPrefix, slaveId, err)
+		}
+		log.Printf("INFO: %s: Slave %d: Sending full model", pushItemUpdatesPrefix, slaveId)
+		err = master.handleSlaveSync(slaveId, master.slaveIps[slaveId])
+		if err != nil {
+			log.Printf("ERROR: %s: %v", pushItemUpdatesPrefix, err)
+		}
+	}
+}
+
+func (master *Master) sendItemUpdates(slaveId int, request *syncutils.MasterSyncRequest) (int, error) {
+	conn, err := master.dialSlave(master.slaveIps[slaveId], syncutils.SyncronizationPort)
+	if err != nil {
+		return 0, fmt.Errorf("syncError: Slave %d connection error: %v", slaveId, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(20 * time.Second))
+
+	// Si el hello ya dice que el slave no puede aplicar la actualización se
+	// pasa directo al modelo completo.
+	peer := conn.Peer()
+	if !peer.Supports(syncutils.FeatureDelta) {
+		return syncutils.SyncStatusNeedFull, nil
+	}
+	if peer.Version() >= 3 && (!peer.HasModel || peer.ModelVersion != request.BaseVersion) {
+		return syncutils.SyncStatusNeedFull, nil
+	}
+	err = conn.Send(request)
+	if err != nil {
+		return 0, fmt.Errorf("syncError: Slave %d update request error: %v", slaveId, err)
+	}
+	var response syncutils.SlaveSyncResponse
+	err = master.receiveSyncResponse(conn, &response)
+	if err != nil {
+		return 0, fmt.Errorf("syncError: Slave %d update response error: %v", slaveId, err)
+	}
+	return response.Status, nil
+}
+
+// sparseRatings ordena los ratings por película.
+func sparseRatings(ratings map[int]float64) model.SparseVector {
+	vector := model.SparseVector{Indices: make([]int, 0, len(ratings)), Values: make([]float64, 0, len(ratings))}
+	for movieId := range ratings {
+		vector.Indices = append(vector.Indices, movieId)
+	}
+	sort.Ints(vector.Indices)
+	for _, movieId := range vector.Indices {
+		vector.Values = append(vector.Values, ratings[movieId])
+	}
+	return vector
+}
+
+func copyMatrix(matrix [][]float64) [][]float64 {
+	copied := make([][]float64, len(matrix))
+	for i, row := range matrix {
+		copied[i] = append([]float64(nil), row...)
+	}
+	return copied
+}
@@ -0,0 +1,117 @@
+package master
+
+import (
+	"os"
+	"path/filepath"
+	"recommendation-service/master/feedback"
+	"recommendation-service/master/userstore"
+	"recommendation-service/model"
+	"testing"
+)
+
+// newTrainerMaster arma un master sin slaves con el registro de eventos y los
+// perfiles en dir y un modelo de 3 películas.
+func newTrainerMaster(t *testing.T, dir string, algorithm string) (*Master, model.Model) {
+	t.Helper()
+	events, err := feedback.OpenLog(filepath.Join(dir, "feedback.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { events.Close() })
+	users, err := userstore.Open(filepath.Join(dir, "users.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { users.Close() })
+	master := &Master{
+		events: events,
+		users:  users,
+		modelConfig: model.ModelConfig{
+			NumFeatures:    2,
+			LearningRate:   0.01,
+			Regularization: 0.01,
+			Algorithm:      algorithm,
+			Q:              [][]float64{{0.1, 0.2}, {0.3, -0.1}, {-0.2, 0.4}},
+		},
+	}
+	trainedConfig := master.modelConfig
+	trainedConfig.Q = copyMatrix(master.modelConfig.Q)
+	return master, model.LoadModel(&trainedConfig)
+}
+
+// appendRating registra el rating como lo hace feedbackHandler.
+func appendRating(t *testing.T, master *Master, userId, movieId int, rating float64) {
+	t.Helper()
+	err := master.events.Append(feedback.Event{UserId: userId, MovieId: movieId, Type: feedback.EventRating, Rating: rating})
+	if err == nil {
+		err = master.users.SetRating(userId, movieId, rating)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func logSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(dir, "feedback.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+// TestIncrementalStepSkipsPoisonedEvents comprueba que una línea ilegible y un
+// rating de una película fuera del modelo no traben al trainer: la ronda
+// avanza hasta el final del registro y entrena con el rating válido.
+func TestIncrementalStepSkipsPoisonedEvents(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "feedback.log"), []byte("{not json\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, trained := newTrainerMaster(t, dir, model.AlgorithmSGD)
+	appendRating(t, master, 1, 7, 4)
+	appendRating(t, master, 1, 1, 5)
+
+	offset, failures := master.incrementalStep(&trained, 0, 1, 0)
+	if offset != logSize(t, dir) || failures != 0 {
+		t.Fatalf("got offset %d and %d failures, want %d and 0", offset, failures, logSize(t, dir))
+	}
+	if master.modelVersion != 1 {
+		t.Errorf("model version %d, want 1", master.modelVersion)
+	}
+	if master.modelConfig.Q[1][0] == 0.3 && master.modelConfig.Q[1][1] == -0.1 {
+		t.Errorf("movie 1 was not updated")
+	}
+	if profile, _ := master.users.Profile(1); len(profile.Factors) != 2 {
+		t.Errorf("user factors %v were not saved", profile.Factors)
+	}
+}
+
+// TestIncrementalStepSkipsAfterFailures comprueba que una ronda que falla
+// siempre (un modelo implícito no se actualiza) se reintenta
+// maxIncrementalFailures veces y después se descarta.
+func TestIncrementalStepSkipsAfterFailures(t *testing.T) {
+	dir := t.TempDir()
+	master, trained := newTrainerMaster(t, dir, model.AlgorithmImplicitALS)
+	appendRating(t, master, 1, 0, 3)
+
+	offset, failures := int64(0), 0
+	for attempt := 1; attempt < maxIncrementalFailures; attempt++ {
+		offset, failures = master.incrementalStep(&trained, offset, attempt, failures)
+		if offset != 0 || failures != attempt {
+			t.Fatalf("attempt %d: got offset %d and %d failures, want 0 and %d", attempt, offset, failures, attempt)
+		}
+	}
+	offset, failures = master.incrementalStep(&trained, offset, maxIncrementalFailures, failures)
+	if offset != logSize(t, dir) || failures != 0 {
+		t.Fatalf("got offset %d and %d failures, want %d and 0", offset, failures, logSize(t, dir))
+	}
+
+	// Los eventos siguientes se leen desde el nuevo offset
+	appendRating(t, master, 2, 1, 4)
+	next, _ := master.incrementalStep(&trained, offset, maxIncrementalFailures+1, 0)
+	if next != offset {
+		t.Errorf("offset moved to %d on a failing round", next)
+	}
+}
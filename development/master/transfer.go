@@ -0,0 +1,111 @@
+package master
+
+import (
+	"fmt"
+	"log"
+	"recommendation-service/syncutils"
+	"time"
+)
+
+// Reenvíos de un mismo chunk antes de abandonar la transferencia
+const maxChunkRetries = 3
+
+// El slave carga el modelo y arma el índice antes de confirmar el último
+// chunk, eso puede llevar más que un chunk.
+const syncFinishTimeout = 5 * time.Minute
+
+type encodedTransfer struct {
+	version int
+	model   *syncutils.EncodedModel
+}
+
+// encodedModel devuelve el modelo actual (o el de shard) codificado con
+// codecName, reutilizando el anterior si no cambió.
+func (master *Master) encodedModel(codecName string, shard *syncutils.Shard) (*syncutils.EncodedModel, error) {
+	request := master.fullSyncRequest(shard)
+	key := 0
+	if shard != nil {
+		key = shard.Index
+	}
+	master.transferMu.Lock()
+	defer master.transferMu.Unlock()
+	cached, ok := master.transfers[key]
+	if ok && cached.version == request.ModelVersion && cached.model.Transfer.Codec == codecName {
+		return cached.model, nil
+	}
+	start := time.Now()
+	encoded, err := syncutils.EncodeModel(&request, codecName, master.syncChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	part := "full model"
+	if shard != nil {
+		part = fmt.Sprintf("shard %d", shard.Index)
+	}
+	log.Printf("INFO: Model version %d (%s) encoded with %s in %v: %d bytes, %d chunks", request.ModelVersion, part, codecName, time.Since(start), encoded.Transfer.Size, encoded.Transfer.NumChunks)
+	if master.transfers == nil {
+		master.transfers = map[int]encodedTransfer{}
+	}
+	master.transfers[key] = encodedTransfer{version: request.ModelVersion, model: encoded}
+	return encoded, nil
+}
+
+// sendModelChunks anuncia la transferencia y envía los chunks que pide el
+// slave, que empieza por el primero que le falta. Deja en response la
+// respuesta final.
+func (master *Master) sendModelChunks(conn *syncutils.Conn, slaveId int, shard *syncutils.Shard, response *syncutils.SlaveSyncResponse) error {
+	encoded, err := master.encodedModel(conn.Codec().Name(), shard)
+	if err != nil {
+		return fmt.Errorf("syncError: Slave %d: %v", slaveId, err)
+	}
+	transfer := encoded.Transfer
+	err = conn.Send(&syncutils.MasterSyncRequest{MasterIp: master.ip, Transfer: &transfer})
+	if err != nil {
+		return fmt.Errorf("syncError: Slave %d sync request error: %v", slaveId, err)
+	}
+	err = master.receiveSyncResponse(conn, response)
+	if err != nil {
+		return fmt.Errorf("syncError: Slave %d sync response error: %v", slaveId, err)
+	}
+	if response.Status == syncutils.SyncStatusNextChunk && response.NextChunk > 0 {
+		log.Printf("INFO: Slave %d: Resuming transfer at chunk %d/%d", slaveId, response.NextChunk, transfer.NumChunks)
+	}
+
+	sent, retries := -1, 0
+	reported := transfer.Progress(response.NextChunk) / 10
+	for response.Status == syncutils.SyncStatusNextChunk {
+		index := response.NextChunk
+		if index < 0 || index >= transfer.NumChunks {
+			return fmt.Errorf("syncError: Slave %d requested chunk %d of %d", slaveId, index, transfer.NumChunks)
+		}
+		if index <= sent {
+			retries++
+			if retries > maxChunkRetries {
+				return fmt.Errorf("syncError: Slave %d rejected chunk %d %d times", slaveId, index, retries)
+			}
+			log.Printf("INFO: Slave %d: Resending chunk %d", slaveId, index)
+		} else {
+			retries = 0
+		}
+		if index == transfer.NumChunks-1 {
+			conn.SetDeadline(time.Now().Add(syncFinishTimeout))
+		} else {
+			conn.SetDeadline(time.Now().Add(syncTimeout))
+		}
+		chunk := encoded.Chunk(index)
+		err = conn.Send(&chunk)
+		if err != nil {
+			return fmt.Errorf("syncError: Slave %d error sending chunk %d: %v", slaveId, index, err)
+		}
+		sent = index
+		err = master.receiveSyncResponse(conn, response)
+		if err != nil {
+			return fmt.Errorf("syncError: Slave %d error confirming chunk %d: %v", slaveId, index, err)
+		}
+		if progress := transfer.Progress(index+1) / 10; progress > reported {
+			reported = progress
+			log.Printf("INFO: Slave %d: Sync %d%% (%d/%d chunks)", slaveId, transfer.Progress(index+1), index+1, transfer.NumChunks)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package master
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"recommendation-service/master/userstore"
+	"recommendation-service/model"
+	"recommendation-service/syncutils"
+	"strconv"
+)
+
+const (
+	userHandlerPrefix      = "userHandler"
+	defaultUserRecQuantity = 10
+	maxUserRecQuantity     = 100
+)
+
+func profileRatings(profile *userstore.Profile) model.SparseVector {
+	movieIds := profile.MovieIds()
+	vector := model.SparseVector{Indices: movieIds, Values: make([]float64, len(movieIds))}
+	for i, movieId := range movieIds {
+		vector.Values[i] = profile.Ratings[movieId]
+	}
+	return vector
+}
+
+// saveUserFactors guarda los factores del fold-in como punto de partida de la
+// próxima recomendación del usuario.
+func (master *Master) saveUserFactors(request *syncutils.ClientRecRequest) {
+	err := master.users.SetFactors(request.UserId, request.UserFactors, request.UserBias)
+	if err != nil {
+		log.Printf("ERROR: %s: Error saving user factors: %v", userHandlerPrefix, err)
+	}
+}
+
+func pathUserId(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Id de usuario inválido", http.StatusBadRequest)
+		return 0, false
+	}
+	return userId, true
+}
+
+func (master *Master) pathMovieId(w http.ResponseWriter, r *http.Request) (int, bool) {
+	movieId, err := strconv.Atoi(r.PathValue("movieId"))
+	if err != nil || movieId < 0 || movieId >= len(master.movieTitles) {
+		http.Error(w, "Película no encontrada", http.StatusNotFound)
+		return 0, false
+	}
+	return movieId, true
+}
+
+// userRatingsHandler atiende GET /users/{id}/ratings con el historial guardado.
+func (master *Master) userRatingsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := pathUserId(w, r)
+	if !ok {
+		return
+	}
+	profile, ok := master.users.Profile(userId)
+	if !ok {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	response := UserRatings{UserId: userId, Ratings: []UserRating{}}
+	for _, movieId := range profile.MovieIds() {
+		response.Ratings = append(response.Ratings, UserRating{
+			MovieId: movieId,
+			Title:   master.movieTitles[movieId],
+			Rating:  profile.Ratings[movieId],
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// setUserRatingHandler atiende PUT /users/{id}/ratings/{movieId} con el cuerpo
+// {"rating": 4.5}; crea el perfil si no existe.
+func (master *Master) setUserRatingHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := pathUserId(w, r)
+	if !ok {
+		return
+	}
+	movieId, ok := master.pathMovieId(w, r)
+	if !ok {
+		return
+	}
+	var request SetRatingRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil || request.Rating <= 0 || math.IsInf(request.Rating, 0) || math.IsNaN(request.Rating) {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	err = master.users.SetRating(userId, movieId, request.Rating)
+	if err != nil {
+		log.Printf("ERROR: %s: %v", userHandlerPrefix, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteUserRatingHandler atiende DELETE /users/{id}/ratings/{movieId}.
+func (master *Master) deleteUserRatingHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := pathUserId(w, r)
+	if !ok {
+		return
+	}
+	movieId, ok := master.pathMovieId(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := master.users.DeleteRating(userId, movieId)
+	if err != nil {
+		log.Printf("ERROR: %s: %v", userHandlerPrefix, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Rating no encontrado", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteUserHandler atiende DELETE /users/{id} y borra el perfil completo.
+func (master *Master) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := pathUserId(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := master.users.DeleteUser(userId)
+	if err != nil {
+		log.Printf("ERROR: %s: %v", userHandlerPrefix, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Usuario no encontrado", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userRecommendationsHandler atiende GET /users/{id}/recommendations?quantity=N&genreIds=1,2
+// con el historial guardado del usuario.
+func (master *Master) userRecommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, ok := pathUserId(w, r)
+	if !ok {
+		return
+	}
+	quantity := defaultUserRecQuantity
+	if value := r.URL.Query().Get("quantity"); value != "" {
+		var err error
+		quantity, err = strconv.Atoi(value)
+		if err != nil || quantity <= 0 || quantity > maxUserRecQuantity {
+			http.Error(w, "El parámetro quantity tiene que ser un entero entre 1 y "+strconv.Itoa(maxUserRecQuantity), http.StatusBadRequest)
+			return
+		}
+	}
+	genreIds, err := master.parseGenreIds(r.URL.Query().Get("genreIds"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	profile, ok := master.users.Profile(userId)
+	if !ok || len(profile.Ratings) == 0 {
+		http.Error(w, "Usuario sin ratings", http.StatusNotFound)
+		return
+	}
+
+	request := syncutils.ClientRecRequest{
+		UserId:      userId,
+		Ratings:     profileRatings(&profile),
+		Quantity:    quantity,
+		GenreIds:    genreIds,
+		UserFactors: profile.Factors,
+		UserBias:    profile.Bias,
+	}
+	var response syncutils.MasterRecResponse
+	err = master.processRecommendationRequest(&w, &response, &request)
+	if err != nil {
+		log.Printf("ERROR: %s: %v", userHandlerPrefix, err)
+		return
+	}
+	master.saveUserFactors(&request)
+	err = respondRecommendationRequest(&w, &response)
+	if err != nil {
+		log.Printf("ERROR: %s: %v", userHandlerPrefix, err)
+	}
+}
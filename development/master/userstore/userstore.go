@@ -0,0 +1,314 @@
+package userstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store guarda los perfiles de usuario (ratings y factores del fold-in) en
+// memoria y en un log de operaciones JSON, una por línea, que se reproduce y
+// compacta al abrirlo.
+type Store struct {
+	mu       sync.RWMutex
+	filename string
+	file     *os.File
+	// size es el largo del log hasta la última línea completa y compacted el
+	// que tenía al compactarlo por última vez.
+	size      int64
+	compacted int64
+	// failed es el error de una escritura que no se pudo deshacer; con él
+	// ya no se aceptan escrituras.
+	failed   error
+	profiles map[int]*Profile
+}
+
+type Profile struct {
+	UserId  int             `json:"userId"`
+	Ratings map[int]float64 `json:"ratings"`
+	// Factores del último fold-in, vacíos si todavía no se recomendó nada
+	Factors   []float64 `json:"factors,omitempty"`
+	Bias      float64   `json:"bias,omitempty"`
+	UpdatedAt int64     `json:"updatedAt"`
+}
+
+// El log se vuelve a compactar mientras se usa cuando supera compactMinSize y
+// compactRatio veces el tamaño de la última compactación: cada recomendación
+// agrega los factores del usuario, así que sin esto crecería sin límite.
+const (
+	compactMinSize = 1 << 20
+	compactRatio   = 2
+)
+
+const (
+	opRating       = "rating"
+	opDeleteRating = "deleteRating"
+	opFactors      = "factors"
+	opDeleteUser   = "deleteUser"
+)
+
+type record struct {
+	Op      string    `json:"op"`
+	UserId  int       `json:"userId"`
+	MovieId int       `json:"movieId,omitempty"`
+	Rating  float64   `json:"rating,omitempty"`
+	Factors []float64 `json:"factors,omitempty"`
+	Bias    float64   `json:"bias,omitempty"`
+	Time    int64     `json:"time"`
+}
+
+// Open carga el log de filename (lo crea si no existe) y lo reescribe con un
+// registro por dato vigente. Las líneas inválidas, como la última de una
+// escritura cortada, se descartan.
+func Open(filename string) (*Store, error) {
+	store := &Store{filename: filename, profiles: map[int]*Profile{}}
+	err := store.replay()
+	if err != nil {
+		return nil, err
+	}
+	err = store.compact()
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *Store) replay() error {
+	data, err := os.ReadFile(store.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("userStoreError: Error reading %s: %v", store.filename, err)
+	}
+	lines := bytes.Split(data, []byte{'\n'})
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry record
+		err = json.Unmarshal(line, &entry)
+		if err != nil {
+			log.Printf("ERROR: userStore: Skipping %s line %d: %v", store.filename, i+1, err)
+			continue
+		}
+		store.apply(&entry)
+	}
+	return nil
+}
+
+func (store *Store) apply(entry *record) {
+	if entry.Op == opDeleteUser {
+		delete(store.profiles, entry.UserId)
+		return
+	}
+	profile, ok := store.profiles[entry.UserId]
+	if !ok {
+		profile = &Profile{UserId: entry.UserId, Ratings: map[int]float64{}}
+		store.profiles[entry.UserId] = profile
+	}
+	switch entry.Op {
+	case opRating:
+		profile.Ratings[entry.MovieId] = entry.Rating
+	case opDeleteRating:
+		delete(profile.Ratings, entry.MovieId)
+	case opFactors:
+		profile.Factors = entry.Factors
+		profile.Bias = entry.Bias
+	}
+	profile.UpdatedAt = entry.Time
+}
+
+// compact escribe el estado actual en un archivo nuevo y lo reemplaza por el
+// log, así el log no crece con cada reinicio. Si falla el log anterior sigue
+// en uso.
+func (store *Store) compact() error {
+	err := os.MkdirAll(filepath.Dir(store.filename), 0755)
+	if err != nil {
+		return fmt.Errorf("userStoreError: Error creating directory: %v", err)
+	}
+	tmpFilename := store.filename + ".tmp"
+	file, err := os.Create(tmpFilename)
+	if err != nil {
+		return fmt.Errorf("userStoreError: Error creating %s: %v", tmpFilename, err)
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	userIds := make([]int, 0, len(store.profiles))
+	for userId := range store.profiles {
+		userIds = append(userIds, userId)
+	}
+	sort.Ints(userIds)
+	for _, userId := range userIds {
+		for _, entry := range store.profiles[userId].records() {
+			err = encoder.Encode(&entry)
+			if err != nil {
+				file.Close()
+				return fmt.Errorf("userStoreError: Error writing %s: %v", tmpFilename, err)
+			}
+		}
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("userStoreError: Error writing %s: %v", tmpFilename, err)
+	}
+	err = os.Rename(tmpFilename, store.filename)
+	if err != nil {
+		return fmt.Errorf("userStoreError: Error replacing %s: %v", store.filename, err)
+	}
+	// Después del rename el archivo abierto ya no es el log: si el nuevo no
+	// se puede abrir no se aceptan más escrituras.
+	compactedFile, err := os.OpenFile(store.filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err == nil {
+		var info os.FileInfo
+		info, err = compactedFile.Stat()
+		if err != nil {
+			compactedFile.Close()
+		} else {
+			store.size = info.Size()
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("userStoreError: Error opening %s: %v", store.filename, err)
+		store.failed = err
+		return err
+	}
+	if store.file != nil {
+		store.file.Close()
+	}
+	store.file = compactedFile
+	store.compacted = store.size
+	return nil
+}
+
+func (profile *Profile) records() []record {
+	movieIds := profile.MovieIds()
+	records := make([]record, 0, len(movieIds)+1)
+	for _, movieId := range movieIds {
+		records = append(records, record{Op: opRating, UserId: profile.UserId, MovieId: movieId, Rating: profile.Ratings[movieId], Time: profile.UpdatedAt})
+	}
+	if len(profile.Factors) > 0 || len(movieIds) == 0 {
+		records = append(records, record{Op: opFactors, UserId: profile.UserId, Factors: profile.Factors, Bias: profile.Bias, Time: profile.UpdatedAt})
+	}
+	return records
+}
+
+// MovieIds devuelve las películas con rating en orden ascendente.
+func (profile *Profile) MovieIds() []int {
+	movieIds := make([]int, 0, len(profile.Ratings))
+	for movieId := range profile.Ratings {
+		movieIds = append(movieIds, movieId)
+	}
+	sort.Ints(movieIds)
+	return movieIds
+}
+
+func (store *Store) write(entry record) error {
+	entry.Time = time.Now().Unix()
+	line, err := json.Marshal(&entry)
+	if err != nil {
+		return fmt.Errorf("userStoreError: Error encoding record: %v", err)
+	}
+	if store.failed != nil {
+		return fmt.Errorf("userStoreError: Store failed: %v", store.failed)
+	}
+	line = append(line, '\n')
+	_, err = store.file.Write(line)
+	if err != nil {
+		// Se descarta la línea parcial para que la próxima no quede pegada a ella
+		truncateErr := store.file.Truncate(store.size)
+		if truncateErr != nil {
+			store.failed = fmt.Errorf("%v, and could not be undone: %v", err, truncateErr)
+		}
+		return fmt.Errorf("userStoreError: Error writing record: %v", err)
+	}
+	store.size += int64(len(line))
+	store.apply(&entry)
+	if store.size > compactMinSize && store.size > compactRatio*store.compacted {
+		err = store.compact()
+		if err != nil {
+			// Se vuelve a intentar cuando el log vuelva a duplicarse
+			store.compacted = store.size
+			log.Printf("ERROR: userStore: Error compacting: %v", err)
+		}
+	}
+	return nil
+}
+
+// Profile devuelve una copia del perfil del usuario.
+func (store *Store) Profile(userId int) (Profile, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	profile, ok := store.profiles[userId]
+	if !ok {
+		return Profile{}, false
+	}
+	copied := *profile
+	copied.Ratings = make(map[int]float64, len(profile.Ratings))
+	for movieId, rating := range profile.Ratings {
+		copied.Ratings[movieId] = rating
+	}
+	copied.Factors = append([]float64(nil), profile.Factors...)
+	return copied, true
+}
+
+// SetRating agrega o actualiza un rating del usuario, creando el perfil.
+func (store *Store) SetRating(userId, movieId int, rating float64) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.write(record{Op: opRating, UserId: userId, MovieId: movieId, Rating: rating})
+}
+
+// DeleteRating borra un rating; devuelve false si el usuario no lo tenía.
+func (store *Store) DeleteRating(userId, movieId int) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	profile, ok := store.profiles[userId]
+	if !ok {
+		return false, nil
+	}
+	if _, ok := profile.Ratings[movieId]; !ok {
+		return false, nil
+	}
+	return true, store.write(record{Op: opDeleteRating, UserId: userId, MovieId: movieId})
+}
+
+// SetFactors guarda los factores del fold-in del usuario.
+func (store *Store) SetFactors(userId int, factors []float64, bias float64) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.write(record{Op: opFactors, UserId: userId, Factors: factors, Bias: bias})
+}
+
+// DeleteUser borra el perfil completo; devuelve false si no existía.
+func (store *Store) DeleteUser(userId int) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, ok := store.profiles[userId]; !ok {
+		return false, nil
+	}
+	return true, store.write(record{Op: opDeleteUser, UserId: userId})
+}
+
+func (store *Store) Len() int {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return len(store.profiles)
+}
+
+func (store *Store) Close() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.file.Close()
+}
@@ -0,0 +1,56 @@
+package userstore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestCompactWhileRunning guarda los factores de los mismos usuarios muchas
+// veces, como después de cada recomendación, y comprueba que el log no pase
+// del umbral de compactación y que al reabrirlo se recupere el último estado.
+func TestCompactWhileRunning(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.log")
+	store, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	factors := []float64{0.123456789, -0.987654321, 0.5, 0.25}
+	for i := 0; i < 30000; i++ {
+		userId := i % 10
+		if i < 10 {
+			err = store.SetRating(userId, i, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		factors[0] = float64(i)
+		err = store.SetFactors(userId, factors, float64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > compactRatio*compactMinSize {
+		t.Errorf("log has %d bytes, want at most %d", info.Size(), compactRatio*compactMinSize)
+	}
+	if info.Size() != store.size {
+		t.Errorf("log has %d bytes but the store counted %d", info.Size(), store.size)
+	}
+	want, _ := store.Profile(9)
+	store.Close()
+
+	reopened, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	got, _ := reopened.Profile(9)
+	if reopened.Len() != 10 || !reflect.DeepEqual(got, want) {
+		t.Errorf("reopened %d users, user 9 is %+v, want 10 and %+v", reopened.Len(), got, want)
+	}
+}
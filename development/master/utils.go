@@ -1,14 +1,30 @@
 package master
 
-import "fmt"
+import (
+	"fmt"
+	"recommendation-service/model"
+	"sort"
+)
 
-func MappRatingsClient(ratings []MovieRatingsClient, moviesTitles *MoviesTitles) []float64 {
-	numMovies := len(moviesTitles.Title)
-	arr := make([]float64, numMovies)
+func MappRatingsClient(ratings []MovieRatingsClient) model.SparseVector {
+	byMovie := make(map[int]float64, len(ratings))
 	for _, rating := range ratings {
-		arr[rating.MovieId] = float64(rating.Rating)
+		byMovie[rating.MovieId] = float64(rating.Rating)
 	}
-	return arr
+	vector := model.SparseVector{
+		Indices: make([]int, 0, len(byMovie)),
+		Values:  make([]float64, 0, len(byMovie)),
+	}
+	for movieId, rating := range byMovie {
+		if rating != 0 {
+			vector.Indices = append(vector.Indices, movieId)
+		}
+	}
+	sort.Ints(vector.Indices)
+	for _, movieId := range vector.Indices {
+		vector.Values = append(vector.Values, byMovie[movieId])
+	}
+	return vector
 }
 
 func Banner() {
@@ -0,0 +1,261 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Época de mínimos cuadrados alternados (ALS-WR): se resuelven los factores de
+// usuario con Q fijo y luego los de item con P fijo. Cada fila es un sistema
+// independiente, así que no hace falta ningún lock y el resultado no depende del
+// orden en que los workers procesan las filas.
+func (model *Model) alsEpoch() {
+	model.updateUsersALS()
+	model.updateItemsALS()
+}
+
+// Con bias, los sesgos entran como una dimensión más: para los usuarios se
+// resuelve [p_u, b_u] con coeficientes [q_i, 1] y objetivo r - μ - b_i, y para
+// los items [q_i, b_i] con coeficientes [p_u, 1] y objetivo r - μ - b_u.
+func (model *Model) updateUsersALS() {
+	parallelRows(model.R.NumUsers(), model.workers(), model.alsSize(), func(userId int, solver *normalSolver) {
+		items, values := model.R.UserRow(userId)
+		if len(items) == 0 {
+			return
+		}
+		solver.reset()
+		for n, item := range items {
+			itemId := int(item)
+			target := float64(values[n])
+			if model.biased {
+				target -= model.GlobalMean + model.ItemBias[itemId]
+			}
+			solver.add(model.alsVector(model.Q[itemId], solver.vector), target, 1)
+		}
+		if solver.solve(model.regularization*float64(len(items))) == nil {
+			copy(model.P[userId], solver.solution)
+			if model.biased {
+				model.UserBias[userId] = solver.solution[model.numFeatures]
+			}
+		}
+	})
+}
+
+func (model *Model) updateItemsALS() {
+	parallelRows(model.R.NumItems(), model.workers(), model.alsSize(), func(itemId int, solver *normalSolver) {
+		users, values := model.R.ItemColumn(itemId)
+		if len(users) == 0 {
+			return
+		}
+		solver.reset()
+		for n, user := range users {
+			userId := int(user)
+			target := float64(values[n])
+			if model.biased {
+				target -= model.GlobalMean + model.UserBias[userId]
+			}
+			solver.add(model.alsVector(model.P[userId], solver.vector), target, 1)
+		}
+		if solver.solve(model.regularization*float64(len(users))) == nil {
+			copy(model.Q[itemId], solver.solution)
+			if model.biased {
+				model.ItemBias[itemId] = solver.solution[model.numFeatures]
+			}
+		}
+	})
+}
+
+func (model *Model) alsSize() int {
+	if model.biased {
+		return model.numFeatures + 1
+	}
+	return model.numFeatures
+}
+
+// alsVector devuelve los coeficientes de una fila, agregando el 1 del sesgo en
+// el buffer cuando el modelo tiene bias.
+func (model *Model) alsVector(factors, buffer []float64) []float64 {
+	if !model.biased {
+		return factors
+	}
+	copy(buffer, factors)
+	buffer[model.numFeatures] = 1
+	return buffer
+}
+
+// parallelRows reparte las filas [0, n) entre un pool fijo de workers, cada uno
+// con su propio solver para no compartir memoria de trabajo.
+func parallelRows(n, numWorkers, numFeatures int, solve func(row int, solver *normalSolver)) {
+	rows := make(chan int, numWorkers)
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			solver := newNormalSolver(numFeatures)
+			for row := range rows {
+				solve(row, solver)
+			}
+		}()
+	}
+	for row := 0; row < n; row++ {
+		rows <- row
+	}
+	close(rows)
+	wg.Wait()
+}
+
+// normalSolver acumula las ecuaciones normales (A = Σ w·q·qᵀ, b = Σ w·r·q) y
+// las resuelve por Cholesky.
+type normalSolver struct {
+	k        int
+	gram     []float64
+	rhs      []float64
+	lower    []float64
+	vector   []float64
+	solution []float64
+}
+
+func newNormalSolver(k int) *normalSolver {
+	return &normalSolver{
+		k:        k,
+		gram:     make([]float64, k*k),
+		rhs:      make([]float64, k),
+		lower:    make([]float64, k*k),
+		vector:   make([]float64, k),
+		solution: make([]float64, k),
+	}
+}
+
+func (solver *normalSolver) reset() {
+	for i := range solver.gram {
+		solver.gram[i] = 0
+	}
+	for i := range solver.rhs {
+		solver.rhs[i] = 0
+	}
+}
+
+func (solver *normalSolver) add(factors []float64, rating, weight float64) {
+	k := solver.k
+	for a := 0; a < k; a++ {
+		wa := weight * factors[a]
+		solver.rhs[a] += wa * rating
+		row := solver.gram[a*k : a*k+k]
+		for b := 0; b <= a; b++ {
+			row[b] += wa * factors[b]
+		}
+	}
+}
+
+// solve deja el resultado en solver.solution; si el sistema no es definido
+// positivo devuelve error y el llamador conserva los factores anteriores.
+func (solver *normalSolver) solve(lambda float64) error {
+	return solveCholesky(solver.gram, solver.rhs, lambda, solver.k, solver.lower, solver.solution)
+}
+
+// solveCholesky resuelve (A + λI)x = b con A simétrica de tamaño k×k; solo lee
+// el triángulo inferior de A.
+func solveCholesky(gram, rhs []float64, lambda float64, k int, lower, out []float64) error {
+	if lambda <= 0 {
+		lambda = 1e-9
+	}
+	for i := 0; i < k; i++ {
+		for j := 0; j <= i; j++ {
+			sum := gram[i*k+j]
+			if i == j {
+				sum += lambda
+			}
+			for p := 0; p < j; p++ {
+				sum -= lower[i*k+p] * lower[j*k+p]
+			}
+			if i == j {
+				if sum <= 0 {
+					return fmt.Errorf("alsError: Matrix is not positive definite")
+				}
+				lower[i*k+i] = math.Sqrt(sum)
+			} else {
+				lower[i*k+j] = sum / lower[j*k+j]
+			}
+		}
+	}
+	// L·y = b
+	for i := 0; i < k; i++ {
+		sum := rhs[i]
+		for p := 0; p < i; p++ {
+			sum -= lower[i*k+p] * out[p]
+		}
+		out[i] = sum / lower[i*k+i]
+	}
+	// Lᵀ·x = y
+	for i := k - 1; i >= 0; i-- {
+		sum := out[i]
+		for p := i + 1; p < k; p++ {
+			sum -= lower[p*k+i] * out[p]
+		}
+		out[i] = sum / lower[i*k+i]
+	}
+	return nil
+}
+
+// UserNormalEquations devuelve la parte de las ecuaciones normales del usuario
+// que aportan los items calificados en ratings. Como son sumas, cada slave puede
+// calcular la de su rango de películas y el master sumarlas antes de resolver.
+// En el modelo con bias la última incógnita es b_u.
+func (model *Model) UserNormalEquations(ratings SparseVector) ([]float64, []float64, int) {
+	solver := newNormalSolver(model.alsSize())
+	for n, itemId := range ratings.Indices {
+		target := ratings.Values[n]
+		if model.biased {
+			target -= model.GlobalMean + model.ItemBias[itemId]
+		}
+		solver.add(model.alsVector(model.Q[itemId], solver.vector), target, 1)
+	}
+	k := solver.k
+	for a := 0; a < k; a++ {
+		for b := a + 1; b < k; b++ {
+			solver.gram[a*k+b] = solver.gram[b*k+a]
+		}
+	}
+	return solver.gram, solver.rhs, ratings.Len()
+}
+
+// SolveUserNormalEquations obtiene en forma cerrada los factores de un usuario
+// nuevo a partir de las ecuaciones normales acumuladas.
+func SolveUserNormalEquations(gram, rhs []float64, count int, regularization float64) ([]float64, error) {
+	k := len(rhs)
+	if len(gram) != k*k {
+		return nil, fmt.Errorf("alsError: Gram matrix size %d does not match %d features", len(gram), k)
+	}
+	userFactors := make([]float64, k)
+	if count == 0 {
+		return userFactors, nil
+	}
+	err := solveCholesky(gram, rhs, regularization*float64(count), k, make([]float64, k*k), userFactors)
+	if err != nil {
+		return nil, err
+	}
+	return userFactors, nil
+}
+
+// SolveUserFactors pliega un usuario nuevo resolviendo directamente su sistema
+// y devuelve sus factores y su sesgo (0 si el modelo no tiene bias).
+func (model *Model) SolveUserFactors(ratings SparseVector) ([]float64, float64, error) {
+	gram, rhs, count := model.UserNormalEquations(ratings)
+	solution, err := SolveUserNormalEquations(gram, rhs, count, model.regularization)
+	if err != nil {
+		return nil, 0, err
+	}
+	userFactors, userBias := SplitUserSolution(solution, model.numFeatures)
+	return userFactors, userBias, nil
+}
+
+// SplitUserSolution separa el sesgo b_u de la solución de las ecuaciones
+// normales cuando el modelo tiene bias.
+func SplitUserSolution(solution []float64, numFeatures int) ([]float64, float64) {
+	if len(solution) > numFeatures {
+		return solution[:numFeatures], solution[numFeatures]
+	}
+	return solution, 0
+}
@@ -0,0 +1,314 @@
+package model
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// AnnConfig configura el índice IVF sobre las filas de Q. Los campos en cero
+// toman los valores por defecto.
+type AnnConfig struct {
+	Enabled bool `json:"enabled"`
+	// Listas del IVF, por defecto √items.
+	NumLists int `json:"numLists,omitempty"`
+	// Listas recorridas por búsqueda, por defecto numLists/10. Más listas dan
+	// más recall a cambio de latencia.
+	NumProbes int `json:"numProbes,omitempty"`
+	// Iteraciones de k-means, por defecto 10.
+	Iterations int `json:"iterations,omitempty"`
+	// Consultas con las que se mide el recall contra la búsqueda exacta al
+	// construir el índice, por defecto 100.
+	ValidationQueries int `json:"validationQueries,omitempty"`
+	// Si el recall medido queda por debajo, no se usa el índice.
+	MinRecall   float64 `json:"minRecall,omitempty"`
+	RandomState int     `json:"randomState,omitempty"`
+}
+
+const (
+	defaultAnnIterations        = 10
+	defaultAnnValidationQueries = 100
+	annValidationK              = 10
+	// Máximo de items por lista con los que se entrena k-means.
+	annSamplePerList = 64
+)
+
+// ItemIndex es un índice IVF para el producto interno (MIPS). Cada item se
+// aumenta a [q, b, √(M² - |q|² - b²)] con M la norma máxima, así todos quedan
+// a la misma norma y el mayor p·q + b es el vecino euclídeo más cercano de
+// [p, 1, 0]; k-means sobre esos vectores arma las listas.
+type ItemIndex struct {
+	model     *Model
+	numProbes int
+	vectors   [][]float64
+	centroids [][]float64
+	lists     [][]int
+	recall    float64
+}
+
+type ItemScore struct {
+	ItemId int     `json:"itemId"`
+	Score  float64 `json:"score"`
+}
+
+// SearchStats resume las predicciones de los items recorridos en una búsqueda.
+type SearchStats struct {
+	Scored int
+	Sum    float64
+	Min    float64
+	Max    float64
+}
+
+func (config *AnnConfig) withDefaults(numItems int) AnnConfig {
+	result := *config
+	if result.NumLists <= 0 {
+		result.NumLists = int(math.Ceil(math.Sqrt(float64(numItems))))
+	}
+	if result.NumLists > numItems {
+		result.NumLists = numItems
+	}
+	if result.NumProbes <= 0 {
+		result.NumProbes = max(1, result.NumLists/10)
+	}
+	if result.NumProbes > result.NumLists {
+		result.NumProbes = result.NumLists
+	}
+	if result.Iterations <= 0 {
+		result.Iterations = defaultAnnIterations
+	}
+	if result.ValidationQueries <= 0 {
+		result.ValidationQueries = defaultAnnValidationQueries
+	}
+	return result
+}
+
+// BuildItemIndex arma el índice sobre Q y mide su recall contra la búsqueda
+// exacta usando filas de Q como consultas.
+func (model *Model) BuildItemIndex(config AnnConfig) (*ItemIndex, error) {
+	numItems := len(model.Q)
+	if numItems == 0 {
+		return nil, fmt.Errorf("annError: Model has no items")
+	}
+	config = config.withDefaults(numItems)
+	index := &ItemIndex{
+		model:     model,
+		numProbes: config.NumProbes,
+		vectors:   model.augmentedItems(),
+	}
+	random := rand.New(rand.NewSource(int64(config.RandomState)))
+	index.centroids = kMeans(index.vectors, config.NumLists, config.Iterations, random)
+	assignments := nearestCentroids(index.vectors, index.centroids)
+	index.lists = make([][]int, len(index.centroids))
+	for itemId, list := range assignments {
+		index.lists[list] = append(index.lists[list], itemId)
+	}
+	index.recall = index.validate(config.ValidationQueries, random)
+	return index, nil
+}
+
+func (model *Model) augmentedItems() [][]float64 {
+	dims := model.numFeatures + 2
+	vectors := make([][]float64, len(model.Q))
+	maxNorm := 0.0
+	for itemId, factors := range model.Q {
+		vector := make([]float64, dims)
+		copy(vector, factors)
+		if model.biased {
+			vector[model.numFeatures] = model.ItemBias[itemId]
+		}
+		vectors[itemId] = vector
+		maxNorm = math.Max(maxNorm, dot(vector, vector))
+	}
+	for _, vector := range vectors {
+		vector[dims-1] = math.Sqrt(math.Max(maxNorm-dot(vector, vector), 0))
+	}
+	return vectors
+}
+
+func dot(a, b []float64) float64 {
+	result := 0.0
+	for i := range a {
+		result += a[i] * b[i]
+	}
+	return result
+}
+
+func squaredDistance(a, b []float64) float64 {
+	result := 0.0
+	for i := range a {
+		diff := a[i] - b[i]
+		result += diff * diff
+	}
+	return result
+}
+
+// kMeans entrena los centroides con una muestra de vectores; los clusters que
+// quedan vacíos se reinician con un vector al azar.
+func kMeans(vectors [][]float64, numLists, iterations int, random *rand.Rand) [][]float64 {
+	sample := vectors
+	if limit := numLists * annSamplePerList; len(vectors) > limit {
+		sample = make([][]float64, limit)
+		for i, j := range random.Perm(len(vectors))[:limit] {
+			sample[i] = vectors[j]
+		}
+	}
+	centroids := make([][]float64, numLists)
+	for i, j := range random.Perm(len(sample))[:numLists] {
+		centroids[i] = append([]float64(nil), sample[j]...)
+	}
+	dims := len(vectors[0])
+	for iteration := 0; iteration < iterations; iteration++ {
+		assignments := nearestCentroids(sample, centroids)
+		sums := make([][]float64, numLists)
+		counts := make([]int, numLists)
+		for i := range sums {
+			sums[i] = make([]float64, dims)
+		}
+		for i, list := range assignments {
+			counts[list]++
+			for d, value := range sample[i] {
+				sums[list][d] += value
+			}
+		}
+		for list := range centroids {
+			if counts[list] == 0 {
+				copy(centroids[list], sample[random.Intn(len(sample))])
+				continue
+			}
+			for d := range sums[list] {
+				centroids[list][d] = sums[list][d] / float64(counts[list])
+			}
+		}
+	}
+	return centroids
+}
+
+func nearestCentroids(vectors, centroids [][]float64) []int {
+	assignments := make([]int, len(vectors))
+	workers := runtime.NumCPU()
+	chunk := (len(vectors) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(vectors); start += chunk {
+		end := min(start+chunk, len(vectors))
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				best, bestDistance := 0, math.Inf(1)
+				for list, centroid := range centroids {
+					if distance := squaredDistance(vectors[i], centroid); distance < bestDistance {
+						best, bestDistance = list, distance
+					}
+				}
+				assignments[i] = best
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	return assignments
+}
+
+// Search devuelve los k items aceptados por accept (nil acepta todos) de mayor
+// PredictUser, de mayor a menor, recorriendo las numProbes listas más cercanas
+// (0 usa la configuración del índice). Si no alcanzan para k items se siguen
+// recorriendo listas. Las estadísticas son de los items recorridos, no de
+// todo el catálogo.
+func (index *ItemIndex) Search(userFactors []float64, userBias float64, k, numProbes int, accept func(itemId int) bool) ([]ItemScore, SearchStats) {
+	stats := SearchStats{Min: math.Inf(1), Max: math.Inf(-1)}
+	if k <= 0 {
+		return []ItemScore{}, SearchStats{}
+	}
+	if numProbes <= 0 {
+		numProbes = index.numProbes
+	}
+	numFeatures := index.model.numFeatures
+	query := make([]float64, numFeatures+2)
+	copy(query, userFactors)
+	query[numFeatures] = 1
+
+	// |query - c|² sin el término |query|², que no cambia el orden
+	order := make([]int, len(index.centroids))
+	distances := make([]float64, len(index.centroids))
+	for list, centroid := range index.centroids {
+		order[list] = list
+		distances[list] = dot(centroid, centroid) - 2*dot(query, centroid)
+	}
+	sort.Slice(order, func(i, j int) bool { return distances[order[i]] < distances[order[j]] })
+
+	h := make(scoredItemHeap, 0, k+1)
+	for probe, list := range order {
+		if probe >= numProbes && len(h) == k {
+			break
+		}
+		for _, itemId := range index.lists[list] {
+			if accept != nil && !accept(itemId) {
+				continue
+			}
+			score := index.model.PredictUser(userFactors, userBias, itemId)
+			stats.Scored++
+			stats.Sum += score
+			stats.Min = math.Min(stats.Min, score)
+			stats.Max = math.Max(stats.Max, score)
+			if len(h) < k {
+				heap.Push(&h, scoredItem{itemId: itemId, score: score})
+			} else if score > h[0].score {
+				h[0] = scoredItem{itemId: itemId, score: score}
+				heap.Fix(&h, 0)
+			}
+		}
+	}
+	if stats.Scored == 0 {
+		stats.Min, stats.Max = 0, 0
+	}
+	items := make([]ItemScore, len(h))
+	for i := len(h) - 1; i >= 0; i-- {
+		item := heap.Pop(&h).(scoredItem)
+		items[i] = ItemScore{ItemId: item.itemId, Score: item.score}
+	}
+	return items, stats
+}
+
+// ExactSearch es la búsqueda exhaustiva equivalente a Search, para validar.
+func (index *ItemIndex) ExactSearch(userFactors []float64, userBias float64, k int, accept func(itemId int) bool) []ItemScore {
+	items, _ := index.Search(userFactors, userBias, k, len(index.centroids), accept)
+	return items
+}
+
+// validate mide el recall@10 promedio usando filas de Q al azar como consultas.
+func (index *ItemIndex) validate(queries int, random *rand.Rand) float64 {
+	q := index.model.Q
+	k := min(annValidationK, len(q))
+	hits := 0
+	for i := 0; i < queries; i++ {
+		query := q[random.Intn(len(q))]
+		exact := index.ExactSearch(query, 0, k, nil)
+		approximate, _ := index.Search(query, 0, k, 0, nil)
+		found := make(map[int]bool, k)
+		for _, item := range approximate {
+			found[item.ItemId] = true
+		}
+		for _, item := range exact {
+			if found[item.ItemId] {
+				hits++
+			}
+		}
+	}
+	return float64(hits) / float64(queries*k)
+}
+
+// Recall es el recall@10 medido al construir el índice.
+func (index *ItemIndex) Recall() float64 {
+	return index.recall
+}
+
+func (index *ItemIndex) NumLists() int {
+	return len(index.centroids)
+}
+
+func (index *ItemIndex) NumProbes() int {
+	return index.numProbes
+}
@@ -0,0 +1,385 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+)
+
+// Formato binario del modelo (little endian):
+//
+//	magic "MFBM" | version uint16 | flags uint16
+//	numUsers, numItems, numFeatures, epochs, numWorkers uint32
+//	learningRate, regularization, globalMean float64
+//	alpha float64 (desde la versión 2)
+//	algorithm (uint8 longitud + bytes)
+//	crc32 del encabezado uint32 (desde la versión 4) | crc32 del payload uint32
+//	payload: Q, ItemBias, P, UserBias, ids (desde la versión 3)
+//
+// El crc32 del encabezado cubre desde version hasta algorithm y se verifica
+// antes de reservar memoria para el payload.
+//
+// Los ids externos van como uint32 longitud + bytes, primero los de los items
+// y luego los de los usuarios, cada lista solo si su flag está activo.
+// Los arreglos del payload van como float32 o float64 según flags. Los ratings
+// de entrenamiento no se guardan: el modelo binario es solo para servir.
+const (
+	binaryMagic   = "MFBM"
+	binaryVersion = 4
+
+	binaryFlagFloat32 = 1 << 0
+	binaryFlagBiased  = 1 << 1
+	binaryFlagHasP    = 1 << 2
+	binaryFlagItemIds = 1 << 3
+	binaryFlagUserIds = 1 << 4
+)
+
+type binaryHeader struct {
+	Version        uint16
+	Flags          uint16
+	NumUsers       uint32
+	NumItems       uint32
+	NumFeatures    uint32
+	Epochs         uint32
+	NumWorkers     uint32
+	LearningRate   float64
+	Regularization float64
+	GlobalMean     float64
+}
+
+// ParamsToBinary guarda el modelo en el formato binario; con float32 el archivo
+// ocupa la mitad a cambio de precisión simple.
+func (model *Model) ParamsToBinary(filename string, useFloat32 bool) error {
+	modelConfig := model.Config()
+	err := writeFileAtomic(filename, func(writer io.Writer) error {
+		return WriteModelBinary(writer, &modelConfig, useFloat32)
+	})
+	if err != nil {
+		return fmt.Errorf("error writing binary model to file: %v", err)
+	}
+	return nil
+}
+
+func WriteModelBinary(writer io.Writer, modelConfig *ModelConfig, useFloat32 bool) error {
+	numFeatures := modelConfig.NumFeatures
+	for _, row := range modelConfig.Q {
+		if len(row) != numFeatures {
+			return fmt.Errorf("binaryModelError: Q row has %d features, expected %d", len(row), numFeatures)
+		}
+	}
+	for _, row := range modelConfig.P {
+		if len(row) != numFeatures {
+			return fmt.Errorf("binaryModelError: P row has %d features, expected %d", len(row), numFeatures)
+		}
+	}
+	if len(modelConfig.Algorithm) > math.MaxUint8 {
+		return fmt.Errorf("binaryModelError: Algorithm name too long")
+	}
+
+	header := binaryHeader{
+		Version:        binaryVersion,
+		NumItems:       uint32(len(modelConfig.Q)),
+		NumFeatures:    uint32(numFeatures),
+		Epochs:         uint32(modelConfig.Epochs),
+		NumWorkers:     uint32(modelConfig.NumWorkers),
+		LearningRate:   modelConfig.LearningRate,
+		Regularization: modelConfig.Regularization,
+		GlobalMean:     modelConfig.GlobalMean,
+	}
+	if useFloat32 {
+		header.Flags |= binaryFlagFloat32
+	}
+	if modelConfig.Biased {
+		header.Flags |= binaryFlagBiased
+		if len(modelConfig.ItemBias) != len(modelConfig.Q) {
+			return fmt.Errorf("binaryModelError: ItemBias has %d entries, expected %d", len(modelConfig.ItemBias), len(modelConfig.Q))
+		}
+	}
+	if modelConfig.ItemIds != nil {
+		header.Flags |= binaryFlagItemIds
+		if len(modelConfig.ItemIds) != len(modelConfig.Q) {
+			return fmt.Errorf("binaryModelError: ItemIds has %d entries, expected %d", len(modelConfig.ItemIds), len(modelConfig.Q))
+		}
+	}
+	if len(modelConfig.P) > 0 {
+		header.Flags |= binaryFlagHasP
+		if modelConfig.UserIds != nil {
+			header.Flags |= binaryFlagUserIds
+			if len(modelConfig.UserIds) != len(modelConfig.P) {
+				return fmt.Errorf("binaryModelError: UserIds has %d entries, expected %d", len(modelConfig.UserIds), len(modelConfig.P))
+			}
+		}
+		header.NumUsers = uint32(len(modelConfig.P))
+		if modelConfig.Biased && len(modelConfig.UserBias) != len(modelConfig.P) {
+			return fmt.Errorf("binaryModelError: UserBias has %d entries, expected %d", len(modelConfig.UserBias), len(modelConfig.P))
+		}
+	}
+
+	// El checksum va en el encabezado, así que el payload se recorre dos veces.
+	checksum := crc32.NewIEEE()
+	err := writeBinaryPayload(checksum, modelConfig, header.Flags)
+	if err != nil {
+		return err
+	}
+
+	var encodedHeader bytes.Buffer
+	binary.Write(&encodedHeader, binary.LittleEndian, &header)
+	binary.Write(&encodedHeader, binary.LittleEndian, modelConfig.Alpha)
+	encodedHeader.WriteByte(byte(len(modelConfig.Algorithm)))
+	encodedHeader.WriteString(modelConfig.Algorithm)
+	binary.Write(&encodedHeader, binary.LittleEndian, crc32.ChecksumIEEE(encodedHeader.Bytes()))
+	binary.Write(&encodedHeader, binary.LittleEndian, checksum.Sum32())
+
+	_, err = io.WriteString(writer, binaryMagic)
+	if err == nil {
+		_, err = writer.Write(encodedHeader.Bytes())
+	}
+	if err != nil {
+		return fmt.Errorf("binaryModelError: Error writing header: %v", err)
+	}
+	err = writeBinaryPayload(writer, modelConfig, header.Flags)
+	if err != nil {
+		return fmt.Errorf("binaryModelError: Error writing payload: %v", err)
+	}
+	return nil
+}
+
+func writeBinaryPayload(writer io.Writer, modelConfig *ModelConfig, flags uint16) error {
+	buffered := bufio.NewWriter(writer)
+	useFloat32 := flags&binaryFlagFloat32 != 0
+	for _, row := range modelConfig.Q {
+		writeFloats(buffered, row, useFloat32)
+	}
+	if flags&binaryFlagBiased != 0 {
+		writeFloats(buffered, modelConfig.ItemBias, useFloat32)
+	}
+	if flags&binaryFlagHasP != 0 {
+		for _, row := range modelConfig.P {
+			writeFloats(buffered, row, useFloat32)
+		}
+		if flags&binaryFlagBiased != 0 {
+			writeFloats(buffered, modelConfig.UserBias, useFloat32)
+		}
+	}
+	if flags&binaryFlagItemIds != 0 {
+		writeStrings(buffered, modelConfig.ItemIds)
+	}
+	if flags&binaryFlagUserIds != 0 {
+		writeStrings(buffered, modelConfig.UserIds)
+	}
+	return buffered.Flush()
+}
+
+func writeStrings(writer *bufio.Writer, values []string) {
+	var buffer [4]byte
+	for _, value := range values {
+		binary.LittleEndian.PutUint32(buffer[:], uint32(len(value)))
+		writer.Write(buffer[:])
+		writer.WriteString(value)
+	}
+}
+
+func writeFloats(writer *bufio.Writer, values []float64, useFloat32 bool) {
+	var buffer [8]byte
+	for _, value := range values {
+		if useFloat32 {
+			binary.LittleEndian.PutUint32(buffer[:4], math.Float32bits(float32(value)))
+			writer.Write(buffer[:4])
+		} else {
+			binary.LittleEndian.PutUint64(buffer[:], math.Float64bits(value))
+			writer.Write(buffer[:])
+		}
+	}
+}
+
+func ReadModelBinary(reader io.Reader) (ModelConfig, error) {
+	var modelConfig ModelConfig
+	buffered := bufio.NewReader(reader)
+
+	magic := make([]byte, len(binaryMagic))
+	_, err := io.ReadFull(buffered, magic)
+	if err != nil || string(magic) != binaryMagic {
+		return modelConfig, fmt.Errorf("binaryModelError: Not a binary model file")
+	}
+	headerChecksum := crc32.NewIEEE()
+	headerReader := io.TeeReader(buffered, headerChecksum)
+	var header binaryHeader
+	err = binary.Read(headerReader, binary.LittleEndian, &header)
+	if err != nil {
+		return modelConfig, fmt.Errorf("binaryModelError: Error reading header: %v", err)
+	}
+	if header.Version < 1 || header.Version > binaryVersion {
+		return modelConfig, fmt.Errorf("binaryModelError: Unsupported version %d", header.Version)
+	}
+	if header.Version >= 2 {
+		err = binary.Read(headerReader, binary.LittleEndian, &modelConfig.Alpha)
+		if err != nil {
+			return modelConfig, fmt.Errorf("binaryModelError: Error reading header: %v", err)
+		}
+	}
+	var algorithmLen [1]byte
+	_, err = io.ReadFull(headerReader, algorithmLen[:])
+	if err != nil {
+		return modelConfig, fmt.Errorf("binaryModelError: Error reading header: %v", err)
+	}
+	algorithm := make([]byte, algorithmLen[0])
+	_, err = io.ReadFull(headerReader, algorithm)
+	if err != nil {
+		return modelConfig, fmt.Errorf("binaryModelError: Error reading header: %v", err)
+	}
+	if header.Version >= 4 {
+		var expectedHeaderChecksum uint32
+		err = binary.Read(buffered, binary.LittleEndian, &expectedHeaderChecksum)
+		if err != nil {
+			return modelConfig, fmt.Errorf("binaryModelError: Error reading header: %v", err)
+		}
+		if headerChecksum.Sum32() != expectedHeaderChecksum {
+			return modelConfig, fmt.Errorf("binaryModelError: Header checksum mismatch")
+		}
+	}
+	var expectedChecksum uint32
+	err = binary.Read(buffered, binary.LittleEndian, &expectedChecksum)
+	if err != nil {
+		return modelConfig, fmt.Errorf("binaryModelError: Error reading header: %v", err)
+	}
+
+	modelConfig.NumFeatures = int(header.NumFeatures)
+	modelConfig.Epochs = int(header.Epochs)
+	modelConfig.NumWorkers = int(header.NumWorkers)
+	modelConfig.LearningRate = header.LearningRate
+	modelConfig.Regularization = header.Regularization
+	modelConfig.GlobalMean = header.GlobalMean
+	modelConfig.Algorithm = string(algorithm)
+	modelConfig.Biased = header.Flags&binaryFlagBiased != 0
+
+	checksum := crc32.NewIEEE()
+	payload := io.TeeReader(buffered, checksum)
+	useFloat32 := header.Flags&binaryFlagFloat32 != 0
+	numItems, numUsers, numFeatures := int(header.NumItems), int(header.NumUsers), int(header.NumFeatures)
+
+	modelConfig.Q, err = readMatrix(payload, numItems, numFeatures, useFloat32)
+	if err != nil {
+		return modelConfig, err
+	}
+	if modelConfig.Biased {
+		modelConfig.ItemBias, err = readFloats(payload, numItems, useFloat32)
+		if err != nil {
+			return modelConfig, err
+		}
+	}
+	if header.Flags&binaryFlagHasP != 0 {
+		modelConfig.P, err = readMatrix(payload, numUsers, numFeatures, useFloat32)
+		if err != nil {
+			return modelConfig, err
+		}
+		if modelConfig.Biased {
+			modelConfig.UserBias, err = readFloats(payload, numUsers, useFloat32)
+			if err != nil {
+				return modelConfig, err
+			}
+		}
+	}
+	if header.Flags&binaryFlagItemIds != 0 {
+		modelConfig.ItemIds, err = readStrings(payload, numItems)
+		if err != nil {
+			return modelConfig, err
+		}
+	}
+	if header.Flags&binaryFlagUserIds != 0 {
+		modelConfig.UserIds, err = readStrings(payload, numUsers)
+		if err != nil {
+			return modelConfig, err
+		}
+	}
+	if checksum.Sum32() != expectedChecksum {
+		return modelConfig, fmt.Errorf("binaryModelError: Checksum mismatch")
+	}
+	return modelConfig, nil
+}
+
+func readMatrix(reader io.Reader, rows, cols int, useFloat32 bool) ([][]float64, error) {
+	matrix := make([][]float64, rows)
+	for i := range matrix {
+		row, err := readFloats(reader, cols, useFloat32)
+		if err != nil {
+			return nil, err
+		}
+		matrix[i] = row
+	}
+	return matrix, nil
+}
+
+func readFloats(reader io.Reader, n int, useFloat32 bool) ([]float64, error) {
+	size := 8
+	if useFloat32 {
+		size = 4
+	}
+	buffer := make([]byte, n*size)
+	_, err := io.ReadFull(reader, buffer)
+	if err != nil {
+		return nil, fmt.Errorf("binaryModelError: Truncated payload: %v", err)
+	}
+	values := make([]float64, n)
+	for i := range values {
+		if useFloat32 {
+			values[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(buffer[i*4:])))
+		} else {
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(buffer[i*8:]))
+		}
+	}
+	return values, nil
+}
+
+// maxIdLength acota la longitud de un id para no reservar memoria de más con
+// un archivo corrupto.
+const maxIdLength = 1 << 16
+
+func readStrings(reader io.Reader, n int) ([]string, error) {
+	values := make([]string, n)
+	var buffer [4]byte
+	for i := range values {
+		_, err := io.ReadFull(reader, buffer[:])
+		if err != nil {
+			return nil, fmt.Errorf("binaryModelError: Truncated payload: %v", err)
+		}
+		length := binary.LittleEndian.Uint32(buffer[:])
+		if length > maxIdLength {
+			return nil, fmt.Errorf("binaryModelError: Id of %d bytes is too long", length)
+		}
+		value := make([]byte, length)
+		_, err = io.ReadFull(reader, value)
+		if err != nil {
+			return nil, fmt.Errorf("binaryModelError: Truncated payload: %v", err)
+		}
+		values[i] = string(value)
+	}
+	return values, nil
+}
+
+// LoadModelFile lee un modelo guardado con ParamsToBinary o ParamsToJson,
+// detectando el formato por la cabecera.
+func LoadModelFile(filename string) (ModelConfig, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return ModelConfig{}, fmt.Errorf("modelFileError: Error opening file %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magic, err := reader.Peek(len(binaryMagic))
+	if err == nil && bytes.Equal(magic, []byte(binaryMagic)) {
+		return ReadModelBinary(reader)
+	}
+	var modelConfig ModelConfig
+	err = json.NewDecoder(reader).Decode(&modelConfig)
+	if err != nil {
+		return modelConfig, fmt.Errorf("modelFileError: Error decoding json file %s: %v", filename, err)
+	}
+	return modelConfig, nil
+}
@@ -0,0 +1,176 @@
+package model
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const CheckpointFilename = "checkpoint.gob"
+
+// checkpointParams identifica el entrenamiento: solo se puede reanudar un
+// checkpoint con los mismos hiperparámetros, la misma semilla y cantidad de
+// workers (que cambian el orden de SGD) y las mismas dimensiones de datos.
+type checkpointParams struct {
+	NumFeatures    int
+	Epochs         int
+	LearningRate   float64
+	Regularization float64
+	Algorithm      string
+	Biased         bool
+	Alpha          float64
+	RandomState    int
+	Deterministic  bool
+	NumWorkers     int
+	NumUsers       int
+	NumItems       int
+	NumRatings     int
+}
+
+// Se usa gob y no JSON porque el estado contiene infinitos (mejor pérdida aún
+// no definida).
+type checkpoint struct {
+	Params     checkpointParams
+	State      trainingState
+	Model      modelSnapshot
+	GlobalMean float64
+}
+
+func (model *Model) checkpointParams() checkpointParams {
+	// En modo determinista los bloques de SGD dependen de la cantidad de
+	// workers configurada y no de las CPUs; si no, cuenta la efectiva.
+	numWorkers := model.workers()
+	if model.deterministic {
+		numWorkers = model.numWorkers
+	}
+	params := checkpointParams{
+		NumFeatures:    model.numFeatures,
+		Epochs:         model.epochs,
+		LearningRate:   model.learningRate,
+		Regularization: model.regularization,
+		Algorithm:      model.algorithm,
+		Biased:         model.biased,
+		RandomState:    model.randomState,
+		Deterministic:  model.deterministic,
+		NumWorkers:     numWorkers,
+		NumUsers:       model.R.NumUsers(),
+		NumItems:       model.R.NumItems(),
+		NumRatings:     model.R.Len(),
+	}
+	if model.algorithm == AlgorithmImplicitALS {
+		params.Alpha = model.alpha
+	}
+	return params
+}
+
+func (model *Model) writeCheckpoint(dir string, state *trainingState) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("checkpointError: Error creating directory %s: %v", dir, err)
+	}
+	data := checkpoint{
+		Params: model.checkpointParams(),
+		State:  *state,
+		Model: modelSnapshot{
+			P:        model.P,
+			Q:        model.Q,
+			UserBias: model.UserBias,
+			ItemBias: model.ItemBias,
+		},
+		GlobalMean: model.GlobalMean,
+	}
+	filename := filepath.Join(dir, CheckpointFilename)
+	err = writeFileAtomic(filename, func(writer io.Writer) error {
+		return gob.NewEncoder(writer).Encode(&data)
+	})
+	if err != nil {
+		return fmt.Errorf("checkpointError: Error writing checkpoint: %v", err)
+	}
+	return nil
+}
+
+// resumeCheckpoint carga el último checkpoint de dir en el modelo y en state.
+// Devuelve false si todavía no hay checkpoint.
+func (model *Model) resumeCheckpoint(dir string, state *trainingState) (bool, error) {
+	filename := filepath.Join(dir, CheckpointFilename)
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("checkpointError: Error opening checkpoint %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	var data checkpoint
+	err = gob.NewDecoder(bufio.NewReader(file)).Decode(&data)
+	if err != nil {
+		return false, fmt.Errorf("checkpointError: Error decoding checkpoint %s: %v", filename, err)
+	}
+	if data.Params != model.checkpointParams() {
+		return false, fmt.Errorf("checkpointError: Checkpoint %s was written with different hyperparameters, seed or workers: %+v, current %+v", filename, data.Params, model.checkpointParams())
+	}
+
+	model.P = data.Model.P
+	model.Q = data.Model.Q
+	if model.biased {
+		model.UserBias = data.Model.UserBias
+		model.ItemBias = data.Model.ItemBias
+		model.GlobalMean = data.GlobalMean
+	}
+	*state = data.State
+	return true, nil
+}
+
+// writeFileAtomic escribe en un archivo temporal del mismo directorio y lo
+// renombra al final, de modo que un archivo a medio escribir nunca reemplaza a
+// uno válido. Se conservan los permisos del archivo anterior, o 0644 si no
+// existía (CreateTemp crea con 0600).
+func writeFileAtomic(filename string, write func(writer io.Writer) error) error {
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode().Perm()
+	}
+	err = tmp.Chmod(mode)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	err = write(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpName, filename)
+	if err != nil {
+		return err
+	}
+	if dirFile, err := os.Open(dir); err == nil {
+		dirFile.Sync()
+		dirFile.Close()
+	}
+	return nil
+}
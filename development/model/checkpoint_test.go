@@ -0,0 +1,65 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestResumeCheckpoint corta un entrenamiento determinista a mitad (como un
+// proceso que muere después de escribir el checkpoint) y espera que al
+// reanudarlo quede el mismo modelo que sin cortes.
+func TestResumeCheckpoint(t *testing.T) {
+	ratings := testRatings(40, 30)
+	config := ModelConfig{NumFeatures: 4, Epochs: 6, LearningRate: 0.01, Regularization: 0.01, Biased: true, Deterministic: true}
+	schedule := BoldDriver{Increase: 1.05, Decrease: 0.5}
+
+	uninterrupted := newTestModel(t, config, ratings)
+	want, err := uninterrupted.TrainWithOptions(TrainOptions{Schedule: schedule})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	crash := errors.New("crash")
+	interrupted := newTestModel(t, config, ratings)
+	_, err = interrupted.TrainWithOptions(TrainOptions{
+		Schedule:        schedule,
+		CheckpointDir:   dir,
+		CheckpointEvery: 2,
+		OnEpoch: []EpochCallback{func(model *Model, stats EpochStats) error {
+			if stats.Epoch == 2 {
+				return crash
+			}
+			return nil
+		}},
+	})
+	if err == nil {
+		t.Fatal("the interrupted training did not fail")
+	}
+
+	resumed := newTestModel(t, config, ratings)
+	got, err := resumed.TrainWithOptions(TrainOptions{Schedule: schedule, CheckpointDir: dir, Resume: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSameBits(t, "P", resumed.P, uninterrupted.P)
+	assertSameBits(t, "Q", resumed.Q, uninterrupted.Q)
+	assertSameBits(t, "UserBias", [][]float64{resumed.UserBias}, [][]float64{uninterrupted.UserBias})
+	assertSameBits(t, "ItemBias", [][]float64{resumed.ItemBias}, [][]float64{uninterrupted.ItemBias})
+	if len(got.Epochs) != len(want.Epochs) {
+		t.Fatalf("resumed report has %d epochs, want %d", len(got.Epochs), len(want.Epochs))
+	}
+	for i := range want.Epochs {
+		if got.Epochs[i].LearningRate != want.Epochs[i].LearningRate || got.Epochs[i].TrainRMSE != want.Epochs[i].TrainRMSE {
+			t.Errorf("epoch %d: got %+v, want %+v", i, got.Epochs[i], want.Epochs[i])
+		}
+	}
+
+	// Con otra semilla el checkpoint no se puede reanudar
+	other := newTestModel(t, config, ratings)
+	other.randomState++
+	_, err = other.TrainWithOptions(TrainOptions{Schedule: schedule, CheckpointDir: dir, Resume: true})
+	if err == nil {
+		t.Error("resumed a checkpoint written with another seed")
+	}
+}
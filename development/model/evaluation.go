@@ -0,0 +1,295 @@
+package model
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+const (
+	SplitRandom    = "random"
+	SplitLeaveKOut = "leave-k-out"
+	SplitTemporal  = "temporal"
+)
+
+type SplitConfig struct {
+	Method             string
+	ValidationFraction float64 // random y temporal
+	TestFraction       float64 // random y temporal
+	K                  int     // leave-k-out: ratings por usuario en validación y en test
+	RandomState        int
+}
+
+// DataSplit contiene particiones disjuntas con las mismas dimensiones que los
+// ratings originales, para que los ids de usuario e item coincidan.
+type DataSplit struct {
+	Train      *Ratings
+	Validation *Ratings
+	Test       *Ratings
+}
+
+func SplitRatings(ratings *Ratings, config SplitConfig) (DataSplit, error) {
+	entries := ratings.Entries()
+	r := rand.New(rand.NewSource(int64(config.RandomState)))
+	var train, validation, test []Rating
+
+	switch config.Method {
+	case SplitRandom, "":
+		if config.ValidationFraction < 0 || config.TestFraction < 0 || config.ValidationFraction+config.TestFraction >= 1 {
+			return DataSplit{}, fmt.Errorf("splitError: Invalid fractions %v/%v", config.ValidationFraction, config.TestFraction)
+		}
+		r.Shuffle(len(entries), func(i, j int) {
+			entries[i], entries[j] = entries[j], entries[i]
+		})
+		train, validation, test = splitByFractions(entries, config.ValidationFraction, config.TestFraction)
+	case SplitTemporal:
+		if config.ValidationFraction < 0 || config.TestFraction < 0 || config.ValidationFraction+config.TestFraction >= 1 {
+			return DataSplit{}, fmt.Errorf("splitError: Invalid fractions %v/%v", config.ValidationFraction, config.TestFraction)
+		}
+		if ratings.userTimes == nil {
+			return DataSplit{}, fmt.Errorf("splitError: Temporal split requires timestamps")
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Timestamp < entries[j].Timestamp
+		})
+		train, validation, test = splitByFractions(entries, config.ValidationFraction, config.TestFraction)
+	case SplitLeaveKOut:
+		if config.K <= 0 {
+			return DataSplit{}, fmt.Errorf("splitError: Leave-k-out requires k > 0")
+		}
+		start := 0
+		for start < len(entries) {
+			end := start
+			for end < len(entries) && entries[end].UserId == entries[start].UserId {
+				end++
+			}
+			user := entries[start:end]
+			// Se deja al menos un rating del usuario en entrenamiento.
+			if len(user) <= 2*config.K {
+				train = append(train, user...)
+				start = end
+				continue
+			}
+			r.Shuffle(len(user), func(i, j int) {
+				user[i], user[j] = user[j], user[i]
+			})
+			test = append(test, user[:config.K]...)
+			validation = append(validation, user[config.K:2*config.K]...)
+			train = append(train, user[2*config.K:]...)
+			start = end
+		}
+	default:
+		return DataSplit{}, fmt.Errorf("splitError: Unknown split method %q", config.Method)
+	}
+
+	numUsers, numItems := ratings.NumUsers(), ratings.NumItems()
+	return DataSplit{
+		Train:      NewRatings(numUsers, numItems, train),
+		Validation: NewRatings(numUsers, numItems, validation),
+		Test:       NewRatings(numUsers, numItems, test),
+	}, nil
+}
+
+func splitByFractions(entries []Rating, validationFraction, testFraction float64) ([]Rating, []Rating, []Rating) {
+	n := len(entries)
+	numTest := int(float64(n) * testFraction)
+	numValidation := int(float64(n) * validationFraction)
+	numTrain := n - numTest - numValidation
+	return entries[:numTrain], entries[numTrain : numTrain+numValidation], entries[numTrain+numValidation:]
+}
+
+type EvaluationConfig struct {
+	K int
+	// Un item del conjunto evaluado es relevante si su rating es >= RelevanceThreshold.
+	RelevanceThreshold float64
+	// MaxUsers limita los usuarios usados en las métricas de ranking (0 = todos).
+	MaxUsers    int
+	RandomState int
+}
+
+type Metrics struct {
+	RMSE      float64 `json:"rmse"`
+	MAE       float64 `json:"mae"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	NDCG      float64 `json:"ndcg"`
+	MAP       float64 `json:"map"`
+	Coverage  float64 `json:"coverage"`
+	K         int     `json:"k"`
+	Count     int     `json:"count"`
+	Users     int     `json:"users"`
+}
+
+// ErrorMetrics calcula RMSE y MAE sin término de regularización sobre ratings.
+func (model *Model) ErrorMetrics(ratings *Ratings) (float64, float64) {
+	squaredErrorSum := 0.0
+	absoluteErrorSum := 0.0
+	for u := 0; u < ratings.NumUsers(); u++ {
+		items, values := ratings.UserRow(u)
+		for n, itemId := range items {
+			target := float64(values[n])
+			if model.algorithm == AlgorithmImplicitALS {
+				// En el modelo implícito se predice la preferencia, no el peso.
+				target = 1
+			}
+			err := target - model.Predict(u, int(itemId))
+			squaredErrorSum += err * err
+			absoluteErrorSum += math.Abs(err)
+		}
+	}
+	if ratings.Len() == 0 {
+		return 0, 0
+	}
+	count := float64(ratings.Len())
+	return math.Sqrt(squaredErrorSum / count), absoluteErrorSum / count
+}
+
+// Evaluate mide el modelo sobre heldOut. Para las métricas de ranking se ordenan
+// todos los items que el usuario no calificó en seen (normalmente el conjunto de
+// entrenamiento) y se comparan los k primeros con sus items relevantes en heldOut.
+func (model *Model) Evaluate(heldOut, seen *Ratings, config EvaluationConfig) Metrics {
+	metrics := Metrics{K: config.K, Count: heldOut.Len()}
+	metrics.RMSE, metrics.MAE = model.ErrorMetrics(heldOut)
+	if config.K <= 0 {
+		return metrics
+	}
+
+	users := make([]int, 0)
+	for u := 0; u < heldOut.NumUsers(); u++ {
+		items, values := heldOut.UserRow(u)
+		for n := range items {
+			if float64(values[n]) >= config.RelevanceThreshold {
+				users = append(users, u)
+				break
+			}
+		}
+	}
+	if config.MaxUsers > 0 && len(users) > config.MaxUsers {
+		r := rand.New(rand.NewSource(int64(config.RandomState)))
+		r.Shuffle(len(users), func(i, j int) {
+			users[i], users[j] = users[j], users[i]
+		})
+		users = users[:config.MaxUsers]
+	}
+	if len(users) == 0 {
+		return metrics
+	}
+
+	type partial struct {
+		precision, recall, ndcg, ap float64
+		recommended                 map[int]bool
+	}
+	numWorkers := model.workers()
+	partials := make([]partial, numWorkers)
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			acc := partial{recommended: make(map[int]bool)}
+			for idx := w; idx < len(users); idx += numWorkers {
+				userId := users[idx]
+				relevant := make(map[int]bool)
+				items, values := heldOut.UserRow(userId)
+				for n, itemId := range items {
+					if float64(values[n]) >= config.RelevanceThreshold {
+						relevant[int(itemId)] = true
+					}
+				}
+				topK := model.rankItems(userId, seen, config.K)
+				hits := 0
+				dcg := 0.0
+				apSum := 0.0
+				for rank, itemId := range topK {
+					acc.recommended[itemId] = true
+					if relevant[itemId] {
+						hits++
+						dcg += 1 / math.Log2(float64(rank+2))
+						apSum += float64(hits) / float64(rank+1)
+					}
+				}
+				idcg := 0.0
+				for rank := 0; rank < len(relevant) && rank < config.K; rank++ {
+					idcg += 1 / math.Log2(float64(rank+2))
+				}
+				acc.precision += float64(hits) / float64(config.K)
+				acc.recall += float64(hits) / float64(len(relevant))
+				acc.ndcg += dcg / idcg
+				acc.ap += apSum / math.Min(float64(len(relevant)), float64(config.K))
+			}
+			partials[w] = acc
+		}(w)
+	}
+	wg.Wait()
+
+	recommended := make(map[int]bool)
+	for _, acc := range partials {
+		metrics.Precision += acc.precision
+		metrics.Recall += acc.recall
+		metrics.NDCG += acc.ndcg
+		metrics.MAP += acc.ap
+		for itemId := range acc.recommended {
+			recommended[itemId] = true
+		}
+	}
+	numUsers := float64(len(users))
+	metrics.Users = len(users)
+	metrics.Precision /= numUsers
+	metrics.Recall /= numUsers
+	metrics.NDCG /= numUsers
+	metrics.MAP /= numUsers
+	metrics.Coverage = float64(len(recommended)) / float64(len(model.Q))
+	return metrics
+}
+
+type scoredItem struct {
+	itemId int
+	score  float64
+}
+
+type scoredItemHeap []scoredItem
+
+func (h scoredItemHeap) Len() int           { return len(h) }
+func (h scoredItemHeap) Less(i, j int) bool { return h[i].score < h[j].score }
+func (h scoredItemHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *scoredItemHeap) Push(x any)        { *h = append(*h, x.(scoredItem)) }
+func (h *scoredItemHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// rankItems devuelve los k items con mayor predicción que el usuario no
+// calificó en seen.
+func (model *Model) rankItems(userId int, seen *Ratings, k int) []int {
+	var seenItems []int32
+	if seen != nil && userId < seen.NumUsers() {
+		seenItems, _ = seen.UserRow(userId)
+	}
+	h := make(scoredItemHeap, 0, k+1)
+	next := 0
+	for itemId := range model.Q {
+		for next < len(seenItems) && int(seenItems[next]) < itemId {
+			next++
+		}
+		if next < len(seenItems) && int(seenItems[next]) == itemId {
+			continue
+		}
+		score := model.Predict(userId, itemId)
+		if len(h) < k {
+			heap.Push(&h, scoredItem{itemId: itemId, score: score})
+		} else if score > h[0].score {
+			h[0] = scoredItem{itemId: itemId, score: score}
+			heap.Fix(&h, 0)
+		}
+	}
+	ranked := make([]int, len(h))
+	for i := len(h) - 1; i >= 0; i-- {
+		ranked[i] = heap.Pop(&h).(scoredItem).itemId
+	}
+	return ranked
+}
@@ -0,0 +1,120 @@
+package model
+
+import (
+	"fmt"
+)
+
+// defaultAlpha es el α de Hu, Koren y Volinsky para datos de visualizaciones.
+const defaultAlpha = 40.0
+
+// Feedback implícito con ALS ponderado por confianza (Hu, Koren y Volinsky): cada
+// celda (u, i) tiene preferencia p = 1 si hubo interacción y 0 si no, con
+// confianza c = 1 + α·r, donde r es el peso acumulado de las interacciones. Las
+// celdas vacías también entran a la pérdida con c = 1, pero su aporte a las
+// ecuaciones normales es QᵀQ (o PᵀP) para todas las filas, así que se calcula
+// una sola vez por media época.
+func (model *Model) implicitEpoch() {
+	model.updateUsersImplicit()
+	model.updateItemsImplicit()
+}
+
+func (model *Model) updateUsersImplicit() {
+	itemGram := factorsGram(model.Q, 0, len(model.Q), model.numFeatures)
+	parallelRows(model.R.NumUsers(), model.workers(), model.numFeatures, func(userId int, solver *normalSolver) {
+		items, values := model.R.UserRow(userId)
+		copy(solver.gram, itemGram)
+		for i := range solver.rhs {
+			solver.rhs[i] = 0
+		}
+		for n, item := range items {
+			solver.addConfidence(model.Q[item], model.confidence(float64(values[n])))
+		}
+		if solver.solve(model.regularization) == nil {
+			copy(model.P[userId], solver.solution)
+		}
+	})
+}
+
+func (model *Model) updateItemsImplicit() {
+	userGram := factorsGram(model.P, 0, len(model.P), model.numFeatures)
+	parallelRows(model.R.NumItems(), model.workers(), model.numFeatures, func(itemId int, solver *normalSolver) {
+		users, values := model.R.ItemColumn(itemId)
+		copy(solver.gram, userGram)
+		for i := range solver.rhs {
+			solver.rhs[i] = 0
+		}
+		for n, user := range users {
+			solver.addConfidence(model.P[user], model.confidence(float64(values[n])))
+		}
+		if solver.solve(model.regularization) == nil {
+			copy(model.Q[itemId], solver.solution)
+		}
+	})
+}
+
+func (model *Model) confidence(weight float64) float64 {
+	return 1 + model.alpha*weight
+}
+
+// addConfidence suma la corrección de una celda observada: (c-1)·q·qᵀ a la
+// matriz y c·q al lado derecho (la preferencia es 1).
+func (solver *normalSolver) addConfidence(factors []float64, confidence float64) {
+	k := solver.k
+	for a := 0; a < k; a++ {
+		solver.rhs[a] += confidence * factors[a]
+		wa := (confidence - 1) * factors[a]
+		row := solver.gram[a*k : a*k+k]
+		for b := 0; b <= a; b++ {
+			row[b] += wa * factors[b]
+		}
+	}
+}
+
+// factorsGram devuelve el triángulo inferior de Σ x·xᵀ para las filas
+// [start, end) de matrix.
+func factorsGram(matrix [][]float64, start, end, k int) []float64 {
+	gram := make([]float64, k*k)
+	for _, factors := range matrix[start:end] {
+		for a := 0; a < k; a++ {
+			row := gram[a*k : a*k+k]
+			for b := 0; b <= a; b++ {
+				row[b] += factors[a] * factors[b]
+			}
+		}
+	}
+	return gram
+}
+
+// ImplicitUserNormalEquations es la versión implícita de UserNormalEquations
+// para los items [startItem, endItem): además de las interacciones incluye el
+// término QᵀQ del rango, de modo que la suma de todos los rangos da el sistema
+// completo del usuario.
+func (model *Model) ImplicitUserNormalEquations(ratings SparseVector, startItem, endItem int) ([]float64, []float64, int) {
+	solver := newNormalSolver(model.numFeatures)
+	copy(solver.gram, factorsGram(model.Q, startItem, endItem, model.numFeatures))
+	for n, itemId := range ratings.Indices {
+		solver.addConfidence(model.Q[itemId], model.confidence(ratings.Values[n]))
+	}
+	k := solver.k
+	for a := 0; a < k; a++ {
+		for b := a + 1; b < k; b++ {
+			solver.gram[a*k+b] = solver.gram[b*k+a]
+		}
+	}
+	return solver.gram, solver.rhs, ratings.Len()
+}
+
+// SolveImplicitUserNormalEquations resuelve el sistema acumulado de un usuario
+// implícito; a diferencia de ALS-WR, λ no se escala por la cantidad de items.
+func SolveImplicitUserNormalEquations(gram, rhs []float64, regularization float64) ([]float64, error) {
+	k := len(rhs)
+	if len(gram) != k*k {
+		return nil, fmt.Errorf("alsError: Gram matrix size %d does not match %d features", len(gram), k)
+	}
+	userFactors := make([]float64, k)
+	err := solveCholesky(gram, rhs, regularization, k, make([]float64, k*k), userFactors)
+	if err != nil {
+		return nil, err
+	}
+	return userFactors, nil
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// IncrementalConfig configura las pasadas de SGD sobre el feedback nuevo. Los
+// campos en cero toman los valores del modelo (épocas por defecto 3).
+type IncrementalConfig struct {
+	Epochs         int     `json:"epochs,omitempty"`
+	LearningRate   float64 `json:"learningRate,omitempty"`
+	Regularization float64 `json:"regularization,omitempty"`
+	RandomState    int     `json:"randomState,omitempty"`
+}
+
+const defaultIncrementalEpochs = 3
+
+// UserHistory es un usuario con feedback nuevo. New son los ratings nuevos,
+// los únicos sobre los que se entrena; Ratings es su historial completo y solo
+// se usa para plegarlo con Q si Factors está vacío. Factors y Bias son el
+// punto de partida y al terminar quedan los actualizados.
+type UserHistory struct {
+	UserId  int
+	Ratings SparseVector
+	New     SparseVector
+	Factors []float64
+	Bias    float64
+}
+
+// UpdateFromFeedback corre pasadas de SGD sobre los ratings nuevos de los
+// usuarios, moviendo sus factores y las filas de Q (y sesgos) de las películas
+// calificadas. Devuelve las películas modificadas en orden ascendente. Los
+// usuarios del entrenamiento original no se tocan, solo los de users.
+func (model *Model) UpdateFromFeedback(users []UserHistory, config IncrementalConfig) ([]int, error) {
+	if model.algorithm == AlgorithmImplicitALS {
+		return nil, fmt.Errorf("incrementalError: Implicit feedback models are not updated incrementally")
+	}
+	if config.Epochs <= 0 {
+		config.Epochs = defaultIncrementalEpochs
+	}
+	if config.LearningRate <= 0 {
+		config.LearningRate = model.learningRate
+	}
+	if config.Regularization <= 0 {
+		config.Regularization = model.regularization
+	}
+
+	type userRating struct {
+		user, item int
+		value      float64
+	}
+	var ratings []userRating
+	changed := map[int]bool{}
+	for u := range users {
+		user := &users[u]
+		for _, vector := range []SparseVector{user.Ratings, user.New} {
+			for _, itemId := range vector.Indices {
+				if itemId < 0 || itemId >= len(model.Q) {
+					return nil, fmt.Errorf("incrementalError: User %d rated item %d out of range [0, %d)", user.UserId, itemId, len(model.Q))
+				}
+			}
+		}
+		for n, itemId := range user.New.Indices {
+			ratings = append(ratings, userRating{user: u, item: itemId, value: user.New.Values[n]})
+			changed[itemId] = true
+		}
+		if len(user.Factors) != model.numFeatures {
+			history := user.Ratings
+			if len(history.Indices) == 0 {
+				history = user.New
+			}
+			factors, bias, err := model.SolveUserFactors(history)
+			if err != nil {
+				return nil, fmt.Errorf("incrementalError: Error folding in user %d: %v", user.UserId, err)
+			}
+			user.Factors, user.Bias = factors, bias
+		} else {
+			user.Factors = append([]float64(nil), user.Factors...)
+		}
+	}
+
+	random := rand.New(rand.NewSource(int64(config.RandomState)))
+	lr, reg := config.LearningRate, config.Regularization
+	for epoch := 0; epoch < config.Epochs; epoch++ {
+		random.Shuffle(len(ratings), func(a, b int) {
+			ratings[a], ratings[b] = ratings[b], ratings[a]
+		})
+		for _, rating := range ratings {
+			user := &users[rating.user]
+			itemFactors := model.Q[rating.item]
+			err := rating.value - model.PredictUser(user.Factors, user.Bias, rating.item)
+			if model.biased {
+				user.Bias += lr * (err - reg*user.Bias)
+				model.ItemBias[rating.item] += lr * (err - reg*model.ItemBias[rating.item])
+			}
+			for k := 0; k < model.numFeatures; k++ {
+				userGrad := lr * (err*itemFactors[k] - reg*user.Factors[k])
+				itemGrad := lr * (err*user.Factors[k] - reg*itemFactors[k])
+				user.Factors[k] += userGrad
+				itemFactors[k] += itemGrad
+			}
+		}
+	}
+
+	items := make([]int, 0, len(changed))
+	for itemId := range changed {
+		items = append(items, itemId)
+	}
+	sort.Ints(items)
+	return items, nil
+}
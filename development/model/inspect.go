@@ -0,0 +1,225 @@
+package model
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// explodingFactor marca una fila como explotada cuando su norma supera esta
+// cantidad de veces la norma mediana de su matriz.
+const explodingFactor = 10
+
+// MatrixStats resume una matriz de factores: la distribución de las normas de
+// sus filas y la de todos sus valores.
+type MatrixStats struct {
+	Rows int `json:"rows"`
+	Cols int `json:"cols"`
+	// Filas con algún NaN o infinito; no entran en el resto de las estadísticas.
+	NaNRows      int       `json:"nanRows"`
+	InfRows      int       `json:"infRows"`
+	ZeroRows     int       `json:"zeroRows"`
+	ExplodedRows []int     `json:"explodedRows,omitempty"`
+	NormMin      float64   `json:"normMin"`
+	NormMean     float64   `json:"normMean"`
+	NormMax      float64   `json:"normMax"`
+	NormQuantile []float64 `json:"normQuantiles"` // p10, p50, p90, p99
+	ValueMin     float64   `json:"valueMin"`
+	ValueMean    float64   `json:"valueMean"`
+	ValueStd     float64   `json:"valueStd"`
+	ValueMax     float64   `json:"valueMax"`
+}
+
+var inspectQuantiles = []float64{0.1, 0.5, 0.9, 0.99}
+
+type InspectionReport struct {
+	Algorithm   string       `json:"algorithm"`
+	NumFeatures int          `json:"numFeatures"`
+	Biased      bool         `json:"biased"`
+	P           MatrixStats  `json:"P"`
+	Q           MatrixStats  `json:"Q"`
+	UserBias    *MatrixStats `json:"userBias,omitempty"`
+	ItemBias    *MatrixStats `json:"itemBias,omitempty"`
+	// Warnings describe los problemas encontrados (NaN, infinitos, filas explotadas).
+	Warnings []string `json:"warnings"`
+}
+
+func (report *InspectionReport) Healthy() bool {
+	return len(report.Warnings) == 0
+}
+
+// Inspect calcula el reporte de los factores del modelo.
+func (model *Model) Inspect() InspectionReport {
+	report := InspectionReport{
+		Algorithm:   model.algorithm,
+		NumFeatures: model.numFeatures,
+		Biased:      model.biased,
+		P:           matrixStats(model.P),
+		Q:           matrixStats(model.Q),
+		Warnings:    []string{},
+	}
+	if model.biased {
+		userBias := matrixStats(columnMatrix(model.UserBias))
+		itemBias := matrixStats(columnMatrix(model.ItemBias))
+		report.UserBias = &userBias
+		report.ItemBias = &itemBias
+	}
+	checks := []struct {
+		name  string
+		stats *MatrixStats
+		cols  int
+	}{{"P", &report.P, model.numFeatures}, {"Q", &report.Q, model.numFeatures}, {"userBias", report.UserBias, 1}, {"itemBias", report.ItemBias, 1}}
+	for _, check := range checks {
+		if check.stats == nil {
+			continue
+		}
+		if check.stats.NaNRows > 0 {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s has %d rows with NaN", check.name, check.stats.NaNRows))
+		}
+		if check.stats.InfRows > 0 {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s has %d rows with Inf", check.name, check.stats.InfRows))
+		}
+		// En los sesgos la norma es |b| y valores lejos de la mediana son normales.
+		if len(check.stats.ExplodedRows) > 0 && check.cols > 1 {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s has %d rows with norm over %d times the median", check.name, len(check.stats.ExplodedRows), explodingFactor))
+		}
+		if check.stats.Rows > 0 && check.stats.Cols != check.cols {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s has %d columns, expected %d", check.name, check.stats.Cols, check.cols))
+		}
+	}
+	return report
+}
+
+func columnMatrix(values []float64) [][]float64 {
+	matrix := make([][]float64, len(values))
+	for i := range values {
+		matrix[i] = values[i : i+1]
+	}
+	return matrix
+}
+
+func matrixStats(matrix [][]float64) MatrixStats {
+	stats := MatrixStats{Rows: len(matrix), NormQuantile: make([]float64, len(inspectQuantiles))}
+	if len(matrix) > 0 {
+		stats.Cols = len(matrix[0])
+	}
+	norms := make([]float64, 0, len(matrix))
+	rows := make([]int, 0, len(matrix))
+	stats.ValueMin, stats.ValueMax = math.Inf(1), math.Inf(-1)
+	sum, sumSquares, count := 0.0, 0.0, 0
+	for i, row := range matrix {
+		hasNaN, hasInf := false, false
+		for _, value := range row {
+			hasNaN = hasNaN || math.IsNaN(value)
+			hasInf = hasInf || math.IsInf(value, 0)
+		}
+		if hasNaN {
+			stats.NaNRows++
+			continue
+		}
+		if hasInf {
+			stats.InfRows++
+			continue
+		}
+		norm := 0.0
+		for _, value := range row {
+			norm += value * value
+			sum += value
+			sumSquares += value * value
+			stats.ValueMin = math.Min(stats.ValueMin, value)
+			stats.ValueMax = math.Max(stats.ValueMax, value)
+		}
+		count += len(row)
+		norm = math.Sqrt(norm)
+		if norm == 0 {
+			stats.ZeroRows++
+		}
+		norms = append(norms, norm)
+		rows = append(rows, i)
+	}
+	if count == 0 {
+		stats.ValueMin, stats.ValueMax = 0, 0
+		return stats
+	}
+	stats.ValueMean = sum / float64(count)
+	stats.ValueStd = math.Sqrt(math.Max(sumSquares/float64(count)-stats.ValueMean*stats.ValueMean, 0))
+
+	sorted := append([]float64(nil), norms...)
+	sort.Float64s(sorted)
+	stats.NormMin, stats.NormMax = sorted[0], sorted[len(sorted)-1]
+	for _, norm := range sorted {
+		stats.NormMean += norm
+	}
+	stats.NormMean /= float64(len(sorted))
+	for q, quantile := range inspectQuantiles {
+		stats.NormQuantile[q] = sorted[int(quantile*float64(len(sorted)-1))]
+	}
+	median := stats.NormQuantile[1]
+	for n, norm := range norms {
+		if median > 0 && norm > explodingFactor*median {
+			stats.ExplodedRows = append(stats.ExplodedRows, rows[n])
+		}
+	}
+	return stats
+}
+
+type Neighbor struct {
+	ItemId     int     `json:"itemId"`
+	Similarity float64 `json:"similarity"`
+}
+
+// SimilarItems devuelve los k items más parecidos a itemId por similitud coseno
+// entre filas de Q, de mayor a menor.
+func (model *Model) SimilarItems(itemId, k int) ([]Neighbor, error) {
+	if itemId < 0 || itemId >= len(model.Q) {
+		return nil, fmt.Errorf("inspectError: Item %d out of range [0, %d)", itemId, len(model.Q))
+	}
+	return model.NearestItems(model.Q[itemId], 0, len(model.Q), k, func(other int) bool { return other != itemId }), nil
+}
+
+// NearestItems busca entre los items [start, end) que acepta accept (nil acepta
+// todos) los k de mayor similitud coseno con query. Los items con factores
+// nulos o no finitos se ignoran.
+func (model *Model) NearestItems(query []float64, start, end, k int, accept func(itemId int) bool) []Neighbor {
+	queryNorm := vectorNorm(query)
+	if queryNorm == 0 || k <= 0 {
+		return []Neighbor{}
+	}
+	h := make(scoredItemHeap, 0, k+1)
+	for itemId := start; itemId < end; itemId++ {
+		if accept != nil && !accept(itemId) {
+			continue
+		}
+		factors := model.Q[itemId]
+		norm := vectorNorm(factors)
+		if norm == 0 || math.IsNaN(norm) || math.IsInf(norm, 0) {
+			continue
+		}
+		dot := 0.0
+		for f := range query {
+			dot += query[f] * factors[f]
+		}
+		similarity := dot / (queryNorm * norm)
+		if len(h) < k {
+			heap.Push(&h, scoredItem{itemId: itemId, score: similarity})
+		} else if similarity > h[0].score {
+			h[0] = scoredItem{itemId: itemId, score: similarity}
+			heap.Fix(&h, 0)
+		}
+	}
+	neighbors := make([]Neighbor, len(h))
+	for i := len(h) - 1; i >= 0; i-- {
+		item := heap.Pop(&h).(scoredItem)
+		neighbors[i] = Neighbor{ItemId: item.itemId, Similarity: item.score}
+	}
+	return neighbors
+}
+
+func vectorNorm(vector []float64) float64 {
+	norm := 0.0
+	for _, value := range vector {
+		norm += value * value
+	}
+	return math.Sqrt(norm)
+}
@@ -0,0 +1,461 @@
+package model
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// HeaderAuto trata la primera fila como encabezado si sus columnas
+	// numéricas no se pueden leer como números.
+	HeaderAuto    = "auto"
+	HeaderPresent = "present"
+	HeaderAbsent  = "absent"
+)
+
+// DatasetConfig describe cómo leer un archivo de ratings o de eventos. Las
+// columnas empiezan en 0 y -1 indica que la columna no existe.
+type DatasetConfig struct {
+	Delimiter       string `json:"delimiter"`
+	Header          string `json:"header"`
+	UserColumn      int    `json:"userColumn"`
+	ItemColumn      int    `json:"itemColumn"`
+	ValueColumn     int    `json:"valueColumn"`
+	TimestampColumn int    `json:"timestampColumn"`
+	// MapIds reemplaza los ids externos por índices densos 0..n-1 (ver IdMap);
+	// sin MapIds los ids tienen que ser enteros no negativos y se usan tal cual
+	// como índices de la matriz.
+	MapIds bool `json:"mapIds"`
+	// Implicit suma los pesos de las filas repetidas de un mismo par
+	// usuario-item (peso 1 si no hay columna de valor); si no, gana la última.
+	Implicit bool `json:"implicit"`
+	// SkipInvalidRows descarta las filas mal formadas y las devuelve en
+	// Dataset.Skipped; si no, la primera fila inválida es un error.
+	SkipInvalidRows bool `json:"skipInvalidRows"`
+	// Users e Items fijan los índices de MapIds, por ejemplo los guardados con
+	// un modelo; las filas con ids que no están se descartan y se cuentan en
+	// Dataset.Unknown.
+	Users *IdMap `json:"-"`
+	Items *IdMap `json:"-"`
+}
+
+// DefaultDatasetConfig es el formato del CSV limpio: userId;movieId;rating[;timestamp].
+func DefaultDatasetConfig() DatasetConfig {
+	return DatasetConfig{
+		Delimiter:       ";",
+		Header:          HeaderAuto,
+		UserColumn:      0,
+		ItemColumn:      1,
+		ValueColumn:     2,
+		TimestampColumn: 3,
+	}
+}
+
+// RowError es una fila inválida del archivo.
+type RowError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (rowError *RowError) Error() string {
+	if rowError.Column < 0 {
+		return fmt.Sprintf("line %d: %v", rowError.Line, rowError.Err)
+	}
+	return fmt.Sprintf("line %d, column %d: %v", rowError.Line, rowError.Column+1, rowError.Err)
+}
+
+func (rowError *RowError) Unwrap() error {
+	return rowError.Err
+}
+
+type Dataset struct {
+	Ratings *Ratings
+	// Users e Items son nil si no se usó MapIds.
+	Users   *IdMap
+	Items   *IdMap
+	Skipped []RowError
+	// Unknown son las filas descartadas por ids fuera de config.Users o config.Items.
+	Unknown int
+}
+
+// IdMap traduce ids externos (los del archivo) a índices internos de la matriz
+// y viceversa. Se guarda con el modelo para que las filas de Q y los títulos del
+// master no se puedan desalinear.
+type IdMap struct {
+	ids   []string
+	index map[string]int
+}
+
+// NewIdMap crea el diccionario donde el índice interno de ids[i] es i.
+func NewIdMap(ids []string) (*IdMap, error) {
+	idMap := &IdMap{ids: ids, index: make(map[string]int, len(ids))}
+	for i, id := range ids {
+		if _, ok := idMap.index[id]; ok {
+			return nil, fmt.Errorf("idMapError: Duplicated id %q", id)
+		}
+		idMap.index[id] = i
+	}
+	return idMap, nil
+}
+
+func (idMap *IdMap) Len() int {
+	return len(idMap.ids)
+}
+
+// Index devuelve el índice interno de un id externo.
+func (idMap *IdMap) Index(id string) (int, bool) {
+	i, ok := idMap.index[id]
+	return i, ok
+}
+
+// Id devuelve el id externo de un índice interno.
+func (idMap *IdMap) Id(index int) string {
+	return idMap.ids[index]
+}
+
+func (idMap *IdMap) Ids() []string {
+	return idMap.ids
+}
+
+func (idMap *IdMap) MarshalJSON() ([]byte, error) {
+	return json.Marshal(idMap.ids)
+}
+
+func (idMap *IdMap) UnmarshalJSON(bytes []byte) error {
+	var ids []string
+	err := json.Unmarshal(bytes, &ids)
+	if err != nil {
+		return err
+	}
+	loaded, err := NewIdMap(ids)
+	if err != nil {
+		return err
+	}
+	*idMap = *loaded
+	return nil
+}
+
+// newSortedIdMap ordena los ids numéricamente si todos son enteros y
+// lexicográficamente si no, para que el mapeo no dependa del orden del archivo.
+func newSortedIdMap(seen map[string]bool) *IdMap {
+	ids := make([]string, 0, len(seen))
+	numeric := true
+	for id := range seen {
+		ids = append(ids, id)
+		if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+			numeric = false
+		}
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		if numeric {
+			x, _ := strconv.ParseInt(ids[a], 10, 64)
+			y, _ := strconv.ParseInt(ids[b], 10, 64)
+			return x < y
+		}
+		return ids[a] < ids[b]
+	})
+	idMap, _ := NewIdMap(ids)
+	return idMap
+}
+
+type datasetRow struct {
+	user      string
+	item      string
+	value     float64
+	timestamp int64
+}
+
+func (config *DatasetConfig) validate() error {
+	if utf8.RuneCountInString(config.Delimiter) != 1 {
+		return fmt.Errorf("datasetConfigError: Delimiter must be a single character, got %q", config.Delimiter)
+	}
+	if config.UserColumn < 0 || config.ItemColumn < 0 {
+		return fmt.Errorf("datasetConfigError: User and item columns are required")
+	}
+	if config.ValueColumn < 0 && !config.Implicit {
+		return fmt.Errorf("datasetConfigError: Explicit ratings require a value column")
+	}
+	switch config.Header {
+	case "", HeaderAuto, HeaderPresent, HeaderAbsent:
+	default:
+		return fmt.Errorf("datasetConfigError: Unknown header mode %q", config.Header)
+	}
+	return nil
+}
+
+// LoadDataset lee un archivo delimitado según config. Los valores 0 se
+// consideran desconocidos y se descartan, como en la matriz densa original.
+func LoadDataset(filename string, config DatasetConfig) (*Dataset, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("trainFileError: Error opening file %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	dataset, err := ReadDataset(file, config)
+	if err != nil {
+		return nil, fmt.Errorf("trainFileError: %s: %v", filename, err)
+	}
+	if len(dataset.Skipped) > 0 {
+		log.Printf("INFO: loadDataset: Skipped %d invalid rows in %s, first at %v", len(dataset.Skipped), filename, &dataset.Skipped[0])
+	}
+	if dataset.Unknown > 0 {
+		log.Printf("INFO: loadDataset: Skipped %d rows with unknown ids in %s", dataset.Unknown, filename)
+	}
+	return dataset, nil
+}
+
+func ReadDataset(reader io.Reader, config DatasetConfig) (*Dataset, error) {
+	err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+	delimiter, _ := utf8.DecodeRuneInString(config.Delimiter)
+	csvReader := csv.NewReader(reader)
+	csvReader.Comma = delimiter
+	csvReader.FieldsPerRecord = -1
+	csvReader.ReuseRecord = true
+
+	dataset := &Dataset{}
+	var rows []datasetRow
+	first := true
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Un registro ilegible también cuenta como primero: si no, la
+			// siguiente fila válida se tomaría por el encabezado.
+			first = false
+			var parseError *csv.ParseError
+			if !errors.As(err, &parseError) {
+				return nil, fmt.Errorf("datasetError: Error reading data: %v", err)
+			}
+			rowError := RowError{Line: parseError.Line, Column: -1, Err: parseError.Err}
+			if !config.SkipInvalidRows {
+				return nil, &rowError
+			}
+			dataset.Skipped = append(dataset.Skipped, rowError)
+			continue
+		}
+
+		line, _ := csvReader.FieldPos(0)
+		row, rowError := config.parseRow(record, line)
+		if first {
+			first = false
+			if config.Header == HeaderPresent || (config.Header != HeaderAbsent && rowError != nil && config.looksLikeHeader(record)) {
+				continue
+			}
+		}
+		if rowError != nil {
+			if !config.SkipInvalidRows {
+				return nil, rowError
+			}
+			dataset.Skipped = append(dataset.Skipped, *rowError)
+			continue
+		}
+		if row.value != 0 {
+			rows = append(rows, row)
+		}
+	}
+
+	err = config.buildRatings(dataset, rows)
+	if err != nil {
+		return nil, err
+	}
+	return dataset, nil
+}
+
+func (config *DatasetConfig) parseRow(record []string, line int) (datasetRow, *RowError) {
+	var row datasetRow
+	required := []int{config.UserColumn, config.ItemColumn}
+	if !config.Implicit {
+		required = append(required, config.ValueColumn)
+	}
+	for _, column := range required {
+		if column >= len(record) {
+			return row, &RowError{Line: line, Column: -1, Err: fmt.Errorf("expected at least %d fields, got %d", column+1, len(record))}
+		}
+	}
+	row.user = strings.TrimSpace(record[config.UserColumn])
+	row.item = strings.TrimSpace(record[config.ItemColumn])
+	for _, column := range []int{config.UserColumn, config.ItemColumn} {
+		id := strings.TrimSpace(record[column])
+		if id == "" {
+			return row, &RowError{Line: line, Column: column, Err: fmt.Errorf("empty id")}
+		}
+		if !config.MapIds {
+			value, err := strconv.Atoi(id)
+			if err != nil {
+				return row, &RowError{Line: line, Column: column, Err: fmt.Errorf("invalid id %q", id)}
+			}
+			if value < 0 {
+				return row, &RowError{Line: line, Column: column, Err: fmt.Errorf("negative id %d", value)}
+			}
+		}
+	}
+
+	// En feedback implícito el valor es opcional y vale 1.
+	row.value = 1
+	if config.ValueColumn >= 0 && config.ValueColumn < len(record) {
+		field := strings.TrimSpace(record[config.ValueColumn])
+		if field != "" || !config.Implicit {
+			value, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return row, &RowError{Line: line, Column: config.ValueColumn, Err: fmt.Errorf("invalid value %q", field)}
+			}
+			row.value = value
+		}
+	}
+	// El timestamp es opcional: las filas cortas quedan sin timestamp.
+	if config.TimestampColumn >= 0 && config.TimestampColumn < len(record) {
+		field := strings.TrimSpace(record[config.TimestampColumn])
+		if field != "" {
+			timestamp, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return row, &RowError{Line: line, Column: config.TimestampColumn, Err: fmt.Errorf("invalid timestamp %q", field)}
+			}
+			row.timestamp = timestamp
+		}
+	}
+	return row, nil
+}
+
+// looksLikeHeader decide en modo auto si una primera fila inválida es un
+// encabezado: ninguna de sus columnas numéricas tiene un número.
+func (config *DatasetConfig) looksLikeHeader(record []string) bool {
+	columns := []int{config.ValueColumn, config.TimestampColumn}
+	if !config.MapIds {
+		columns = append(columns, config.UserColumn, config.ItemColumn)
+	}
+	for _, column := range columns {
+		if column < 0 || column >= len(record) {
+			continue
+		}
+		if _, err := strconv.ParseFloat(strings.TrimSpace(record[column]), 64); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (config *DatasetConfig) buildRatings(dataset *Dataset, rows []datasetRow) error {
+	userIndex := func(row datasetRow) int {
+		value, _ := strconv.Atoi(row.user)
+		return value
+	}
+	itemIndex := func(row datasetRow) int {
+		value, _ := strconv.Atoi(row.item)
+		return value
+	}
+	numUsers, numItems := 0, 0
+	if config.MapIds {
+		users := make(map[string]bool)
+		items := make(map[string]bool)
+		for _, row := range rows {
+			users[row.user] = true
+			items[row.item] = true
+		}
+		dataset.Users, dataset.Items = config.Users, config.Items
+		if dataset.Users == nil {
+			dataset.Users = newSortedIdMap(users)
+		}
+		if dataset.Items == nil {
+			dataset.Items = newSortedIdMap(items)
+		}
+		known := rows[:0]
+		for _, row := range rows {
+			_, userOk := dataset.Users.Index(row.user)
+			_, itemOk := dataset.Items.Index(row.item)
+			if userOk && itemOk {
+				known = append(known, row)
+			}
+		}
+		dataset.Unknown = len(rows) - len(known)
+		rows = known
+		userIndex = func(row datasetRow) int {
+			index, _ := dataset.Users.Index(row.user)
+			return index
+		}
+		itemIndex = func(row datasetRow) int {
+			index, _ := dataset.Items.Index(row.item)
+			return index
+		}
+		numUsers, numItems = dataset.Users.Len(), dataset.Items.Len()
+	} else {
+		for _, row := range rows {
+			numUsers = max(numUsers, userIndex(row)+1)
+			numItems = max(numItems, itemIndex(row)+1)
+		}
+	}
+
+	var builder ratingsBuilder
+	if !config.Implicit {
+		for _, row := range rows {
+			builder.add(userIndex(row), itemIndex(row), row.value, row.timestamp)
+		}
+		dataset.Ratings = builder.build(numUsers, numItems)
+		return nil
+	}
+
+	// Feedback implícito: se suman los pesos y se conserva el timestamp más reciente.
+	type cell struct {
+		userId, itemId int
+	}
+	positions := make(map[cell]int)
+	for _, row := range rows {
+		key := cell{userIndex(row), itemIndex(row)}
+		pos, ok := positions[key]
+		if !ok {
+			positions[key] = len(builder.users)
+			builder.add(key.userId, key.itemId, row.value, row.timestamp)
+			continue
+		}
+		builder.values[pos] += float32(row.value)
+		builder.times[pos] = max(builder.times[pos], row.timestamp)
+	}
+	// Un par cuyos pesos suman 0 o menos no cuenta como interacción.
+	var positive ratingsBuilder
+	for pos, value := range builder.values {
+		if value > 0 {
+			positive.add(int(builder.users[pos]), int(builder.items[pos]), float64(value), builder.times[pos])
+		}
+	}
+	dataset.Ratings = positive.build(numUsers, numItems)
+	return nil
+}
+
+// LoadRatings lee el CSV de ratings (userId;movieId;rating[;timestamp]) sin
+// materializar la matriz densa; los ids del archivo son los índices.
+func LoadRatings(filename string) (*Ratings, error) {
+	dataset, err := LoadDataset(filename, DefaultDatasetConfig())
+	if err != nil {
+		return nil, err
+	}
+	return dataset.Ratings, nil
+}
+
+// LoadImplicitFeedback lee un CSV de eventos (userId;itemId[;weight[;timestamp]])
+// con encabezado. Cada línea es una interacción (vista, click, reproducción
+// completa...) con peso 1 si no se indica; los pesos del mismo par usuario-item
+// se suman y se conserva el timestamp más reciente.
+func LoadImplicitFeedback(filename string) (*Ratings, error) {
+	config := DefaultDatasetConfig()
+	config.Implicit = true
+	dataset, err := LoadDataset(filename, config)
+	if err != nil {
+		return nil, err
+	}
+	return dataset.Ratings, nil
+}
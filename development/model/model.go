@@ -1,14 +1,20 @@
 package model
 
 import (
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"math"
 	"math/rand"
-	"os"
-	"strconv"
-	"sync"
+	"runtime"
+)
+
+const (
+	AlgorithmSGD = "sgd"
+	AlgorithmALS = "als"
+	// AlgorithmImplicitALS entrena con feedback implícito (ver implicit.go).
+	AlgorithmImplicitALS = "implicit-als"
 )
 
 type Model struct {
@@ -16,9 +22,23 @@ type Model struct {
 	epochs         int
 	learningRate   float64
 	regularization float64
-	R              [][]float64
+	algorithm      string
+	numWorkers     int
+	biased         bool
+	randomState    int
+	deterministic  bool
+	alpha          float64
+	userIds        *IdMap
+	itemIds        *IdMap
+	R              *Ratings
 	P              [][]float64
 	Q              [][]float64
+	// Sesgos del modelo con bias: μ, b_u y b_i
+	GlobalMean float64
+	UserBias   []float64
+	ItemBias   []float64
+	// Bloques de ratings de SGD, se arman en la primera época
+	blocks *sgdBlocks
 }
 
 type ModelConfig struct {
@@ -26,78 +46,192 @@ type ModelConfig struct {
 	Epochs         int         `json:"epochs"`
 	LearningRate   float64     `json:"learningRate"`
 	Regularization float64     `json:"regularization"`
-	R              [][]float64 `json:"R"`
+	Algorithm      string      `json:"algorithm,omitempty"`
+	NumWorkers     int         `json:"numWorkers,omitempty"`
+	Biased         bool        `json:"biased,omitempty"`
+	RandomState    int         `json:"randomState,omitempty"`
+	Deterministic  bool        `json:"deterministic,omitempty"`
+	Alpha          float64     `json:"alpha,omitempty"`
+	GlobalMean     float64     `json:"globalMean,omitempty"`
+	UserBias       []float64   `json:"userBias,omitempty"`
+	ItemBias       []float64   `json:"itemBias,omitempty"`
+	Ratings        *Ratings    `json:"ratings,omitempty"`
+	R              [][]float64 `json:"R,omitempty"`
 	P              [][]float64 `json:"P"`
 	Q              [][]float64 `json:"Q"`
+	// Ids externos de cada fila de P y Q cuando el dataset se cargó con MapIds
+	UserIds []string `json:"userIds,omitempty"`
+	ItemIds []string `json:"itemIds,omitempty"`
 }
 
+// LoadTrainData mantiene la API densa para datasets pequeños.
 func LoadTrainData(filename string) ([][]float64, error) {
-	file, err := os.Open(filename)
+	ratings, err := LoadRatings(filename)
 	if err != nil {
-		return nil, fmt.Errorf("trainFileError: Error opening file %s: %v", filename, err)
+		return nil, err
 	}
-	defer file.Close()
+	return ratings.Dense(), nil
+}
 
-	reader := csv.NewReader(file)
-	reader.Comma = ';'
+func NewModel(numFeatures, epochs int, learningRate, regularization float64, R *Ratings, randomState int) Model {
+	r := rand.New(rand.NewSource(int64(randomState)))
+	numUsers := R.NumUsers()
+	numItems := R.NumItems()
+	return Model{
+		numFeatures:    numFeatures,
+		epochs:         epochs,
+		learningRate:   learningRate,
+		regularization: regularization,
+		algorithm:      AlgorithmSGD,
+		randomState:    randomState,
+		alpha:          defaultAlpha,
+		R:              R,
+		P:              initMatrix(numUsers, numFeatures, r),
+		Q:              initMatrix(numItems, numFeatures, r),
+	}
+}
 
-	records, err := reader.ReadAll()
+// NewModelFromConfig crea un modelo sin entrenar con los hiperparámetros de
+// modelConfig, incluido el algoritmo de entrenamiento.
+func NewModelFromConfig(modelConfig *ModelConfig, R *Ratings, randomState int) (Model, error) {
+	model := NewModel(modelConfig.NumFeatures, modelConfig.Epochs, modelConfig.LearningRate, modelConfig.Regularization, R, randomState)
+	err := model.setAlgorithm(modelConfig.Algorithm, modelConfig.NumWorkers)
 	if err != nil {
-		return nil, fmt.Errorf("trainFileError: Error reading file %s: %v", filename, err)
+		return Model{}, err
 	}
-
-	maxUserId, maxMovieId := 0, 0
-	for _, record := range records[1:] {
-		userId, _ := strconv.Atoi(record[0])
-		movieId, _ := strconv.Atoi(record[1])
-		if userId > maxUserId {
-			maxUserId = userId
-		}
-		if movieId > maxMovieId {
-			maxMovieId = movieId
+	model.deterministic = modelConfig.Deterministic
+	model.setAlpha(modelConfig.Alpha)
+	if modelConfig.Biased {
+		if model.algorithm == AlgorithmImplicitALS {
+			return Model{}, fmt.Errorf("modelConfigError: Implicit feedback models do not support biases")
 		}
+		model.initBiases()
 	}
+	return model, nil
+}
 
-	ratingsMatrix := make([][]float64, maxUserId+1)
-	for i := range ratingsMatrix {
-		ratingsMatrix[i] = make([]float64, maxMovieId+1)
+func (model *Model) initBiases() {
+	model.biased = true
+	sum := 0.0
+	for u := 0; u < model.R.NumUsers(); u++ {
+		_, values := model.R.UserRow(u)
+		for _, value := range values {
+			sum += float64(value)
+		}
 	}
+	if model.R.Len() > 0 {
+		model.GlobalMean = sum / float64(model.R.Len())
+	}
+	model.UserBias = make([]float64, model.R.NumUsers())
+	model.ItemBias = make([]float64, model.R.NumItems())
+}
 
-	for _, record := range records[1:] {
-		userId, _ := strconv.Atoi(record[0])
-		movieId, _ := strconv.Atoi(record[1])
-		rating, _ := strconv.ParseFloat(record[2], 64)
-		ratingsMatrix[userId][movieId] = rating
+func (model *Model) setAlgorithm(algorithm string, numWorkers int) error {
+	switch algorithm {
+	case "":
+		model.algorithm = AlgorithmSGD
+	case AlgorithmSGD, AlgorithmALS, AlgorithmImplicitALS:
+		model.algorithm = algorithm
+	default:
+		return fmt.Errorf("modelConfigError: Unknown algorithm %q", algorithm)
 	}
+	model.numWorkers = numWorkers
+	return nil
+}
 
-	return ratingsMatrix, nil
+// setAlpha fija la escala de confianza del modelo implícito (0 = defaultAlpha).
+func (model *Model) setAlpha(alpha float64) {
+	if alpha <= 0 {
+		alpha = defaultAlpha
+	}
+	model.alpha = alpha
 }
 
-func NewModel(numFeatures, epochs int, learningRate, regularization float64, R [][]float64, randomState int) Model {
-	r := rand.New(rand.NewSource(int64(randomState)))
-	numUsers := len(R)
-	numItems := len(R[0])
-	return Model{
-		numFeatures:    numFeatures,
-		epochs:         epochs,
-		learningRate:   learningRate,
-		regularization: regularization,
-		R:              R,
-		P:              initMatrix(numUsers, numFeatures, r),
-		Q:              initMatrix(numItems, numFeatures, r),
+// workers devuelve el tamaño del pool de entrenamiento; 0 significa un worker por CPU.
+func (model *Model) workers() int {
+	if model.numWorkers > 0 {
+		return model.numWorkers
 	}
+	return runtime.NumCPU()
 }
 
+// NewDenseModel es el adaptador de NewModel para matrices densas pequeñas.
+func NewDenseModel(numFeatures, epochs int, learningRate, regularization float64, R [][]float64, randomState int) Model {
+	return NewModel(numFeatures, epochs, learningRate, regularization, NewRatingsFromDense(R), randomState)
+}
+
+// LoadModel arma el modelo de una configuración guardada. Los archivos sin
+// ratings (binarios o del master) dan un modelo con R nil que sirve para
+// predecir, inspeccionar y actualizar con feedback, pero no para entrenar.
 func LoadModel(modelConfig *ModelConfig) Model {
-	return Model{
+	R := modelConfig.Ratings
+	if R == nil && modelConfig.R != nil {
+		R = NewRatingsFromDense(modelConfig.R)
+	}
+	model := Model{
 		numFeatures:    modelConfig.NumFeatures,
 		epochs:         modelConfig.Epochs,
 		learningRate:   modelConfig.LearningRate,
 		regularization: modelConfig.Regularization,
-		R:              modelConfig.R,
+		biased:         modelConfig.Biased,
+		randomState:    modelConfig.RandomState,
+		deterministic:  modelConfig.Deterministic,
+		R:              R,
 		P:              modelConfig.P,
 		Q:              modelConfig.Q,
+		GlobalMean:     modelConfig.GlobalMean,
+		UserBias:       modelConfig.UserBias,
+		ItemBias:       modelConfig.ItemBias,
+	}
+	err := model.setAlgorithm(modelConfig.Algorithm, modelConfig.NumWorkers)
+	if err != nil {
+		// Un algoritmo desconocido solo afecta al entrenamiento; para servir se usa SGD.
+		model.setAlgorithm(AlgorithmSGD, modelConfig.NumWorkers)
 	}
+	model.setAlpha(modelConfig.Alpha)
+	// Ids inválidos solo hacen perder la traducción, no los factores.
+	if modelConfig.UserIds != nil {
+		model.userIds, _ = NewIdMap(modelConfig.UserIds)
+	}
+	if modelConfig.ItemIds != nil {
+		model.itemIds, _ = NewIdMap(modelConfig.ItemIds)
+	}
+	return model
+}
+
+func (model *Model) Algorithm() string {
+	return model.algorithm
+}
+
+func (model *Model) NumFeatures() int {
+	return model.numFeatures
+}
+
+func (model *Model) Biased() bool {
+	return model.biased
+}
+
+// SetIdMaps asocia los ids externos del dataset a las filas de P y Q; se
+// guardan con el modelo. Cualquiera de los dos puede ser nil.
+func (model *Model) SetIdMaps(users, items *IdMap) error {
+	if users != nil && users.Len() != len(model.P) {
+		return fmt.Errorf("modelConfigError: %d user ids for %d users", users.Len(), len(model.P))
+	}
+	if items != nil && items.Len() != len(model.Q) {
+		return fmt.Errorf("modelConfigError: %d item ids for %d items", items.Len(), len(model.Q))
+	}
+	model.userIds = users
+	model.itemIds = items
+	return nil
+}
+
+// UserIdMap devuelve nil si el modelo usa los ids del archivo como índices.
+func (model *Model) UserIdMap() *IdMap {
+	return model.userIds
+}
+
+func (model *Model) ItemIdMap() *IdMap {
+	return model.itemIds
 }
 
 func initMatrix(rows, cols int, r *rand.Rand) [][]float64 {
@@ -112,57 +246,45 @@ func initMatrix(rows, cols int, r *rand.Rand) [][]float64 {
 }
 
 func (model *Model) Train() {
-	var wg sync.WaitGroup
-	muQ := make([]sync.Mutex, len(model.R[0]))
-	for epoch := 0; epoch < model.epochs; epoch++ {
-		for userId := range model.R {
-			wg.Add(1)
-			go func(userId int) {
-				defer wg.Done()
-				for itemId := range model.R[userId] {
-					if model.R[userId][itemId] != 0 {
-						muQ[itemId].Lock()
-						pred := model.Predict(userId, itemId)
-						err := model.R[userId][itemId] - pred
-						for k := 0; k < model.numFeatures; k++ {
-							userGrad := model.learningRate * (err*model.Q[itemId][k] - model.regularization*model.P[userId][k])
-							itemGrad := model.learningRate * (err*model.P[userId][k] - model.regularization*model.Q[itemId][k])
-							model.P[userId][k] += userGrad
-							model.Q[itemId][k] += itemGrad
-						}
-						muQ[itemId].Unlock()
-					}
-				}
-			}(userId)
-		}
-		wg.Wait()
+	_, err := model.TrainWithOptions(TrainOptions{})
+	if err != nil {
+		log.Printf("ERROR: %s: %v", trainPrefix, err)
 	}
 }
 
 func (model *Model) Predict(userId, itemId int) float64 {
 	prediction := 0.0
+	if model.biased {
+		prediction = model.GlobalMean + model.UserBias[userId] + model.ItemBias[itemId]
+	}
 	for k := 0; k < model.numFeatures; k++ {
 		prediction += model.P[userId][k] * model.Q[itemId][k]
 	}
 	return prediction
 }
 
+// CalculateRMSE devuelve la pérdida regularizada sobre los datos de
+// entrenamiento; para medir el error real sobre datos reservados se usa Evaluate.
+// Es NaN si el modelo se cargó sin ratings.
 func (model *Model) CalculateRMSE() float64 {
+	if model.R == nil {
+		return math.NaN()
+	}
 	var squaredErrorSum float64
 	var regularizationSum float64
 	count := 0
 
-	for u := 0; u < len(model.P); u++ {
-		for i := 0; i < len(model.Q); i++ {
-			if model.R[u][i] > 0 {
-				pred := model.Predict(u, i)
-				err := model.R[u][i] - pred
-				squaredErrorSum += math.Pow(err, 2)
-				for k := 0; k < model.numFeatures; k++ {
-					regularizationSum += math.Pow(model.P[u][k], 2) + math.Pow(model.Q[i][k], 2)
-				}
-				count++
+	for u := 0; u < model.R.NumUsers(); u++ {
+		items, values := model.R.UserRow(u)
+		for n, item := range items {
+			i := int(item)
+			pred := model.Predict(u, i)
+			err := float64(values[n]) - pred
+			squaredErrorSum += math.Pow(err, 2)
+			for k := 0; k < model.numFeatures; k++ {
+				regularizationSum += math.Pow(model.P[u][k], 2) + math.Pow(model.Q[i][k], 2)
 			}
+			count++
 		}
 	}
 
@@ -170,23 +292,49 @@ func (model *Model) CalculateRMSE() float64 {
 	return rmse
 }
 
-func (model *Model) ParamsToJson(filename string) error {
-	params := ModelConfig{
+// Config devuelve la configuración serializable del modelo entrenado.
+func (model *Model) Config() ModelConfig {
+	modelConfig := ModelConfig{
 		NumFeatures:    model.numFeatures,
 		Epochs:         model.epochs,
 		LearningRate:   model.learningRate,
 		Regularization: model.regularization,
-		R:              model.R,
+		Algorithm:      model.algorithm,
+		NumWorkers:     model.numWorkers,
+		Biased:         model.biased,
+		RandomState:    model.randomState,
+		Deterministic:  model.deterministic,
+		GlobalMean:     model.GlobalMean,
+		UserBias:       model.UserBias,
+		ItemBias:       model.ItemBias,
+		Ratings:        model.R,
 		P:              model.P,
 		Q:              model.Q,
 	}
+	if model.algorithm == AlgorithmImplicitALS {
+		modelConfig.Alpha = model.alpha
+	}
+	if model.userIds != nil {
+		modelConfig.UserIds = model.userIds.Ids()
+	}
+	if model.itemIds != nil {
+		modelConfig.ItemIds = model.itemIds.Ids()
+	}
+	return modelConfig
+}
+
+func (model *Model) ParamsToJson(filename string) error {
+	params := model.Config()
 
 	jsonData, err := json.MarshalIndent(params, "", "\t")
 	if err != nil {
 		return fmt.Errorf("error marshalling params to JSON: %v", err)
 	}
 
-	err = os.WriteFile(filename, jsonData, 0644)
+	err = writeFileAtomic(filename, func(writer io.Writer) error {
+		_, err := writer.Write(jsonData)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("error writing JSON to file: %v", err)
 	}
@@ -194,77 +342,42 @@ func (model *Model) ParamsToJson(filename string) error {
 	return nil
 }
 
-type ModelGrid struct {
-	NumFeatures    []int
-	Epochs         []int
-	LearningRate   []float64
-	Regularization []float64
-}
-
-func SearchGrid(grid ModelGrid, R [][]float64) Model {
-	var bestModel Model
-	bestRMSE := math.Inf(1)
-	for _, numFeatures := range grid.NumFeatures {
-		for _, epochs := range grid.Epochs {
-			for _, learningRate := range grid.LearningRate {
-				for _, regularization := range grid.Regularization {
-					model := NewModel(numFeatures, epochs, learningRate, regularization, R, 1)
-					fmt.Println("--------------------")
-					fmt.Println("Model with:")
-					fmt.Println("numFeatures:", numFeatures)
-					fmt.Println("epochs:", epochs)
-					fmt.Println("learningRate:", learningRate)
-					fmt.Println("regularization:", regularization)
-					model.Train()
-					rmse := model.CalculateRMSE()
-					fmt.Println("RMSE:", rmse)
-					if rmse < bestRMSE {
-						bestRMSE = rmse
-						bestModel = model
-					}
-				}
-			}
-		}
-	}
-	fmt.Println("Best RMSE:", bestRMSE)
-	fmt.Println("numFeatures:", bestModel.numFeatures)
-	fmt.Println("epochs:", bestModel.epochs)
-	fmt.Println("learningRate:", bestModel.learningRate)
-	fmt.Println("regularization:", bestModel.regularization)
-	return bestModel
-}
-
-func (model *Model) UpdateUserFactors(ratings []float64, userFactors *[]float64, startItemId, endItemId int) ([]float64, int) {
+// UpdateUserFactors pliega por SGD a un usuario anónimo; en el modelo con bias
+// también ajusta su sesgo b_u.
+func (model *Model) UpdateUserFactors(ratings SparseVector, userFactors *[]float64, userBias *float64) ([]float64, float64, int) {
 	weightedGrad := make([]float64, model.numFeatures)
-	n := endItemId - startItemId
+	weightedBiasGrad := 0.0
 	count := 0
 	for epoch := 0; epoch < model.epochs; epoch++ {
-		for i := 0; i < n; i++ {
-			itemId := startItemId + i
-			if ratings[i] != 0 {
-				pred := 0.0
-				for k := 0; k < model.numFeatures; k++ {
-					pred += (*userFactors)[k] * model.Q[itemId][k]
-				}
-				err := ratings[i] - pred
-				for k := 0; k < model.numFeatures; k++ {
-					userGrad := model.learningRate * (err*model.Q[itemId][k] - model.regularization*(*userFactors)[k])
-					(*userFactors)[k] += userGrad
-					weightedGrad[k] += userGrad
-				}
-				count++
+		for n, itemId := range ratings.Indices {
+			pred := model.PredictUser(*userFactors, *userBias, itemId)
+			err := ratings.Values[n] - pred
+			if model.biased {
+				biasGrad := model.learningRate * (err - model.regularization*(*userBias))
+				*userBias += biasGrad
+				weightedBiasGrad += biasGrad
 			}
+			for k := 0; k < model.numFeatures; k++ {
+				userGrad := model.learningRate * (err*model.Q[itemId][k] - model.regularization*(*userFactors)[k])
+				(*userFactors)[k] += userGrad
+				weightedGrad[k] += userGrad
+			}
+			count++
 		}
 	}
 
 	for k := 0; k < model.numFeatures; k++ {
 		weightedGrad[k] *= float64(count) // w * grad
 	}
-	return weightedGrad, count
+	weightedBiasGrad *= float64(count)
+	return weightedGrad, weightedBiasGrad, count
 }
 
-func (model *Model) PredictUser(userFactors []float64, itemId int) float64 {
+func (model *Model) PredictUser(userFactors []float64, userBias float64, itemId int) float64 {
 	prediction := 0.0
+	if model.biased {
+		prediction = model.GlobalMean + userBias + model.ItemBias[itemId]
+	}
 	for k := 0; k < model.numFeatures; k++ {
 		prediction += userFactors[k] * model.Q[itemId][k]
 	}
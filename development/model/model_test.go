@@ -0,0 +1,46 @@
+package model
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadModelWithoutRatings carga un modelo guardado en binario, que no
+// trae ratings, y comprueba que se pueda usar para servir y que entrenarlo
+// devuelva un error en vez de fallar con R nil.
+func TestLoadModelWithoutRatings(t *testing.T) {
+	trained := newTestModel(t, ModelConfig{NumFeatures: 3, Epochs: 2, LearningRate: 0.01, Regularization: 0.01, Biased: true}, testRatings(10, 8))
+	trained.Train()
+	filename := filepath.Join(t.TempDir(), "model.bin")
+	err := trained.ParamsToBinary(filename, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelConfig, err := LoadModelFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := LoadModel(&modelConfig)
+	if loaded.R != nil {
+		t.Fatalf("binary model has ratings")
+	}
+
+	if report := loaded.Inspect(); report.Q.Rows != 8 {
+		t.Errorf("inspected %d items, want 8", report.Q.Rows)
+	}
+	if _, err := loaded.SimilarItems(0, 3); err != nil {
+		t.Errorf("SimilarItems: %v", err)
+	}
+	users := []UserHistory{{UserId: 1, New: SparseVector{Indices: []int{2, 5}, Values: []float64{4, 1}}}}
+	changed, err := loaded.UpdateFromFeedback(users, IncrementalConfig{})
+	if err != nil || len(changed) != 2 {
+		t.Errorf("UpdateFromFeedback changed %v: %v", changed, err)
+	}
+	if _, err := loaded.TrainWithOptions(TrainOptions{}); err != errNoRatings {
+		t.Errorf("TrainWithOptions: got %v, want %v", err, errNoRatings)
+	}
+	if rmse := loaded.CalculateRMSE(); !math.IsNaN(rmse) {
+		t.Errorf("CalculateRMSE: got %v, want NaN", rmse)
+	}
+}
@@ -0,0 +1,319 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Rating es una entrada observada (formato COO) de la matriz de ratings.
+type Rating struct {
+	UserId    int
+	ItemId    int
+	Value     float64
+	Timestamp int64
+}
+
+// Ratings almacena solo los ratings observados, indexados por usuario (CSR)
+// y por item (CSC), para no reservar memoria para las celdas vacías.
+type Ratings struct {
+	numUsers   int
+	numItems   int
+	userPtr    []int
+	userItems  []int32
+	userValues []float32
+	userTimes  []int64
+	itemPtr    []int
+	itemUsers  []int32
+	itemValues []float32
+}
+
+type ratingsBuilder struct {
+	users  []int32
+	items  []int32
+	values []float32
+	times  []int64
+}
+
+func (builder *ratingsBuilder) add(userId, itemId int, value float64, timestamp int64) {
+	builder.users = append(builder.users, int32(userId))
+	builder.items = append(builder.items, int32(itemId))
+	builder.values = append(builder.values, float32(value))
+	builder.times = append(builder.times, timestamp)
+}
+
+func (builder *ratingsBuilder) build(numUsers, numItems int) *Ratings {
+	ratings := &Ratings{
+		numUsers: numUsers,
+		numItems: numItems,
+		userPtr:  make([]int, numUsers+1),
+	}
+	for _, userId := range builder.users {
+		ratings.userPtr[userId+1]++
+	}
+	for u := 0; u < numUsers; u++ {
+		ratings.userPtr[u+1] += ratings.userPtr[u]
+	}
+
+	n := len(builder.users)
+	hasTimes := false
+	for _, timestamp := range builder.times {
+		if timestamp != 0 {
+			hasTimes = true
+			break
+		}
+	}
+	items := make([]int32, n)
+	values := make([]float32, n)
+	var times []int64
+	if hasTimes {
+		times = make([]int64, n)
+	}
+	next := make([]int, numUsers)
+	copy(next, ratings.userPtr[:numUsers])
+	for e, userId := range builder.users {
+		pos := next[userId]
+		next[userId]++
+		items[pos] = builder.items[e]
+		values[pos] = builder.values[e]
+		if hasTimes {
+			times[pos] = builder.times[e]
+		}
+	}
+
+	// Ordena cada fila por item y conserva el último rating de los duplicados,
+	// igual que hacía la matriz densa al sobrescribir la celda.
+	ratings.userItems = make([]int32, 0, n)
+	ratings.userValues = make([]float32, 0, n)
+	if hasTimes {
+		ratings.userTimes = make([]int64, 0, n)
+	}
+	newPtr := make([]int, numUsers+1)
+	for u := 0; u < numUsers; u++ {
+		start, end := ratings.userPtr[u], ratings.userPtr[u+1]
+		row := make([]int, end-start)
+		for i := range row {
+			row[i] = start + i
+		}
+		sort.SliceStable(row, func(a, b int) bool {
+			return items[row[a]] < items[row[b]]
+		})
+		for i, pos := range row {
+			if i+1 < len(row) && items[row[i+1]] == items[pos] {
+				continue
+			}
+			ratings.userItems = append(ratings.userItems, items[pos])
+			ratings.userValues = append(ratings.userValues, values[pos])
+			if hasTimes {
+				ratings.userTimes = append(ratings.userTimes, times[pos])
+			}
+		}
+		newPtr[u+1] = len(ratings.userItems)
+	}
+	ratings.userPtr = newPtr
+
+	ratings.itemPtr = make([]int, numItems+1)
+	for _, itemId := range ratings.userItems {
+		ratings.itemPtr[itemId+1]++
+	}
+	for i := 0; i < numItems; i++ {
+		ratings.itemPtr[i+1] += ratings.itemPtr[i]
+	}
+	ratings.itemUsers = make([]int32, len(ratings.userItems))
+	ratings.itemValues = make([]float32, len(ratings.userItems))
+	next = make([]int, numItems)
+	copy(next, ratings.itemPtr[:numItems])
+	for u := 0; u < numUsers; u++ {
+		for pos := ratings.userPtr[u]; pos < ratings.userPtr[u+1]; pos++ {
+			itemId := ratings.userItems[pos]
+			ratings.itemUsers[next[itemId]] = int32(u)
+			ratings.itemValues[next[itemId]] = ratings.userValues[pos]
+			next[itemId]++
+		}
+	}
+	return ratings
+}
+
+// NewRatings construye el almacenamiento disperso a partir de entradas COO.
+// Las entradas con valor 0 se consideran desconocidas y se descartan.
+func NewRatings(numUsers, numItems int, entries []Rating) *Ratings {
+	var builder ratingsBuilder
+	for _, entry := range entries {
+		if entry.Value != 0 {
+			builder.add(entry.UserId, entry.ItemId, entry.Value, entry.Timestamp)
+		}
+	}
+	return builder.build(numUsers, numItems)
+}
+
+// NewRatingsFromDense adapta una matriz densa (0 = sin rating) al formato disperso.
+func NewRatingsFromDense(R [][]float64) *Ratings {
+	numItems := 0
+	if len(R) > 0 {
+		numItems = len(R[0])
+	}
+	var builder ratingsBuilder
+	for userId := range R {
+		for itemId, value := range R[userId] {
+			if value != 0 {
+				builder.add(userId, itemId, value, 0)
+			}
+		}
+	}
+	return builder.build(len(R), numItems)
+}
+
+func (ratings *Ratings) NumUsers() int {
+	return ratings.numUsers
+}
+
+func (ratings *Ratings) NumItems() int {
+	return ratings.numItems
+}
+
+// Len devuelve la cantidad de ratings observados.
+func (ratings *Ratings) Len() int {
+	return len(ratings.userItems)
+}
+
+// UserRow devuelve los items y valores calificados por el usuario.
+func (ratings *Ratings) UserRow(userId int) ([]int32, []float32) {
+	start, end := ratings.userPtr[userId], ratings.userPtr[userId+1]
+	return ratings.userItems[start:end], ratings.userValues[start:end]
+}
+
+// ItemColumn devuelve los usuarios y valores que calificaron el item.
+func (ratings *Ratings) ItemColumn(itemId int) ([]int32, []float32) {
+	start, end := ratings.itemPtr[itemId], ratings.itemPtr[itemId+1]
+	return ratings.itemUsers[start:end], ratings.itemValues[start:end]
+}
+
+// Entries devuelve los ratings en formato COO, ordenados por usuario e item.
+func (ratings *Ratings) Entries() []Rating {
+	entries := make([]Rating, 0, ratings.Len())
+	for u := 0; u < ratings.numUsers; u++ {
+		for pos := ratings.userPtr[u]; pos < ratings.userPtr[u+1]; pos++ {
+			entry := Rating{
+				UserId: u,
+				ItemId: int(ratings.userItems[pos]),
+				Value:  float64(ratings.userValues[pos]),
+			}
+			if ratings.userTimes != nil {
+				entry.Timestamp = ratings.userTimes[pos]
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Dense reconstruye la matriz densa; solo es viable para datasets pequeños.
+func (ratings *Ratings) Dense() [][]float64 {
+	R := make([][]float64, ratings.numUsers)
+	for u := range R {
+		R[u] = make([]float64, ratings.numItems)
+		items, values := ratings.UserRow(u)
+		for n, itemId := range items {
+			R[u][itemId] = float64(values[n])
+		}
+	}
+	return R
+}
+
+type ratingsJson struct {
+	NumUsers   int       `json:"numUsers"`
+	NumItems   int       `json:"numItems"`
+	Users      []int32   `json:"users"`
+	Items      []int32   `json:"items"`
+	Values     []float32 `json:"values"`
+	Timestamps []int64   `json:"timestamps,omitempty"`
+}
+
+func (ratings *Ratings) MarshalJSON() ([]byte, error) {
+	data := ratingsJson{
+		NumUsers:   ratings.numUsers,
+		NumItems:   ratings.numItems,
+		Users:      make([]int32, 0, ratings.Len()),
+		Items:      ratings.userItems,
+		Values:     ratings.userValues,
+		Timestamps: ratings.userTimes,
+	}
+	for u := 0; u < ratings.numUsers; u++ {
+		for pos := ratings.userPtr[u]; pos < ratings.userPtr[u+1]; pos++ {
+			data.Users = append(data.Users, int32(u))
+		}
+	}
+	return json.Marshal(data)
+}
+
+func (ratings *Ratings) UnmarshalJSON(bytes []byte) error {
+	var data ratingsJson
+	err := json.Unmarshal(bytes, &data)
+	if err != nil {
+		return err
+	}
+	if len(data.Users) != len(data.Items) || len(data.Users) != len(data.Values) {
+		return fmt.Errorf("ratingsJson: Inconsistent entries length")
+	}
+	var builder ratingsBuilder
+	for e := range data.Users {
+		var timestamp int64
+		if e < len(data.Timestamps) {
+			timestamp = data.Timestamps[e]
+		}
+		if int(data.Users[e]) >= data.NumUsers || int(data.Items[e]) >= data.NumItems || data.Users[e] < 0 || data.Items[e] < 0 {
+			return fmt.Errorf("ratingsJson: Entry %d out of range", e)
+		}
+		builder.add(int(data.Users[e]), int(data.Items[e]), float64(data.Values[e]), timestamp)
+	}
+	*ratings = *builder.build(data.NumUsers, data.NumItems)
+	return nil
+}
+
+// GobEncode y GobDecode usan la misma representación que JSON, para que los
+// mensajes con ratings se puedan enviar con encoding/gob.
+func (ratings *Ratings) GobEncode() ([]byte, error) {
+	return ratings.MarshalJSON()
+}
+
+func (ratings *Ratings) GobDecode(bytes []byte) error {
+	return ratings.UnmarshalJSON(bytes)
+}
+
+// SparseVector representa los ratings de un único usuario; los índices son
+// ids globales de película en orden ascendente.
+type SparseVector struct {
+	Indices []int     `json:"indices"`
+	Values  []float64 `json:"values"`
+}
+
+func NewSparseVector(dense []float64) SparseVector {
+	vector := SparseVector{Indices: []int{}, Values: []float64{}}
+	for i, value := range dense {
+		if value != 0 {
+			vector.Indices = append(vector.Indices, i)
+			vector.Values = append(vector.Values, value)
+		}
+	}
+	return vector
+}
+
+func (vector SparseVector) Len() int {
+	return len(vector.Indices)
+}
+
+// Range devuelve las entradas con índice en [start, end).
+func (vector SparseVector) Range(start, end int) SparseVector {
+	from := sort.SearchInts(vector.Indices, start)
+	to := sort.SearchInts(vector.Indices, end)
+	return SparseVector{
+		Indices: vector.Indices[from:to],
+		Values:  vector.Values[from:to],
+	}
+}
+
+// Contains indica si el item tiene rating en el vector.
+func (vector SparseVector) Contains(itemId int) bool {
+	i := sort.SearchInts(vector.Indices, itemId)
+	return i < len(vector.Indices) && vector.Indices[i] == itemId
+}
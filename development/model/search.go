@@ -0,0 +1,469 @@
+package model
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+)
+
+type ModelGrid struct {
+	NumFeatures    []int
+	Epochs         []int
+	LearningRate   []float64
+	Regularization []float64
+}
+
+const (
+	StrategyGrid    = "grid"
+	StrategyRandom  = "random"
+	StrategyHalving = "halving"
+)
+
+type TrialParams struct {
+	NumFeatures    int     `json:"numFeatures"`
+	Epochs         int     `json:"epochs"`
+	LearningRate   float64 `json:"learningRate"`
+	Regularization float64 `json:"regularization"`
+}
+
+// Key identifica el trial en el archivo de resultados.
+func (params TrialParams) Key() string {
+	return fmt.Sprintf("f=%d,e=%d,lr=%g,reg=%g", params.NumFeatures, params.Epochs, params.LearningRate, params.Regularization)
+}
+
+type Trial struct {
+	Key     string      `json:"key"`
+	Params  TrialParams `json:"params"`
+	Rung    int         `json:"rung"`
+	Metrics Metrics     `json:"metrics"`
+	Seconds float64     `json:"seconds"`
+	Error   string      `json:"error,omitempty"`
+}
+
+type SearchConfig struct {
+	Strategy string
+	// Workers es la cantidad de modelos que se entrenan a la vez.
+	Workers     int
+	RandomState int
+	// NumTrials es la cantidad de combinaciones muestreadas en la búsqueda
+	// aleatoria y el número inicial de candidatos en successive halving
+	// (0 = toda la grilla).
+	NumTrials int
+	// Successive halving: la ronda r entrena con MinEpochs·Eta^r épocas y
+	// conserva el mejor 1/Eta de los candidatos, hasta el máximo de grid.Epochs.
+	MinEpochs int
+	Eta       int
+	// ResultsFile guarda un trial por línea (JSON); al reanudar se omiten los
+	// trials que ya están en el archivo. La primera línea identifica la
+	// configuración y la partición (ver searchFingerprint) y no se reanuda si
+	// cambiaron.
+	ResultsFile string
+	// RetryFailed vuelve a entrenar al reanudar los trials que terminaron con
+	// error en vez de omitirlos.
+	RetryFailed bool
+	Evaluation  EvaluationConfig
+	// Base aporta el resto de la configuración de cada modelo (algoritmo, bias...).
+	Base ModelConfig
+}
+
+type SearchResult struct {
+	Trials    []Trial
+	Best      Trial
+	BestModel Model
+}
+
+const searchPrefix = "search"
+
+// RunSearch evalúa las configuraciones de grid entrenando sobre split.Train y
+// midiendo sobre split.Validation, con a lo sumo config.Workers entrenamientos
+// concurrentes. El mejor modelo es el de menor RMSE de validación.
+func RunSearch(grid ModelGrid, split DataSplit, config SearchConfig) (SearchResult, error) {
+	var result SearchResult
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	candidates := expandGrid(grid)
+	if len(candidates) == 0 {
+		return result, fmt.Errorf("%s: Empty grid", searchPrefix)
+	}
+
+	runner := searchRunner{
+		split:    split,
+		config:   config,
+		bestRMSE: math.Inf(1),
+	}
+	err := runner.openResults()
+	if err != nil {
+		return result, err
+	}
+	defer runner.closeResults()
+
+	r := rand.New(rand.NewSource(int64(config.RandomState)))
+	switch config.Strategy {
+	case StrategyGrid, "":
+		runner.runRung(candidates, 0)
+	case StrategyRandom:
+		runner.runRung(sampleCandidates(candidates, config.NumTrials, r), 0)
+	case StrategyHalving:
+		err = runner.runHalving(sampleCandidates(candidates, config.NumTrials, r), grid)
+		if err != nil {
+			return result, err
+		}
+	default:
+		return result, fmt.Errorf("%s: Unknown strategy %q", searchPrefix, config.Strategy)
+	}
+
+	result.Trials = runner.trials
+	if runner.best == nil {
+		return result, fmt.Errorf("%s: No trial finished successfully", searchPrefix)
+	}
+	result.Best = *runner.best
+	if runner.bestModel == nil {
+		// El mejor trial viene de una ejecución anterior: se vuelve a entrenar.
+		model, _, err := runner.train(result.Best.Params)
+		if err != nil {
+			return result, err
+		}
+		runner.bestModel = &model
+	}
+	result.BestModel = *runner.bestModel
+	log.Printf("INFO: %s: Best trial %s with validation RMSE %v", searchPrefix, result.Best.Key, result.Best.Metrics.RMSE)
+	return result, nil
+}
+
+// SearchGrid entrena cada combinación sobre split.Train y se queda con el
+// modelo de menor RMSE sobre split.Validation.
+func SearchGrid(grid ModelGrid, split DataSplit) Model {
+	result, err := RunSearch(grid, split, SearchConfig{Strategy: StrategyGrid, Workers: 1, RandomState: 1})
+	if err != nil {
+		log.Printf("ERROR: %s: %v", searchPrefix, err)
+	}
+	return result.BestModel
+}
+
+func expandGrid(grid ModelGrid) []TrialParams {
+	var candidates []TrialParams
+	for _, numFeatures := range grid.NumFeatures {
+		for _, epochs := range grid.Epochs {
+			for _, learningRate := range grid.LearningRate {
+				for _, regularization := range grid.Regularization {
+					candidates = append(candidates, TrialParams{
+						NumFeatures:    numFeatures,
+						Epochs:         epochs,
+						LearningRate:   learningRate,
+						Regularization: regularization,
+					})
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+func sampleCandidates(candidates []TrialParams, n int, r *rand.Rand) []TrialParams {
+	if n <= 0 || n >= len(candidates) {
+		return candidates
+	}
+	sampled := make([]TrialParams, len(candidates))
+	copy(sampled, candidates)
+	r.Shuffle(len(sampled), func(i, j int) {
+		sampled[i], sampled[j] = sampled[j], sampled[i]
+	})
+	return sampled[:n]
+}
+
+type searchRunner struct {
+	split     DataSplit
+	config    SearchConfig
+	finished  map[string]Trial
+	results   *os.File
+	mu        sync.Mutex
+	trials    []Trial
+	best      *Trial
+	bestRMSE  float64
+	bestModel *Model
+}
+
+func (runner *searchRunner) openResults() error {
+	runner.finished = make(map[string]Trial)
+	if runner.config.ResultsFile == "" {
+		return nil
+	}
+	fingerprint := runner.fingerprint()
+	file, err := os.OpenFile(runner.config.ResultsFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("%s: Error opening results file: %v", searchPrefix, err)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("%s: Error reading results file: %v", searchPrefix, err)
+	}
+	// Una línea incompleta (corte a mitad de escritura) se descarta, así el
+	// próximo trial no queda pegado a ella.
+	complete := bytes.LastIndexByte(data, '\n') + 1
+	if complete < len(data) {
+		err = file.Truncate(int64(complete))
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("%s: Error truncating results file: %v", searchPrefix, err)
+		}
+	}
+	_, err = file.Seek(int64(complete), io.SeekStart)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("%s: Error seeking results file: %v", searchPrefix, err)
+	}
+	lines := bytes.Split(data[:complete], []byte{'\n'})
+	var header resultsHeader
+	if complete == 0 {
+		line, _ := json.Marshal(resultsHeader{Fingerprint: fingerprint})
+		_, err = file.Write(append(line, '\n'))
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("%s: Error writing results file: %v", searchPrefix, err)
+		}
+	} else if json.Unmarshal(lines[0], &header) != nil || header.Fingerprint != fingerprint {
+		file.Close()
+		return fmt.Errorf("%s: Results file %s was written with another model configuration, seed or split (fingerprint %q, current %q), use a new file", searchPrefix, runner.config.ResultsFile, header.Fingerprint, fingerprint)
+	}
+	for _, line := range lines {
+		var trial Trial
+		if json.Unmarshal(line, &trial) != nil || trial.Key == "" {
+			continue
+		}
+		if trial.Error != "" && runner.config.RetryFailed {
+			delete(runner.finished, trial.Key)
+			continue
+		}
+		runner.finished[trial.Key] = trial
+	}
+	if len(runner.finished) > 0 {
+		log.Printf("INFO: %s: Resuming, %d trials already finished", searchPrefix, len(runner.finished))
+	}
+	runner.results = file
+	return nil
+}
+
+// resultsHeader es la primera línea del archivo de resultados.
+type resultsHeader struct {
+	Fingerprint string `json:"fingerprint"`
+}
+
+// fingerprint resume todo lo que, además de TrialParams, cambia el resultado
+// de un trial: la configuración base del modelo, la semilla, la evaluación y
+// los ratings de la partición.
+func (runner *searchRunner) fingerprint() string {
+	base := runner.config.Base
+	settings, _ := json.Marshal(struct {
+		Algorithm     string
+		Biased        bool
+		Alpha         float64
+		NumWorkers    int
+		Deterministic bool
+		RandomState   int
+		Evaluation    EvaluationConfig
+	}{base.Algorithm, base.Biased, base.Alpha, runner.numWorkers(), base.Deterministic, runner.config.RandomState, runner.config.Evaluation})
+	hash := sha256.New()
+	hash.Write(settings)
+	var buffer []byte
+	for _, ratings := range []*Ratings{runner.split.Train, runner.split.Validation} {
+		buffer = binary.AppendVarint(buffer[:0], -1)
+		hash.Write(buffer)
+		if ratings == nil {
+			continue
+		}
+		for _, rating := range ratings.Entries() {
+			buffer = binary.AppendVarint(buffer[:0], int64(rating.UserId))
+			buffer = binary.AppendVarint(buffer, int64(rating.ItemId))
+			buffer = binary.AppendUvarint(buffer, math.Float64bits(rating.Value))
+			buffer = binary.AppendVarint(buffer, rating.Timestamp)
+			hash.Write(buffer)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// numWorkers devuelve los workers de cada entrenamiento: si Base no los fija
+// se reparte la CPU entre los entrenamientos concurrentes.
+func (runner *searchRunner) numWorkers() int {
+	if runner.config.Base.NumWorkers > 0 {
+		return runner.config.Base.NumWorkers
+	}
+	return max(runtime.NumCPU()/runner.config.Workers, 1)
+}
+
+func (runner *searchRunner) closeResults() {
+	if runner.results != nil {
+		runner.results.Close()
+	}
+}
+
+// runRung evalúa los candidatos con un pool de workers y devuelve los trials en
+// el mismo orden que los candidatos.
+func (runner *searchRunner) runRung(candidates []TrialParams, rung int) []Trial {
+	trials := make([]Trial, len(candidates))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runner.config.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				trials[i] = runner.runTrial(candidates[i], rung)
+			}
+		}()
+	}
+	for i := range candidates {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return trials
+}
+
+func (runner *searchRunner) runTrial(params TrialParams, rung int) Trial {
+	key := params.Key()
+	runner.mu.Lock()
+	done, ok := runner.finished[key]
+	runner.mu.Unlock()
+	if ok {
+		runner.record(done, nil, false)
+		return done
+	}
+
+	log.Printf("INFO: %s: Training %s", searchPrefix, key)
+	start := time.Now()
+	trial := Trial{Key: key, Params: params, Rung: rung}
+	model, metrics, err := runner.train(params)
+	trial.Seconds = time.Since(start).Seconds()
+	if err != nil {
+		trial.Error = err.Error()
+		log.Printf("ERROR: %s: Trial %s: %v", searchPrefix, key, err)
+	} else {
+		trial.Metrics = metrics
+		log.Printf("INFO: %s: Trial %s validation RMSE %v (%.1fs)", searchPrefix, key, metrics.RMSE, trial.Seconds)
+	}
+	runner.record(trial, &model, true)
+	return trial
+}
+
+func (runner *searchRunner) train(params TrialParams) (Model, Metrics, error) {
+	modelConfig := runner.config.Base
+	modelConfig.NumFeatures = params.NumFeatures
+	modelConfig.Epochs = params.Epochs
+	modelConfig.LearningRate = params.LearningRate
+	modelConfig.Regularization = params.Regularization
+	modelConfig.NumWorkers = runner.numWorkers()
+	model, err := NewModelFromConfig(&modelConfig, runner.split.Train, runner.config.RandomState)
+	if err != nil {
+		return Model{}, Metrics{}, err
+	}
+	model.Train()
+	metrics := model.Evaluate(runner.split.Validation, runner.split.Train, runner.config.Evaluation)
+	if math.IsNaN(metrics.RMSE) || math.IsInf(metrics.RMSE, 0) {
+		return model, metrics, fmt.Errorf("validation RMSE is %v", metrics.RMSE)
+	}
+	return model, metrics, nil
+}
+
+func (runner *searchRunner) record(trial Trial, model *Model, write bool) {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	runner.trials = append(runner.trials, trial)
+	if write && runner.results != nil {
+		line, err := json.Marshal(trial)
+		if err == nil {
+			_, err = runner.results.Write(append(line, '\n'))
+		}
+		if err != nil {
+			log.Printf("ERROR: %s: Error writing trial %s: %v", searchPrefix, trial.Key, err)
+		}
+	}
+	if trial.Error == "" && trial.Metrics.RMSE < runner.bestRMSE {
+		runner.bestRMSE = trial.Metrics.RMSE
+		best := trial
+		runner.best = &best
+		runner.bestModel = model
+	}
+}
+
+func (runner *searchRunner) runHalving(candidates []TrialParams, grid ModelGrid) error {
+	eta := runner.config.Eta
+	if eta < 2 {
+		eta = 3
+	}
+	maxEpochs := 0
+	for _, epochs := range grid.Epochs {
+		if epochs > maxEpochs {
+			maxEpochs = epochs
+		}
+	}
+	epochs := runner.config.MinEpochs
+	if epochs <= 0 || maxEpochs <= 0 {
+		return fmt.Errorf("%s: Successive halving requires minEpochs > 0 and epochs in the grid", searchPrefix)
+	}
+
+	// Las épocas de la grilla se reemplazan por el presupuesto de cada ronda.
+	seen := make(map[string]bool)
+	var unique []TrialParams
+	for _, params := range candidates {
+		params.Epochs = 0
+		if !seen[params.Key()] {
+			seen[params.Key()] = true
+			unique = append(unique, params)
+		}
+	}
+	candidates = unique
+
+	for rung := 0; ; rung++ {
+		if epochs > maxEpochs {
+			epochs = maxEpochs
+		}
+		for i := range candidates {
+			candidates[i].Epochs = epochs
+		}
+		log.Printf("INFO: %s: Rung %d: %d candidates with %d epochs", searchPrefix, rung, len(candidates), epochs)
+		trials := runner.runRung(candidates, rung)
+		if len(candidates) == 1 || epochs == maxEpochs {
+			return nil
+		}
+
+		order := make([]int, len(trials))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			return trialScore(trials[order[a]]) < trialScore(trials[order[b]])
+		})
+		keep := len(candidates) / eta
+		if keep < 1 {
+			keep = 1
+		}
+		next := make([]TrialParams, keep)
+		for i := 0; i < keep; i++ {
+			next[i] = candidates[order[i]]
+		}
+		candidates = next
+		epochs *= eta
+	}
+}
+
+func trialScore(trial Trial) float64 {
+	if trial.Error != "" {
+		return math.Inf(1)
+	}
+	return trial.Metrics.RMSE
+}
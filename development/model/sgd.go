@@ -0,0 +1,146 @@
+package model
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// deterministicBlocks es la cantidad de estratos en modo determinista; no
+// depende de la cantidad de workers ni de CPUs, así que dos entrenamientos con
+// el mismo randomState dan el mismo modelo bit a bit en cualquier máquina.
+const deterministicBlocks = 8
+
+type sgdRating struct {
+	user  int32
+	item  int32
+	value float32
+}
+
+// sgdBlocks particiona los ratings en una grilla B×B (DSGD): los usuarios y los
+// ítems se reparten en B grupos con una permutación aleatoria y el bloque (a, b)
+// tiene los ratings de usuarios del grupo a con ítems del grupo b. Los bloques
+// de un mismo estrato {(a, (a+s) mod B)} no comparten filas de P ni de Q, así
+// que se pueden entrenar en paralelo sin locks.
+type sgdBlocks struct {
+	numBlocks int
+	ratings   [][]sgdRating
+	order     [][]int
+}
+
+// numSGDBlocks devuelve B: en modo determinista numWorkers (si se fijó) o
+// deterministicBlocks; si no, un bloque por worker.
+func (model *Model) numSGDBlocks() int {
+	if model.deterministic {
+		if model.numWorkers > 0 {
+			return model.numWorkers
+		}
+		return deterministicBlocks
+	}
+	return model.workers()
+}
+
+func (model *Model) buildSGDBlocks() *sgdBlocks {
+	numBlocks := model.numSGDBlocks()
+	r := rand.New(rand.NewSource(mixSeed(int64(model.randomState), -1, -1)))
+	userBlock := make([]int, model.R.NumUsers())
+	for pos, u := range r.Perm(len(userBlock)) {
+		userBlock[u] = pos % numBlocks
+	}
+	itemBlock := make([]int, model.R.NumItems())
+	for pos, i := range r.Perm(len(itemBlock)) {
+		itemBlock[i] = pos % numBlocks
+	}
+
+	blocks := &sgdBlocks{
+		numBlocks: numBlocks,
+		ratings:   make([][]sgdRating, numBlocks*numBlocks),
+		order:     make([][]int, numBlocks*numBlocks),
+	}
+	for u := 0; u < model.R.NumUsers(); u++ {
+		items, values := model.R.UserRow(u)
+		for n, item := range items {
+			block := userBlock[u]*numBlocks + itemBlock[item]
+			blocks.ratings[block] = append(blocks.ratings[block], sgdRating{user: int32(u), item: item, value: values[n]})
+		}
+	}
+	for block := range blocks.order {
+		blocks.order[block] = make([]int, len(blocks.ratings[block]))
+	}
+	return blocks
+}
+
+// sgdEpoch recorre todos los ratings una vez en B sub-épocas. El orden de los
+// estratos y el de los ratings dentro de cada bloque se sortean con semillas
+// derivadas de (randomState, epoch, bloque), así que el resultado no depende de
+// cómo el scheduler reparte los bloques entre los workers y una época reanudada
+// desde un checkpoint es idéntica a la original.
+func (model *Model) sgdEpoch(epoch int, learningRate float64) {
+	if model.blocks == nil || model.blocks.numBlocks != model.numSGDBlocks() {
+		model.blocks = model.buildSGDBlocks()
+	}
+	numBlocks := model.blocks.numBlocks
+	numWorkers := model.workers()
+	if numWorkers > numBlocks {
+		numWorkers = numBlocks
+	}
+
+	r := rand.New(rand.NewSource(mixSeed(int64(model.randomState), int64(epoch), -1)))
+	for _, stratum := range r.Perm(numBlocks) {
+		jobs := make(chan int, numBlocks)
+		var wg sync.WaitGroup
+		for w := 0; w < numWorkers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for block := range jobs {
+					model.sgdBlock(block, epoch, learningRate)
+				}
+			}()
+		}
+		for userGroup := 0; userGroup < numBlocks; userGroup++ {
+			jobs <- userGroup*numBlocks + (userGroup+stratum)%numBlocks
+		}
+		close(jobs)
+		wg.Wait()
+	}
+}
+
+func (model *Model) sgdBlock(block, epoch int, learningRate float64) {
+	ratings := model.blocks.ratings[block]
+	order := model.blocks.order[block]
+	for n := range order {
+		order[n] = n
+	}
+	r := rand.New(rand.NewSource(mixSeed(int64(model.randomState), int64(epoch), int64(block))))
+	r.Shuffle(len(order), func(a, b int) {
+		order[a], order[b] = order[b], order[a]
+	})
+
+	for _, n := range order {
+		userId, itemId := int(ratings[n].user), int(ratings[n].item)
+		err := float64(ratings[n].value) - model.Predict(userId, itemId)
+		if model.biased {
+			model.UserBias[userId] += learningRate * (err - model.regularization*model.UserBias[userId])
+			model.ItemBias[itemId] += learningRate * (err - model.regularization*model.ItemBias[itemId])
+		}
+		userFactors, itemFactors := model.P[userId], model.Q[itemId]
+		for k := 0; k < model.numFeatures; k++ {
+			userGrad := learningRate * (err*itemFactors[k] - model.regularization*userFactors[k])
+			itemGrad := learningRate * (err*userFactors[k] - model.regularization*itemFactors[k])
+			userFactors[k] += userGrad
+			itemFactors[k] += itemGrad
+		}
+	}
+}
+
+// mixSeed combina la semilla del modelo con la época y el bloque (splitmix64).
+func mixSeed(seed, epoch, block int64) int64 {
+	x := uint64(seed)
+	for _, v := range []int64{epoch, block} {
+		x += 0x9e3779b97f4a7c15 + uint64(v)
+		x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+		x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+		x ^= x >> 31
+	}
+	return int64(x)
+}
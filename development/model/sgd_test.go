@@ -0,0 +1,70 @@
+package model
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// testRatings genera ratings sintéticos de numUsers x numItems con la mitad
+// de las celdas calificadas.
+func testRatings(numUsers, numItems int) *Ratings {
+	random := rand.New(rand.NewSource(7))
+	var ratings []Rating
+	for u := 0; u < numUsers; u++ {
+		for i := 0; i < numItems; i++ {
+			if random.Intn(2) == 0 {
+				ratings = append(ratings, Rating{UserId: u, ItemId: i, Value: float64(1 + random.Intn(5))})
+			}
+		}
+	}
+	return NewRatings(numUsers, numItems, ratings)
+}
+
+func newTestModel(t *testing.T, config ModelConfig, ratings *Ratings) Model {
+	t.Helper()
+	model, err := NewModelFromConfig(&config, ratings, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model
+}
+
+// assertSameBits compara dos matrices bit a bit.
+func assertSameBits(t *testing.T, name string, got, want [][]float64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s has %d rows, want %d", name, len(got), len(want))
+	}
+	for i := range want {
+		if len(got[i]) != len(want[i]) {
+			t.Fatalf("%s row %d has %d columns, want %d", name, i, len(got[i]), len(want[i]))
+		}
+		for k := range want[i] {
+			if math.Float64bits(got[i][k]) != math.Float64bits(want[i][k]) {
+				t.Fatalf("%s[%d][%d] = %v, want %v", name, i, k, got[i][k], want[i][k])
+			}
+		}
+	}
+}
+
+// TestDeterministicSGD entrena dos veces con la misma semilla en modo
+// determinista, con los bloques repartidos entre todas las CPUs, y espera el
+// mismo modelo bit a bit.
+func TestDeterministicSGD(t *testing.T) {
+	ratings := testRatings(40, 30)
+	config := ModelConfig{NumFeatures: 4, Epochs: 5, LearningRate: 0.01, Regularization: 0.01, Biased: true, Deterministic: true}
+	first := newTestModel(t, config, ratings)
+	first.Train()
+	second := newTestModel(t, config, ratings)
+	second.Train()
+	assertSameBits(t, "P", second.P, first.P)
+	assertSameBits(t, "Q", second.Q, first.Q)
+	assertSameBits(t, "UserBias", [][]float64{second.UserBias}, [][]float64{first.UserBias})
+	assertSameBits(t, "ItemBias", [][]float64{second.ItemBias}, [][]float64{first.ItemBias})
+
+	initial := newTestModel(t, config, ratings)
+	if math.Float64bits(initial.Q[0][0]) == math.Float64bits(first.Q[0][0]) {
+		t.Errorf("training did not change Q")
+	}
+}
@@ -0,0 +1,317 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"time"
+)
+
+// errNoRatings es el error de entrenar un modelo cargado sin ratings (los
+// archivos binarios y los JSON del master no los guardan).
+var errNoRatings = errors.New("trainError: Model has no ratings, it was loaded from a file without them")
+
+// ErrStopTraining lo devuelve un EpochCallback para terminar el entrenamiento
+// sin que se considere un error.
+var ErrStopTraining = errors.New("stop training")
+
+type EpochCallback func(model *Model, stats EpochStats) error
+
+type TrainOptions struct {
+	// Validation, si no es nil, se evalúa al final de cada época.
+	Validation *Ratings
+	// Patience es la cantidad de épocas sin mejorar la validación antes de
+	// detener el entrenamiento (0 = sin early stopping).
+	Patience int
+	// RestoreBest deja en el modelo los factores de la mejor época de validación.
+	RestoreBest bool
+	Schedule    LearningRateSchedule
+	OnEpoch     []EpochCallback
+	// CheckpointDir activa los checkpoints periódicos cada CheckpointEvery
+	// épocas (por defecto en todas); con Resume se reanuda desde el último.
+	CheckpointDir   string
+	CheckpointEvery int
+	Resume          bool
+}
+
+func (options *TrainOptions) checkpointEvery() int {
+	if options.CheckpointEvery > 0 {
+		return options.CheckpointEvery
+	}
+	return 1
+}
+
+const trainPrefix = "train"
+
+type EpochStats struct {
+	Epoch          int     `json:"epoch"`
+	LearningRate   float64 `json:"learningRate"`
+	TrainRMSE      float64 `json:"trainRmse"`
+	ValidationRMSE float64 `json:"validationRmse,omitempty"`
+	ValidationMAE  float64 `json:"validationMae,omitempty"`
+	Seconds        float64 `json:"seconds"`
+}
+
+type TrainingReport struct {
+	Algorithm          string       `json:"algorithm"`
+	NumFeatures        int          `json:"numFeatures"`
+	Epochs             []EpochStats `json:"epochs"`
+	BestEpoch          int          `json:"bestEpoch"`
+	BestValidationRMSE float64      `json:"bestValidationRmse,omitempty"`
+	StoppedEarly       bool         `json:"stoppedEarly"`
+	WallSeconds        float64      `json:"wallSeconds"`
+}
+
+func (report *TrainingReport) ToJson(filename string) error {
+	jsonData, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return fmt.Errorf("error marshalling report to JSON: %v", err)
+	}
+	err = writeFileAtomic(filename, func(writer io.Writer) error {
+		_, err := writer.Write(jsonData)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error writing JSON to file: %v", err)
+	}
+	return nil
+}
+
+// trainingState es todo lo que cambia entre épocas además de los parámetros
+// del modelo; se guarda en los checkpoints para poder reanudar.
+type trainingState struct {
+	NextEpoch                int
+	LearningRate             float64
+	PreviousLoss             float64
+	BestRMSE                 float64
+	EpochsWithoutImprovement int
+	Best                     *modelSnapshot
+	Finished                 bool
+	Report                   TrainingReport
+}
+
+// TrainWithOptions ejecuta hasta model.epochs épocas del algoritmo configurado,
+// aplicando el schedule de learning rate, el early stopping y los callbacks.
+func (model *Model) TrainWithOptions(options TrainOptions) (TrainingReport, error) {
+	if model.R == nil {
+		return TrainingReport{}, errNoRatings
+	}
+	state := trainingState{
+		LearningRate: model.learningRate,
+		PreviousLoss: math.Inf(1),
+		BestRMSE:     math.Inf(1),
+		Report: TrainingReport{
+			Algorithm:   model.algorithm,
+			NumFeatures: model.numFeatures,
+			Epochs:      make([]EpochStats, 0, model.epochs),
+			BestEpoch:   -1,
+		},
+	}
+	if options.Resume && options.CheckpointDir != "" {
+		resumed, err := model.resumeCheckpoint(options.CheckpointDir, &state)
+		if err != nil {
+			return state.Report, err
+		}
+		if resumed {
+			log.Printf("INFO: %s: Resuming from epoch %d", trainPrefix, state.NextEpoch)
+		}
+	}
+	previousSeconds := state.Report.WallSeconds
+	start := time.Now()
+	report := &state.Report
+
+	for !state.Finished && state.NextEpoch < model.epochs {
+		epoch := state.NextEpoch
+		epochStart := time.Now()
+		switch model.algorithm {
+		case AlgorithmALS:
+			model.alsEpoch()
+		case AlgorithmImplicitALS:
+			model.implicitEpoch()
+		default:
+			model.sgdEpoch(epoch, state.LearningRate)
+		}
+
+		stats := EpochStats{Epoch: epoch, LearningRate: state.LearningRate}
+		stats.TrainRMSE, _ = model.ErrorMetrics(model.R)
+		if options.Validation != nil {
+			stats.ValidationRMSE, stats.ValidationMAE = model.ErrorMetrics(options.Validation)
+		}
+		stats.Seconds = time.Since(epochStart).Seconds()
+		report.Epochs = append(report.Epochs, stats)
+
+		stop := false
+		if options.Validation != nil {
+			if stats.ValidationRMSE < state.BestRMSE {
+				state.BestRMSE = stats.ValidationRMSE
+				report.BestEpoch = epoch
+				report.BestValidationRMSE = state.BestRMSE
+				state.EpochsWithoutImprovement = 0
+				if options.RestoreBest {
+					state.Best = model.snapshot()
+				}
+			} else {
+				state.EpochsWithoutImprovement++
+				if options.Patience > 0 && state.EpochsWithoutImprovement >= options.Patience {
+					report.StoppedEarly = true
+					stop = true
+				}
+			}
+		} else {
+			report.BestEpoch = epoch
+		}
+
+		for _, callback := range options.OnEpoch {
+			err := callback(model, stats)
+			if errors.Is(err, ErrStopTraining) {
+				report.StoppedEarly = true
+				stop = true
+			} else if err != nil {
+				report.WallSeconds = previousSeconds + time.Since(start).Seconds()
+				return *report, fmt.Errorf("trainError: Epoch %d callback error: %v", epoch, err)
+			}
+		}
+
+		if options.Schedule != nil {
+			state.LearningRate = options.Schedule.NextLearningRate(epoch, state.LearningRate, stats.TrainRMSE, state.PreviousLoss)
+		}
+		state.PreviousLoss = stats.TrainRMSE
+		state.NextEpoch = epoch + 1
+		state.Finished = stop || state.NextEpoch >= model.epochs
+		report.WallSeconds = previousSeconds + time.Since(start).Seconds()
+
+		if options.CheckpointDir != "" && (state.Finished || state.NextEpoch%options.checkpointEvery() == 0) {
+			err := model.writeCheckpoint(options.CheckpointDir, &state)
+			if err != nil {
+				return *report, err
+			}
+		}
+	}
+
+	if state.Best != nil {
+		model.restore(state.Best)
+	}
+	report.WallSeconds = previousSeconds + time.Since(start).Seconds()
+	return *report, nil
+}
+
+type modelSnapshot struct {
+	P        [][]float64
+	Q        [][]float64
+	UserBias []float64
+	ItemBias []float64
+}
+
+func (model *Model) snapshot() *modelSnapshot {
+	return &modelSnapshot{
+		P:        copyMatrix(model.P),
+		Q:        copyMatrix(model.Q),
+		UserBias: append([]float64(nil), model.UserBias...),
+		ItemBias: append([]float64(nil), model.ItemBias...),
+	}
+}
+
+func (model *Model) restore(snapshot *modelSnapshot) {
+	model.P = snapshot.P
+	model.Q = snapshot.Q
+	if model.biased {
+		model.UserBias = snapshot.UserBias
+		model.ItemBias = snapshot.ItemBias
+	}
+}
+
+func copyMatrix(matrix [][]float64) [][]float64 {
+	copied := make([][]float64, len(matrix))
+	for i := range matrix {
+		copied[i] = append([]float64(nil), matrix[i]...)
+	}
+	return copied
+}
+
+// LearningRateSchedule decide el learning rate de la siguiente época a partir
+// del actual y de la pérdida de entrenamiento de la época y de la anterior.
+type LearningRateSchedule interface {
+	NextLearningRate(epoch int, learningRate, loss, previousLoss float64) float64
+}
+
+// StepDecay multiplica el learning rate por Factor cada StepSize épocas.
+type StepDecay struct {
+	StepSize int
+	Factor   float64
+}
+
+func (schedule StepDecay) NextLearningRate(epoch int, learningRate, loss, previousLoss float64) float64 {
+	if schedule.StepSize > 0 && (epoch+1)%schedule.StepSize == 0 {
+		return learningRate * schedule.Factor
+	}
+	return learningRate
+}
+
+// ExponentialDecay aplica lr ← lr·e^(-Rate) en cada época.
+type ExponentialDecay struct {
+	Rate float64
+}
+
+func (schedule ExponentialDecay) NextLearningRate(epoch int, learningRate, loss, previousLoss float64) float64 {
+	return learningRate * math.Exp(-schedule.Rate)
+}
+
+// BoldDriver aumenta el learning rate mientras la pérdida baja y lo reduce
+// cuando sube.
+type BoldDriver struct {
+	Increase float64
+	Decrease float64
+}
+
+func (schedule BoldDriver) NextLearningRate(epoch int, learningRate, loss, previousLoss float64) float64 {
+	if loss < previousLoss {
+		return learningRate * schedule.Increase
+	}
+	return learningRate * schedule.Decrease
+}
+
+const (
+	ScheduleConstant    = "constant"
+	ScheduleStep        = "step"
+	ScheduleExponential = "exponential"
+	ScheduleBoldDriver  = "bold-driver"
+)
+
+type ScheduleConfig struct {
+	Type     string  `json:"type"`
+	StepSize int     `json:"stepSize,omitempty"`
+	Factor   float64 `json:"factor,omitempty"`
+	Rate     float64 `json:"rate,omitempty"`
+	Increase float64 `json:"increase,omitempty"`
+	Decrease float64 `json:"decrease,omitempty"`
+}
+
+// NewSchedule construye el schedule descrito en config; para "constant" o tipo
+// vacío devuelve nil.
+func NewSchedule(config ScheduleConfig) (LearningRateSchedule, error) {
+	switch config.Type {
+	case "", ScheduleConstant:
+		return nil, nil
+	case ScheduleStep:
+		if config.StepSize <= 0 || config.Factor <= 0 {
+			return nil, fmt.Errorf("scheduleError: Step schedule requires stepSize > 0 and factor > 0")
+		}
+		return StepDecay{StepSize: config.StepSize, Factor: config.Factor}, nil
+	case ScheduleExponential:
+		return ExponentialDecay{Rate: config.Rate}, nil
+	case ScheduleBoldDriver:
+		boldDriver := BoldDriver{Increase: config.Increase, Decrease: config.Decrease}
+		if boldDriver.Increase == 0 {
+			boldDriver.Increase = 1.05
+		}
+		if boldDriver.Decrease == 0 {
+			boldDriver.Decrease = 0.5
+		}
+		return boldDriver, nil
+	default:
+		return nil, fmt.Errorf("scheduleError: Unknown schedule %q", config.Type)
+	}
+}
@@ -0,0 +1,444 @@
+package movielens
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"recommendation-service/model"
+)
+
+const (
+	// Layout100K es ml-100k: u.data (tabuladores), u.item y u.genre (con '|').
+	Layout100K = "ml-100k"
+	// LayoutLatest es ml-latest y ml-latest-small: ratings.csv y movies.csv.
+	LayoutLatest = "ml-latest"
+)
+
+// noGenres es el género que MovieLens usa para las películas sin géneros.
+const noGenres = "(no genres listed)"
+
+type Movie struct {
+	Id     string
+	Title  string
+	Genres []string
+}
+
+type rawRating struct {
+	userId    string
+	movieId   string
+	value     float64
+	timestamp int64
+}
+
+// CleanConfig reproduce los filtros del notebook de limpieza: se descartan las
+// películas con MinMovieRatings ratings o menos y luego se conservan los
+// MaxUsers usuarios y las MaxMovies películas con más ratings (0 = sin límite).
+type CleanConfig struct {
+	MinMovieRatings int
+	MinUserRatings  int
+	MaxUsers        int
+	MaxMovies       int
+}
+
+// Result es el dataset limpio y reindexado: el usuario i es UserIds[i] y la
+// película j es MovieIds[j], con título MovieTitles[j]. Los ids de Ratings son
+// los índices i y j, no los de MovieLens.
+type Result struct {
+	Ratings         []model.Rating
+	UserIds         []string
+	MovieIds        []string
+	MovieTitles     []string
+	MovieGenreNames []string
+	MovieGenreIds   [][]int
+}
+
+// DetectLayout reconoce el formato por los archivos presentes en dir.
+func DetectLayout(dir string) (string, error) {
+	if fileExists(filepath.Join(dir, "u.data")) && fileExists(filepath.Join(dir, "u.item")) {
+		return Layout100K, nil
+	}
+	if fileExists(filepath.Join(dir, "ratings.csv")) && fileExists(filepath.Join(dir, "movies.csv")) {
+		return LayoutLatest, nil
+	}
+	return "", fmt.Errorf("movielensError: %s is not a MovieLens directory (expected u.data and u.item, or ratings.csv and movies.csv)", dir)
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// Import lee el dataset de dir, lo limpia y lo reindexa.
+func Import(dir, layout string, config CleanConfig) (*Result, error) {
+	var ratings []rawRating
+	var movies []Movie
+	var genreNames []string
+	var err error
+	switch layout {
+	case Layout100K:
+		ratings, err = read100KRatings(filepath.Join(dir, "u.data"))
+		if err != nil {
+			return nil, err
+		}
+		genreNames, err = read100KGenres(filepath.Join(dir, "u.genre"))
+		if err != nil {
+			return nil, err
+		}
+		movies, err = read100KMovies(filepath.Join(dir, "u.item"), genreNames)
+	case LayoutLatest:
+		ratings, err = readLatestRatings(filepath.Join(dir, "ratings.csv"))
+		if err != nil {
+			return nil, err
+		}
+		movies, err = readLatestMovies(filepath.Join(dir, "movies.csv"))
+	default:
+		return nil, fmt.Errorf("movielensError: Unknown layout %q", layout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return clean(ratings, movies, genreNames, config)
+}
+
+func read100KRatings(filename string) ([]rawRating, error) {
+	var ratings []rawRating
+	err := readLines(filename, func(line int, text string) error {
+		fields := strings.Fields(text)
+		if len(fields) < 3 {
+			return fmt.Errorf("expected 4 fields, got %d", len(fields))
+		}
+		rating, err := parseRating(fields)
+		if err != nil {
+			return err
+		}
+		ratings = append(ratings, rating)
+		return nil
+	})
+	return ratings, err
+}
+
+// read100KGenres lee u.genre (nombre|índice); sin el archivo se usan los
+// géneros de la distribución original.
+func read100KGenres(filename string) ([]string, error) {
+	if !fileExists(filename) {
+		return []string{"unknown", "Action", "Adventure", "Animation", "Children's", "Comedy", "Crime", "Documentary", "Drama", "Fantasy", "Film-Noir", "Horror", "Musical", "Mystery", "Romance", "Sci-Fi", "Thriller", "War", "Western"}, nil
+	}
+	var genres []string
+	err := readLines(filename, func(line int, text string) error {
+		fields := strings.Split(text, "|")
+		if len(fields) != 2 {
+			return fmt.Errorf("expected name|index, got %q", text)
+		}
+		index, err := strconv.Atoi(fields[1])
+		if err != nil || index != len(genres) {
+			return fmt.Errorf("unexpected genre index %q", fields[1])
+		}
+		genres = append(genres, fields[0])
+		return nil
+	})
+	return genres, err
+}
+
+// read100KMovies lee u.item: id|título|estreno|video|url|una marca 0/1 por género.
+func read100KMovies(filename string, genreNames []string) ([]Movie, error) {
+	var movies []Movie
+	err := readLines(filename, func(line int, text string) error {
+		fields := strings.Split(latin1ToUTF8(text), "|")
+		if len(fields) < 5+len(genreNames) {
+			return fmt.Errorf("expected %d fields, got %d", 5+len(genreNames), len(fields))
+		}
+		movie := Movie{Id: fields[0], Title: fields[1], Genres: []string{}}
+		for g, flag := range fields[5 : 5+len(genreNames)] {
+			if flag == "1" {
+				movie.Genres = append(movie.Genres, genreNames[g])
+			}
+		}
+		movies = append(movies, movie)
+		return nil
+	})
+	return movies, err
+}
+
+// latin1ToUTF8 convierte u.item, que está en ISO-8859-1.
+func latin1ToUTF8(text string) string {
+	runes := make([]rune, len(text))
+	for i := 0; i < len(text); i++ {
+		runes[i] = rune(text[i])
+	}
+	return string(runes)
+}
+
+func readLatestRatings(filename string) ([]rawRating, error) {
+	var ratings []rawRating
+	err := readCsv(filename, func(line int, record []string) error {
+		if len(record) < 3 {
+			return fmt.Errorf("expected 4 fields, got %d", len(record))
+		}
+		rating, err := parseRating(record)
+		if err != nil {
+			return err
+		}
+		ratings = append(ratings, rating)
+		return nil
+	})
+	return ratings, err
+}
+
+// readLatestMovies lee movies.csv: movieId,title,genres (separados con '|').
+func readLatestMovies(filename string) ([]Movie, error) {
+	var movies []Movie
+	err := readCsv(filename, func(line int, record []string) error {
+		if len(record) < 3 {
+			return fmt.Errorf("expected 3 fields, got %d", len(record))
+		}
+		movie := Movie{Id: record[0], Title: strings.TrimSpace(record[1]), Genres: []string{}}
+		if record[2] != noGenres && record[2] != "" {
+			movie.Genres = strings.Split(record[2], "|")
+		}
+		movies = append(movies, movie)
+		return nil
+	})
+	return movies, err
+}
+
+func parseRating(fields []string) (rawRating, error) {
+	rating := rawRating{userId: fields[0], movieId: fields[1]}
+	if _, err := strconv.Atoi(fields[0]); err != nil {
+		return rating, fmt.Errorf("invalid user id %q", fields[0])
+	}
+	if _, err := strconv.Atoi(fields[1]); err != nil {
+		return rating, fmt.Errorf("invalid movie id %q", fields[1])
+	}
+	value, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return rating, fmt.Errorf("invalid rating %q", fields[2])
+	}
+	rating.value = value
+	if len(fields) > 3 {
+		rating.timestamp, err = strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return rating, fmt.Errorf("invalid timestamp %q", fields[3])
+		}
+	}
+	return rating, nil
+}
+
+func readLines(filename string, handle func(line int, text string) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("movielensError: Error opening file %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		err = handle(line, text)
+		if err != nil {
+			return fmt.Errorf("movielensError: %s:%d: %v", filename, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("movielensError: Error reading file %s: %v", filename, err)
+	}
+	return nil
+}
+
+// readCsv recorre un CSV con encabezado, como los de ml-latest.
+func readCsv(filename string, handle func(line int, record []string) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("movielensError: Error opening file %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(bufio.NewReader(file))
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	header := true
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("movielensError: Error reading file %s: %v", filename, err)
+		}
+		if header {
+			header = false
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		err = handle(line, record)
+		if err != nil {
+			return fmt.Errorf("movielensError: %s:%d: %v", filename, line, err)
+		}
+	}
+}
+
+// clean filtra los ratings, descarta las películas sin título y reindexa
+// usuarios y películas en orden ascendente de id, como el pivot del notebook.
+func clean(ratings []rawRating, movies []Movie, genreNames []string, config CleanConfig) (*Result, error) {
+	catalog := make(map[string]*Movie, len(movies))
+	for i := range movies {
+		catalog[movies[i].Id] = &movies[i]
+	}
+	movieCounts := make(map[string]int)
+	for _, rating := range ratings {
+		if _, ok := catalog[rating.movieId]; ok {
+			movieCounts[rating.movieId]++
+		}
+	}
+	keptMovies := topIds(movieCounts, config.MinMovieRatings, config.MaxMovies)
+
+	userCounts := make(map[string]int)
+	for _, rating := range ratings {
+		if keptMovies[rating.movieId] {
+			userCounts[rating.userId]++
+		}
+	}
+	keptUsers := topIds(userCounts, config.MinUserRatings, config.MaxUsers)
+
+	var filtered []rawRating
+	for _, rating := range ratings {
+		if keptUsers[rating.userId] && keptMovies[rating.movieId] {
+			filtered = append(filtered, rating)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil, fmt.Errorf("movielensError: No ratings left after cleaning")
+	}
+
+	result := &Result{}
+	userIndex := make(map[string]int)
+	movieIndex := make(map[string]int)
+	result.UserIds = sortedIds(filtered, func(rating rawRating) string { return rating.userId })
+	result.MovieIds = sortedIds(filtered, func(rating rawRating) string { return rating.movieId })
+	for i, id := range result.UserIds {
+		userIndex[id] = i
+	}
+	for i, id := range result.MovieIds {
+		movieIndex[id] = i
+	}
+	result.Ratings = make([]model.Rating, len(filtered))
+	for i, rating := range filtered {
+		result.Ratings[i] = model.Rating{
+			UserId:    userIndex[rating.userId],
+			ItemId:    movieIndex[rating.movieId],
+			Value:     rating.value,
+			Timestamp: rating.timestamp,
+		}
+	}
+
+	// Sin u.genre (ml-latest) los géneros se ordenan alfabéticamente para que
+	// los ids no cambien entre importaciones.
+	if genreNames == nil {
+		seen := make(map[string]bool)
+		for _, id := range result.MovieIds {
+			for _, genre := range catalog[id].Genres {
+				if !seen[genre] {
+					seen[genre] = true
+					genreNames = append(genreNames, genre)
+				}
+			}
+		}
+		sort.Strings(genreNames)
+	}
+	genreIndex := make(map[string]int, len(genreNames))
+	for i, genre := range genreNames {
+		genreIndex[genre] = i
+	}
+	result.MovieGenreNames = genreNames
+	result.MovieTitles = make([]string, len(result.MovieIds))
+	result.MovieGenreIds = make([][]int, len(result.MovieIds))
+	for i, id := range result.MovieIds {
+		movie := catalog[id]
+		result.MovieTitles[i] = movie.Title
+		result.MovieGenreIds[i] = make([]int, 0, len(movie.Genres))
+		for _, genre := range movie.Genres {
+			result.MovieGenreIds[i] = append(result.MovieGenreIds[i], genreIndex[genre])
+		}
+	}
+	return result, nil
+}
+
+// topIds devuelve los ids con más de minCount apariciones, limitados a los
+// maxCount más frecuentes (los empates se resuelven por id).
+func topIds(counts map[string]int, minCount, maxCount int) map[string]bool {
+	ids := make([]string, 0, len(counts))
+	for id, count := range counts {
+		if count > minCount {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		if counts[ids[a]] != counts[ids[b]] {
+			return counts[ids[a]] > counts[ids[b]]
+		}
+		return numericLess(ids[a], ids[b])
+	})
+	if maxCount > 0 && len(ids) > maxCount {
+		ids = ids[:maxCount]
+	}
+	kept := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		kept[id] = true
+	}
+	return kept
+}
+
+func sortedIds(ratings []rawRating, id func(rating rawRating) string) []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, rating := range ratings {
+		if !seen[id(rating)] {
+			seen[id(rating)] = true
+			ids = append(ids, id(rating))
+		}
+	}
+	sort.Slice(ids, func(a, b int) bool {
+		return numericLess(ids[a], ids[b])
+	})
+	return ids
+}
+
+// numericLess compara ids enteros (ya validados en parseRating).
+func numericLess(a, b string) bool {
+	x, _ := strconv.Atoi(a)
+	y, _ := strconv.Atoi(b)
+	return x < y
+}
+
+// WriteRatings guarda los ratings limpios en el formato que lee
+// model.LoadRatings: userId;movieId;rating;timestamp con encabezado.
+func WriteRatings(filename string, ratings []model.Rating) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("movielensError: Error creating file %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	fmt.Fprintln(writer, "userId;movieId;rating;timestamp")
+	for _, rating := range ratings {
+		fmt.Fprintf(writer, "%d;%d;%s;%d\n", rating.UserId, rating.ItemId, strconv.FormatFloat(rating.Value, 'f', -1, 64), rating.Timestamp)
+	}
+	err = writer.Flush()
+	if err != nil {
+		return fmt.Errorf("movielensError: Error writing file %s: %v", filename, err)
+	}
+	return file.Close()
+}
@@ -1,6 +1,7 @@
 package slave
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -8,14 +9,38 @@ import (
 	"recommendation-service/model"
 	"recommendation-service/syncutils"
 	"sort"
+	"sync"
 	"time"
 )
 
 type Slave struct {
-	ip            string
-	masterIp      string
-	model         model.Model
+	ip       string
+	masterIp string
+	// El estado se reemplaza entero en cada sincronización; cada
+	// recomendación usa el que estaba vigente al empezar.
+	stateMu sync.RWMutex
+	state   *modelState
+	synced  sync.Once
+	// Configuración del índice de la última sincronización completa
+	ann *model.AnnConfig
+	// Transferencia en chunks sin terminar; solo la usa el proceso de
+	// sincronización, que atiende un pedido a la vez.
+	transfer *syncutils.ModelReceiver
+}
+
+// modelState es el modelo que sirve el slave. No se modifica una vez
+// publicado, salvo index, que se completa cuando termina de construirse.
+type modelState struct {
+	model         *model.Model
 	movieGenreIds [][]int
+	version       int
+	// Índice aproximado sobre Q, nil si no está habilitado o no alcanzó el
+	// recall mínimo
+	index *model.ItemIndex
+	// En modo sharding el modelo tiene solo las filas del shard; movieIds
+	// es el id global de cada una, en orden ascendente.
+	shard    *syncutils.Shard
+	movieIds []int
 }
 
 func (slave *Slave) Init() error {
@@ -23,18 +48,49 @@ func (slave *Slave) Init() error {
 	return nil
 }
 
+// Run atiende las sincronizaciones del master durante toda la vida del
+// slave; las recomendaciones empiezan a atenderse después de la primera.
 func (slave *Slave) Run() {
+	ready := make(chan struct{})
+	go func() {
+		<-ready
+		for {
+			slave.handleRecommendations()
+			time.Sleep(time.Second)
+		}
+	}()
 	for {
-		slave.handleSynchronization()
-		slave.handleRecommendations()
+		slave.handleSynchronization(ready)
+		time.Sleep(time.Second)
+	}
+}
+
+func (slave *Slave) currentState() *modelState {
+	slave.stateMu.RLock()
+	defer slave.stateMu.RUnlock()
+	if slave.state == nil {
+		return nil
+	}
+	state := *slave.state
+	return &state
+}
+
+// hello es el que se responde al master: la versión del modelo le permite
+// decidir si puede enviar solo las películas actualizadas.
+func (slave *Slave) hello() syncutils.Hello {
+	hello := syncutils.NewHello("slave-" + slave.ip)
+	if state := slave.currentState(); state != nil {
+		hello.ModelVersion = state.version
+		hello.HasModel = true
 	}
+	return hello
 }
 
 // Proceso de sincronización
 const handleSynchronizationPrefix = "handleSync"
 
-func (slave *Slave) handleSynchronization() {
-	syncLstn, err := net.Listen("tcp", fmt.Sprintf("%s:%d", slave.ip, syncutils.SyncronizationPort))
+func (slave *Slave) handleSynchronization(ready chan struct{}) {
+	syncLstn, err := net.Listen("tcp", syncutils.JoinAddress(slave.ip, syncutils.SyncronizationPort))
 	log.Println("INFO: Slave listening for syncronization on", syncutils.JoinAddress(slave.ip, syncutils.SyncronizationPort))
 	if err != nil {
 		log.Printf("ERROR: %s: Error setting local listener: %v", handleSynchronizationPrefix, err)
@@ -42,8 +98,7 @@ func (slave *Slave) handleSynchronization() {
 	}
 	defer syncLstn.Close()
 
-	success := false
-	for !success {
+	for {
 		conn, err := syncLstn.Accept()
 		if err != nil {
 			log.Printf("ERROR: %s: Connection error: %v", handleSynchronizationPrefix, err)
@@ -52,20 +107,20 @@ func (slave *Slave) handleSynchronization() {
 		timeout := 20 * time.Second
 		conn.SetDeadline(time.Now().Add(timeout))
 
-		err = slave.handleSyncRequest(&conn)
+		err = slave.handleSyncRequest(syncutils.AcceptConn(conn, slave.hello()))
 		if err != nil {
 			log.Printf("ERROR: %s: Error handling sync request: %v", handleSynchronizationPrefix, err)
 			continue
 		}
 		log.Println("INFO: Synchronization successful")
-		break
+		slave.synced.Do(func() { close(ready) })
 	}
 }
 
 const handleSyncRequestPrefix = "handleSyncRequest"
 
-func (slave *Slave) handleSyncRequest(conn *net.Conn) error {
-	defer (*conn).Close()
+func (slave *Slave) handleSyncRequest(conn *syncutils.Conn) error {
+	defer conn.Close()
 	log.Printf("INFO: %s: Handling sync request\n", handleSyncRequestPrefix)
 	defer log.Printf("INFO: %s: Synchronization request handled\n", handleSyncRequestPrefix)
 
@@ -74,32 +129,131 @@ func (slave *Slave) handleSyncRequest(conn *net.Conn) error {
 	if err != nil {
 		return fmt.Errorf("%s: Error handling request: %v", handleSyncRequestPrefix, err)
 	}
+	log.Printf("INFO: %s: Request from %s, codec %s\n", handleSyncRequestPrefix, conn.Peer(), conn.Codec().Name())
 
-	err = slave.processSyncRequest(&syncRequest)
+	var status int
+	if syncRequest.Transfer != nil {
+		status, err = slave.receiveModelChunks(conn, &syncRequest)
+	} else {
+		status, err = slave.processSyncRequest(&syncRequest)
+	}
 	if err != nil {
 		return fmt.Errorf("%s: Error handling request: %v", handleSyncRequestPrefix, err)
 	}
+	conn.SetDeadline(time.Now().Add(syncTimeout))
 	//log.Println("test: ", slave.model.Predict(1, 1))
-	err = repondSyncRequest(conn)
+	err = repondSyncRequest(conn, status)
 	if err != nil {
 		return fmt.Errorf("%s: Error handling request: %v", handleSyncRequestPrefix, err)
 	}
+	if status == syncutils.SyncStatusDigestMismatch {
+		return fmt.Errorf("%s: Model discarded", handleSyncRequestPrefix)
+	}
 	return nil
 }
 
 // Recibir solicitud de sincronización
-func receiveSyncRequest(conn *net.Conn, syncRequest *syncutils.MasterSyncRequest) error {
-	err := syncutils.ReceiveJsonMessageAsObject(&syncRequest, conn)
+func receiveSyncRequest(conn *syncutils.Conn, syncRequest *syncutils.MasterSyncRequest) error {
+	err := conn.Receive(&syncRequest)
 	if err != nil {
 		return fmt.Errorf("syncRequestErr. Error receiving request: %v", err)
 	}
 	return nil
 }
 
-func (slave *Slave) processSyncRequest(syncRequest *syncutils.MasterSyncRequest) error {
+const syncTimeout = 20 * time.Second
+
+const receiveModelChunksPrefix = "receiveModelChunks"
+
+// receiveModelChunks pide los chunks que faltan de la transferencia
+// anunciada, retomando la anterior si es la misma. El modelo se usa recién
+// cuando está completo y coincide con el digest.
+func (slave *Slave) receiveModelChunks(conn *syncutils.Conn, syncRequest *syncutils.MasterSyncRequest) (int, error) {
+	transfer := *syncRequest.Transfer
+	if slave.transfer == nil || slave.transfer.Transfer() != transfer {
+		receiver, err := syncutils.NewModelReceiver(transfer)
+		if err != nil {
+			return 0, err
+		}
+		slave.transfer = receiver
+		log.Printf("INFO: %s: Receiving %d bytes in %d chunks", receiveModelChunksPrefix, transfer.Size, transfer.NumChunks)
+	} else {
+		log.Printf("INFO: %s: Resuming transfer at chunk %d/%d", receiveModelChunksPrefix, slave.transfer.Next(), transfer.NumChunks)
+	}
+	receiver := slave.transfer
+	reported := transfer.Progress(receiver.Next()) / 10
+	for !receiver.Done() {
+		conn.SetDeadline(time.Now().Add(syncTimeout))
+		err := conn.Send(&syncutils.SlaveSyncResponse{Status: syncutils.SyncStatusNextChunk, NextChunk: receiver.Next()})
+		if err != nil {
+			return 0, fmt.Errorf("%s: Error requesting chunk %d: %v", receiveModelChunksPrefix, receiver.Next(), err)
+		}
+		var chunk syncutils.SyncChunk
+		err = conn.Receive(&chunk)
+		if err != nil {
+			return 0, fmt.Errorf("%s: Error receiving chunk %d: %v", receiveModelChunksPrefix, receiver.Next(), err)
+		}
+		// Un chunk inválido se vuelve a pedir
+		err = receiver.Add(&chunk)
+		if err != nil {
+			log.Printf("ERROR: %s: %v", receiveModelChunksPrefix, err)
+			continue
+		}
+		if progress := transfer.Progress(receiver.Next()) / 10; progress > reported {
+			reported = progress
+			log.Printf("INFO: %s: %d%% (%d/%d chunks)", receiveModelChunksPrefix, transfer.Progress(receiver.Next()), receiver.Next(), transfer.NumChunks)
+		}
+	}
+	slave.transfer = nil
+
+	var decoded syncutils.MasterSyncRequest
+	err := receiver.Decode(&decoded)
+	if errors.Is(err, syncutils.ErrDigestMismatch) {
+		log.Printf("ERROR: %s: %v, discarding transfer", receiveModelChunksPrefix, err)
+		return syncutils.SyncStatusDigestMismatch, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if decoded.Delta || decoded.Transfer != nil {
+		return 0, fmt.Errorf("%s: Transfer does not contain a full model", receiveModelChunksPrefix)
+	}
+	*syncRequest = decoded
+	return slave.processSyncRequest(syncRequest)
+}
+
+func (slave *Slave) processSyncRequest(syncRequest *syncutils.MasterSyncRequest) (int, error) {
+	if syncRequest.Delta {
+		return slave.processItemUpdates(syncRequest), nil
+	}
+	var movieIds []int
+	if syncRequest.Shard != nil {
+		err := syncRequest.Shard.Validate()
+		if err != nil {
+			return 0, err
+		}
+		movieIds = syncRequest.Shard.MovieIds()
+		if len(syncRequest.ModelConfig.Q) != len(movieIds) || len(syncRequest.MovieGenreIds) != len(movieIds) {
+			return 0, fmt.Errorf("syncError: Shard %d has %d movies, got %d item factors and %d genre lists", syncRequest.Shard.Index, len(movieIds), len(syncRequest.ModelConfig.Q), len(syncRequest.MovieGenreIds))
+		}
+		log.Printf("INFO: Shard %d of %d (%s): %d movies", syncRequest.Shard.Index, syncRequest.Shard.NumShards, syncRequest.Shard.Mode, len(movieIds))
+	}
 	slave.masterIp = syncRequest.MasterIp
-	slave.movieGenreIds = syncRequest.MovieGenreIds
-	slave.model = model.LoadModel(&syncRequest.ModelConfig)
+	loaded := model.LoadModel(&syncRequest.ModelConfig)
+	state := &modelState{
+		model:         &loaded,
+		movieGenreIds: syncRequest.MovieGenreIds,
+		version:       syncRequest.ModelVersion,
+		shard:         syncRequest.Shard,
+		movieIds:      movieIds,
+	}
+	if syncRequest.Ann != nil && syncRequest.Ann.Enabled {
+		state.index = buildIndex(state.model, syncRequest.Ann)
+	}
+	slave.ann = syncRequest.Ann
+	slave.stateMu.Lock()
+	slave.state = state
+	slave.stateMu.Unlock()
 
 	log.Println("INFO: Master IP ", slave.masterIp)
 	/*
@@ -125,12 +279,82 @@ func (slave *Slave) processSyncRequest(syncRequest *syncutils.MasterSyncRequest)
 			log.Println("Q: ", len(slave.model.Q), ", ", 0)
 		}
 	*/
-	return nil
+	return syncutils.SyncStatusOk, nil
+}
+
+const processItemUpdatesPrefix = "processItemUpdates"
+
+// processItemUpdates aplica las filas de Q actualizadas por el entrenamiento
+// incremental. Si el slave no está en la versión base pide una
+// sincronización completa.
+func (slave *Slave) processItemUpdates(syncRequest *syncutils.MasterSyncRequest) int {
+	current := slave.currentState()
+	if current == nil || current.version != syncRequest.BaseVersion {
+		log.Printf("INFO: %s: Model version mismatch, requesting full synchronization", processItemUpdatesPrefix)
+		return syncutils.SyncStatusNeedFull
+	}
+	updated := *current.model
+	updated.Q = append([][]float64(nil), current.model.Q...)
+	if updated.ItemBias != nil {
+		updated.ItemBias = append([]float64(nil), current.model.ItemBias...)
+	}
+	for _, update := range syncRequest.ItemUpdates {
+		row, ok := current.localId(update.MovieId)
+		if !ok || len(update.Factors) != updated.NumFeatures() {
+			log.Printf("ERROR: %s: Invalid update for movie %d, requesting full synchronization", processItemUpdatesPrefix, update.MovieId)
+			return syncutils.SyncStatusNeedFull
+		}
+		updated.Q[row] = update.Factors
+		if updated.ItemBias != nil {
+			updated.ItemBias[row] = update.Bias
+		}
+	}
+	state := &modelState{
+		model:         &updated,
+		movieGenreIds: current.movieGenreIds,
+		version:       syncRequest.ModelVersion,
+		shard:         current.shard,
+		movieIds:      current.movieIds,
+	}
+	slave.stateMu.Lock()
+	slave.state = state
+	slave.stateMu.Unlock()
+	log.Printf("INFO: %s: %d movies updated to model version %d", processItemUpdatesPrefix, len(syncRequest.ItemUpdates), state.version)
+
+	// Mientras se reconstruye el índice se recomienda en forma exacta
+	if slave.ann != nil && slave.ann.Enabled {
+		go func(config *model.AnnConfig) {
+			index := buildIndex(state.model, config)
+			slave.stateMu.Lock()
+			if slave.state == state {
+				state.index = index
+			}
+			slave.stateMu.Unlock()
+		}(slave.ann)
+	}
+	return syncutils.SyncStatusOk
+}
+
+const buildIndexPrefix = "buildIndex"
+
+func buildIndex(served *model.Model, config *model.AnnConfig) *model.ItemIndex {
+	start := time.Now()
+	index, err := served.BuildItemIndex(*config)
+	if err != nil {
+		log.Printf("ERROR: %s: Using exact recommendations: %v", buildIndexPrefix, err)
+		return nil
+	}
+	log.Printf("INFO: %s: Index with %d lists built in %v, %d probes, validation recall@10 %.3f", buildIndexPrefix, index.NumLists(), time.Since(start), index.NumProbes(), index.Recall())
+	if index.Recall() < config.MinRecall {
+		log.Printf("ERROR: %s: Recall below %.3f, using exact recommendations", buildIndexPrefix, config.MinRecall)
+		return nil
+	}
+	return index
 }
 
 // Responder solicitud de sincronización
-func repondSyncRequest(conn *net.Conn) error {
-	err := syncutils.SendObjectAsJsonMessage(syncutils.SlaveSyncResponse{Status: 0}, conn)
+func repondSyncRequest(conn *syncutils.Conn, status int) error {
+	err := conn.Send(syncutils.SlaveSyncResponse{Status: status})
 	if err != nil {
 		return fmt.Errorf("syncResponseErr. Error sending response: %v", err)
 	}
@@ -139,8 +363,8 @@ func repondSyncRequest(conn *net.Conn) error {
 
 func (slave *Slave) handleRecommendations() {
 	log.Println("INFO: Start handling recs")
-	recLstn, err := net.Listen("tcp", fmt.Sprintf("%s:%d", slave.ip, syncutils.RecommendationPort))
-	log.Println("Slave listening for recommendation requests on", fmt.Sprintf("%s:%d", slave.ip, syncutils.RecommendationPort))
+	recLstn, err := net.Listen("tcp", syncutils.JoinAddress(slave.ip, syncutils.RecommendationPort))
+	log.Println("Slave listening for recommendation requests on", syncutils.JoinAddress(slave.ip, syncutils.RecommendationPort))
 	if err != nil {
 		log.Printf("ERROR: recErr: Error setting local listener: %v", err)
 		return
@@ -155,12 +379,12 @@ func (slave *Slave) handleRecommendations() {
 		timeout := 20 * time.Second
 		conn.SetDeadline(time.Now().Add(timeout))
 
-		go slave.handleRecommendation(&conn)
+		go slave.handleRecommendation(syncutils.AcceptConn(conn, slave.hello()))
 	}
 }
 
-func (slave *Slave) handleRecommendation(conn *net.Conn) {
-	defer (*conn).Close()
+func (slave *Slave) handleRecommendation(conn *syncutils.Conn) {
+	defer conn.Close()
 	log.Println("INFO: Handling recommendation")
 	defer log.Println("INFO: Recommendation handled")
 
@@ -172,9 +396,19 @@ func (slave *Slave) handleRecommendation(conn *net.Conn) {
 	}
 	log.Println("INFO: Recommendation request received")
 	//log.Println("TEST: Recommendation Request", request)
+	state := slave.currentState()
+	if state == nil {
+		log.Printf("ERROR: recHandleErr: No model synchronized yet")
+		return
+	}
+	request = state.localRequest(&request)
+	if request.Type == syncutils.RequestSimilar {
+		state.handleSimilar(&request, conn)
+		return
+	}
 
 	var partialUserFactors syncutils.SlavePartialUserFactors
-	err = slave.calcPartialUserFactors(&partialUserFactors, &request)
+	err = state.calcPartialUserFactors(&partialUserFactors, &request)
 	if err != nil {
 		log.Printf("ERROR: recHandleErr: Error handling recommendation: %v", err)
 		return
@@ -198,12 +432,13 @@ func (slave *Slave) handleRecommendation(conn *net.Conn) {
 	//log.Println("TEST: masterUserFactors", masterUserFactors)
 
 	var response syncutils.SlaveRecResponse
-	err = slave.processRecommendation(&response, &request, masterUserFactors.UserFactors)
+	err = state.processRecommendation(&response, &request, masterUserFactors.UserFactors, masterUserFactors.UserBias)
 	if err != nil {
 		log.Printf("ERROR: recHandleErr: Error handling recommendation: %v", err)
 		return
 	}
 	log.Println("INFO: Recommendations obtained")
+	state.globalPredictions(response.Predictions)
 
 	err = respondRecRequest(&response, conn)
 	if err != nil {
@@ -213,60 +448,84 @@ func (slave *Slave) handleRecommendation(conn *net.Conn) {
 	log.Println("INFO: Recommendation handled successfully")
 }
 
-func receiveRecRequest(recRequest *syncutils.MasterRecRequest, conn *net.Conn) error {
-	err := syncutils.ReceiveJsonMessageAsObject(recRequest, conn)
+func receiveRecRequest(recRequest *syncutils.MasterRecRequest, conn *syncutils.Conn) error {
+	err := conn.Receive(recRequest)
 	if err != nil {
 		return fmt.Errorf("recReceiveRequestErr: Error receiving request: %v", err)
 	}
 	return nil
 }
 
-func (slave *Slave) calcPartialUserFactors(partialUserFactors *syncutils.SlavePartialUserFactors, request *syncutils.MasterRecRequest) error {
-	weightedGrad, count := slave.model.UpdateUserFactors(request.UserRatings, &request.UserFactors, request.StartMovieId, request.EndMovieId)
+func (state *modelState) calcPartialUserFactors(partialUserFactors *syncutils.SlavePartialUserFactors, request *syncutils.MasterRecRequest) error {
+	switch state.model.Algorithm() {
+	case model.AlgorithmALS:
+		gram, rhs, count := state.model.UserNormalEquations(request.UserRatings)
+		partialUserFactors.UserId = request.UserId
+		partialUserFactors.Gram = gram
+		partialUserFactors.Rhs = rhs
+		partialUserFactors.Count = count
+		return nil
+	case model.AlgorithmImplicitALS:
+		gram, rhs, count := state.model.ImplicitUserNormalEquations(request.UserRatings, request.StartMovieId, request.EndMovieId)
+		partialUserFactors.UserId = request.UserId
+		partialUserFactors.Gram = gram
+		partialUserFactors.Rhs = rhs
+		partialUserFactors.Count = count
+		return nil
+	}
+	weightedGrad, weightedBiasGrad, count := state.model.UpdateUserFactors(request.UserRatings, &request.UserFactors, &request.UserBias)
 
 	partialUserFactors.UserId = request.UserId
 	partialUserFactors.WeightedGrad = weightedGrad
+	partialUserFactors.WeightedBiasGrad = weightedBiasGrad
 	partialUserFactors.Count = count
 	return nil
 }
 
-func sendPartialUserFactors(partialUserFactors *syncutils.SlavePartialUserFactors, conn *net.Conn) error {
-	err := syncutils.SendObjectAsJsonMessage(partialUserFactors, conn)
+func sendPartialUserFactors(partialUserFactors *syncutils.SlavePartialUserFactors, conn *syncutils.Conn) error {
+	err := conn.Send(partialUserFactors)
 	if err != nil {
 		return fmt.Errorf("sendPartialUserFactorsErr: Error sending partial user factors: %v", err)
 	}
 	return nil
 }
 
-func receiveUserFactors(masterUserFactors *syncutils.MasterUserFactors, conn *net.Conn) error {
-	err := syncutils.ReceiveJsonMessageAsObject(masterUserFactors, conn)
+func receiveUserFactors(masterUserFactors *syncutils.MasterUserFactors, conn *syncutils.Conn) error {
+	err := conn.Receive(masterUserFactors)
 	if err != nil {
 		return fmt.Errorf("recReceiveUserFactorsErr: Error receiving user factors: %v", err)
 	}
 	return nil
 }
 
-func (slave *Slave) processRecommendation(response *syncutils.SlaveRecResponse, request *syncutils.MasterRecRequest, userFactors []float64) error {
+func (state *modelState) processRecommendation(response *syncutils.SlaveRecResponse, request *syncutils.MasterRecRequest, userFactors []float64, userBias float64) error {
+	if state.index != nil {
+		return state.processIndexedRecommendation(response, request, userFactors, userBias)
+	}
 	sum := 0.0
 	max := math.Inf(-1)
 	min := math.Inf(1)
 	count := 0
 
 	n := request.EndMovieId - request.StartMovieId
-	pred := make([]syncutils.Prediction, request.EndMovieId-request.StartMovieId)
+	top := syncutils.NewTopK(request.Quantity)
 
+	rated := 0
 	for i := 0; i < n; i++ {
 		movieId := i + request.StartMovieId
-		if request.UserRatings[i] == 0 {
-			if len(request.GenreIds) > 0 && !containsAll(slave.movieGenreIds[movieId], request.GenreIds) {
+		for rated < request.UserRatings.Len() && request.UserRatings.Indices[rated] < movieId {
+			rated++
+		}
+		if rated >= request.UserRatings.Len() || request.UserRatings.Indices[rated] != movieId {
+			if len(request.GenreIds) > 0 && !containsAll(state.movieGenreIds[movieId], request.GenreIds) {
 				continue
 			}
 
-			rating := slave.model.PredictUser(userFactors, movieId)
-			pred[count] = syncutils.Prediction{
+			rating := state.model.PredictUser(userFactors, userBias, movieId)
+			top.Push(syncutils.Prediction{
 				MovieId: movieId,
 				Rating:  rating,
-			}
+			})
 			if max < rating {
 				max = rating
 			}
@@ -284,15 +543,7 @@ func (slave *Slave) processRecommendation(response *syncutils.SlaveRecResponse,
 		response.Min = 0
 		response.Count = 0
 	} else {
-		pred = pred[:count]
-		sort.Slice(pred, func(i, j int) bool {
-			return pred[i].Rating > pred[j].Rating
-		})
-		if count > request.Quantity {
-			response.Predictions = pred[:request.Quantity]
-		} else {
-			response.Predictions = pred
-		}
+		response.Predictions = top.Sorted()
 		response.Sum = sum
 		response.Max = max
 		response.Min = min
@@ -301,6 +552,113 @@ func (slave *Slave) processRecommendation(response *syncutils.SlaveRecResponse,
 	return nil
 }
 
+func (state *modelState) handleSimilar(request *syncutils.MasterRecRequest, conn *syncutils.Conn) {
+	var response syncutils.SlaveRecResponse
+	err := state.processSimilar(&response, request)
+	if err != nil {
+		log.Printf("ERROR: recHandleErr: Error handling similar movies: %v", err)
+		return
+	}
+	state.globalPredictions(response.Predictions)
+	err = respondRecRequest(&response, conn)
+	if err != nil {
+		log.Printf("ERROR: recHandleErr: Error handling similar movies: %v", err)
+		return
+	}
+	log.Println("INFO: Similar movies handled successfully")
+}
+
+func (state *modelState) processSimilar(response *syncutils.SlaveRecResponse, request *syncutils.MasterRecRequest) error {
+	if request.StartMovieId < 0 || request.EndMovieId > len(state.model.Q) || request.StartMovieId > request.EndMovieId {
+		return fmt.Errorf("similarErr: Movie range [%d, %d) out of model range [0, %d)", request.StartMovieId, request.EndMovieId, len(state.model.Q))
+	}
+	if len(request.MovieFactors) != state.model.NumFeatures() {
+		return fmt.Errorf("similarErr: Movie factors have %d features, model has %d", len(request.MovieFactors), state.model.NumFeatures())
+	}
+	neighbors := state.model.NearestItems(request.MovieFactors, request.StartMovieId, request.EndMovieId, request.Quantity, func(movieId int) bool {
+		if movieId == request.MovieId {
+			return false
+		}
+		return len(request.GenreIds) == 0 || containsAll(state.movieGenreIds[movieId], request.GenreIds)
+	})
+
+	response.Predictions = make([]syncutils.Prediction, len(neighbors))
+	for i, neighbor := range neighbors {
+		response.Predictions[i] = syncutils.Prediction{MovieId: neighbor.ItemId, Rating: neighbor.Similarity}
+	}
+	response.Count = len(neighbors)
+	if len(neighbors) > 0 {
+		response.Max = neighbors[0].Similarity
+		response.Min = neighbors[len(neighbors)-1].Similarity
+	}
+	for _, neighbor := range neighbors {
+		response.Sum += neighbor.Similarity
+	}
+	return nil
+}
+
+// processIndexedRecommendation busca con el índice aproximado. Sum, Max, Min y
+// Count son de las películas recorridas, no de todo el rango.
+func (state *modelState) processIndexedRecommendation(response *syncutils.SlaveRecResponse, request *syncutils.MasterRecRequest, userFactors []float64, userBias float64) error {
+	items, stats := state.index.Search(userFactors, userBias, request.Quantity, 0, func(movieId int) bool {
+		if movieId < request.StartMovieId || movieId >= request.EndMovieId || request.UserRatings.Contains(movieId) {
+			return false
+		}
+		return len(request.GenreIds) == 0 || containsAll(state.movieGenreIds[movieId], request.GenreIds)
+	})
+	response.Predictions = make([]syncutils.Prediction, len(items))
+	for i, item := range items {
+		response.Predictions[i] = syncutils.Prediction{MovieId: item.ItemId, Rating: item.Score}
+	}
+	response.Sum = stats.Sum
+	response.Max = stats.Max
+	response.Min = stats.Min
+	response.Count = stats.Scored
+	return nil
+}
+
+// localId devuelve la fila de Q de movieId, si la película está en el modelo.
+func (state *modelState) localId(movieId int) (int, bool) {
+	if state.movieIds == nil {
+		return movieId, movieId >= 0 && movieId < len(state.model.Q)
+	}
+	row := sort.SearchInts(state.movieIds, movieId)
+	return row, row < len(state.movieIds) && state.movieIds[row] == movieId
+}
+
+// localRequest traduce los ids globales del pedido a filas del shard. Como
+// las filas siguen el orden de los ids, un rango global es un rango de filas.
+func (state *modelState) localRequest(request *syncutils.MasterRecRequest) syncutils.MasterRecRequest {
+	local := *request
+	if state.movieIds == nil {
+		return local
+	}
+	local.StartMovieId = sort.SearchInts(state.movieIds, request.StartMovieId)
+	local.EndMovieId = sort.SearchInts(state.movieIds, request.EndMovieId)
+	local.UserRatings = model.SparseVector{Indices: []int{}, Values: []float64{}}
+	for n, movieId := range request.UserRatings.Indices {
+		if row, ok := state.localId(movieId); ok {
+			local.UserRatings.Indices = append(local.UserRatings.Indices, row)
+			local.UserRatings.Values = append(local.UserRatings.Values, request.UserRatings.Values[n])
+		}
+	}
+	local.MovieId = -1
+	if row, ok := state.localId(request.MovieId); ok {
+		local.MovieId = row
+	}
+	return local
+}
+
+// globalPredictions pasa las filas del shard a ids globales.
+func (state *modelState) globalPredictions(predictions []syncutils.Prediction) {
+	if state.movieIds == nil {
+		return
+	}
+	for i := range predictions {
+		predictions[i].MovieId = state.movieIds[predictions[i].MovieId]
+	}
+}
+
 func containsAll(movieGenres, requestGenres []int) bool {
 	genreMap := make(map[int]bool)
 	for _, genre := range movieGenres {
@@ -314,8 +672,8 @@ func containsAll(movieGenres, requestGenres []int) bool {
 	return true
 }
 
-func respondRecRequest(response *syncutils.SlaveRecResponse, conn *net.Conn) error {
-	err := syncutils.SendObjectAsJsonMessage(response, conn)
+func respondRecRequest(response *syncutils.SlaveRecResponse, conn *syncutils.Conn) error {
+	err := conn.Send(response)
 	if err != nil {
 		return fmt.Errorf("recRespondRequestErr: Error sending response: %v", err)
 	}
@@ -0,0 +1,466 @@
+package syncutils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"unsafe"
+)
+
+// Nombres de los codecs que se negocian en el hello
+const (
+	CodecJSON   = "json"
+	CodecGob    = "gob"
+	CodecBinary = "binary"
+)
+
+// Tipos de frame de cada codec
+const (
+	FrameGob    byte = 3
+	FrameBinary byte = 4
+)
+
+// DefaultCodecs es el orden de preferencia por defecto: binary es el más
+// compacto y JSON el que entienden todos los nodos.
+var DefaultCodecs = []string{CodecBinary, CodecGob, CodecJSON}
+
+// Codec serializa los mensajes entre master y slave. El tipo de frame indica
+// al receptor con qué codec decodificar cada mensaje.
+type Codec interface {
+	Name() string
+	FrameType() byte
+	Marshal(object any) ([]byte, error)
+	Unmarshal(data []byte, object any) error
+}
+
+var codecs = []Codec{jsonCodec{}, gobCodec{}, binaryCodec{}}
+
+// CodecByName devuelve el codec con ese nombre, o nil si no existe.
+func CodecByName(name string) Codec {
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec
+		}
+	}
+	return nil
+}
+
+func codecByFrameType(frameType byte) Codec {
+	for _, codec := range codecs {
+		if codec.FrameType() == frameType {
+			return codec
+		}
+	}
+	return nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string    { return CodecJSON }
+func (jsonCodec) FrameType() byte { return FrameJSON }
+
+func (jsonCodec) Marshal(object any) ([]byte, error) {
+	return json.Marshal(object)
+}
+
+func (jsonCodec) Unmarshal(data []byte, object any) error {
+	return json.Unmarshal(data, object)
+}
+
+// gobCodec usa un encoder nuevo por mensaje, así cada frame se decodifica
+// solo aunque repita la descripción de los tipos.
+type gobCodec struct{}
+
+func (gobCodec) Name() string    { return CodecGob }
+func (gobCodec) FrameType() byte { return FrameGob }
+
+func (gobCodec) Marshal(object any) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(object)
+	return buffer.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, object any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(object)
+}
+
+// binaryCodec escribe los campos exportados en orden, sin nombres ni tipos:
+// enteros como varint, floats y slices de floats como bytes little endian.
+// Es el más compacto pero los dos nodos tienen que tener los mismos structs,
+// por eso solo se usa si ambos lo negocian.
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string    { return CodecBinary }
+func (binaryCodec) FrameType() byte { return FrameBinary }
+
+func (binaryCodec) Marshal(object any) ([]byte, error) {
+	value := reflect.ValueOf(object)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil, fmt.Errorf("binaryCodec: Nil object")
+		}
+		value = value.Elem()
+	}
+	return appendBinary(nil, value)
+}
+
+func (binaryCodec) Unmarshal(data []byte, object any) error {
+	value := reflect.ValueOf(object)
+	if value.Kind() != reflect.Pointer || value.IsNil() {
+		return fmt.Errorf("binaryCodec: Unmarshal needs a non nil pointer")
+	}
+	value = value.Elem()
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		value = value.Elem()
+	}
+	decoder := binaryDecoder{data: data}
+	err := decoder.decode(value)
+	if err != nil {
+		return err
+	}
+	if len(decoder.data) != 0 {
+		return fmt.Errorf("binaryCodec: %d trailing bytes", len(decoder.data))
+	}
+	return nil
+}
+
+var (
+	gobEncoderType = reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+	gobDecoderType = reflect.TypeOf((*gob.GobDecoder)(nil)).Elem()
+	float64Type    = reflect.TypeOf(float64(0))
+)
+
+func appendBinary(data []byte, value reflect.Value) ([]byte, error) {
+	if value.Kind() == reflect.Pointer && value.Type().Implements(gobEncoderType) {
+		if value.IsNil() {
+			return append(data, 0), nil
+		}
+		encoded, err := value.Interface().(gob.GobEncoder).GobEncode()
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, 1)
+		data = binary.AppendUvarint(data, uint64(len(encoded)))
+		return append(data, encoded...), nil
+	}
+	switch value.Kind() {
+	case reflect.Bool:
+		if value.Bool() {
+			return append(data, 1), nil
+		}
+		return append(data, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(data, value.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return binary.AppendUvarint(data, value.Uint()), nil
+	case reflect.Float32:
+		return binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(value.Float()))), nil
+	case reflect.Float64:
+		return binary.LittleEndian.AppendUint64(data, math.Float64bits(value.Float())), nil
+	case reflect.String:
+		data = binary.AppendUvarint(data, uint64(value.Len()))
+		return append(data, value.String()...), nil
+	case reflect.Slice:
+		// 0 es nil, n+1 un slice de largo n
+		if value.IsNil() {
+			return append(data, 0), nil
+		}
+		data = binary.AppendUvarint(data, uint64(value.Len())+1)
+		if value.Type().Elem() == float64Type {
+			return appendFloats(data, value.Interface().([]float64)), nil
+		}
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return append(data, value.Bytes()...), nil
+		}
+		return appendElements(data, value)
+	case reflect.Array:
+		return appendElements(data, value)
+	case reflect.Pointer:
+		if value.IsNil() {
+			return append(data, 0), nil
+		}
+		return appendBinary(append(data, 1), value.Elem())
+	case reflect.Struct:
+		var err error
+		for i := 0; i < value.NumField(); i++ {
+			if !value.Type().Field(i).IsExported() {
+				continue
+			}
+			data, err = appendBinary(data, value.Field(i))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	case reflect.Map:
+		if value.IsNil() {
+			return append(data, 0), nil
+		}
+		data = binary.AppendUvarint(data, uint64(value.Len())+1)
+		var err error
+		iterator := value.MapRange()
+		for iterator.Next() {
+			data, err = appendBinary(data, iterator.Key())
+			if err != nil {
+				return nil, err
+			}
+			data, err = appendBinary(data, iterator.Value())
+			if err != nil {
+				return nil, err
+			}
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("binaryCodec: Unsupported type %s", value.Type())
+}
+
+func appendElements(data []byte, value reflect.Value) ([]byte, error) {
+	var err error
+	for i := 0; i < value.Len(); i++ {
+		data, err = appendBinary(data, value.Index(i))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func appendFloats(data []byte, values []float64) []byte {
+	if len(values) == 0 {
+		return data
+	}
+	if littleEndian {
+		return append(data, unsafe.Slice((*byte)(unsafe.Pointer(&values[0])), len(values)*8)...)
+	}
+	for _, value := range values {
+		data = binary.LittleEndian.AppendUint64(data, math.Float64bits(value))
+	}
+	return data
+}
+
+var littleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+type binaryDecoder struct {
+	data []byte
+}
+
+func (decoder *binaryDecoder) errTruncated() error {
+	return fmt.Errorf("binaryCodec: Truncated message")
+}
+
+func (decoder *binaryDecoder) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(decoder.data) {
+		return nil, decoder.errTruncated()
+	}
+	result := decoder.data[:n]
+	decoder.data = decoder.data[n:]
+	return result, nil
+}
+
+func (decoder *binaryDecoder) uvarint() (uint64, error) {
+	value, n := binary.Uvarint(decoder.data)
+	if n <= 0 {
+		return 0, decoder.errTruncated()
+	}
+	decoder.data = decoder.data[n:]
+	return value, nil
+}
+
+// length lee el largo de un slice o map; nil indica si era nil. Cada
+// elemento ocupa al menos minSize bytes, lo que acota largos inválidos.
+func (decoder *binaryDecoder) length(minSize int) (int, bool, error) {
+	encoded, err := decoder.uvarint()
+	if err != nil {
+		return 0, false, err
+	}
+	if encoded == 0 {
+		return 0, true, nil
+	}
+	n := encoded - 1
+	if n > uint64(len(decoder.data)/max(minSize, 1)) && minSize > 0 {
+		return 0, false, decoder.errTruncated()
+	}
+	return int(n), false, nil
+}
+
+func (decoder *binaryDecoder) decode(value reflect.Value) error {
+	if value.Kind() == reflect.Pointer && value.Type().Implements(gobDecoderType) {
+		present, err := decoder.bytes(1)
+		if err != nil {
+			return err
+		}
+		if present[0] == 0 {
+			value.SetZero()
+			return nil
+		}
+		size, err := decoder.uvarint()
+		if err != nil {
+			return err
+		}
+		encoded, err := decoder.bytes(int(min(size, uint64(len(decoder.data)+1))))
+		if err != nil {
+			return err
+		}
+		value.Set(reflect.New(value.Type().Elem()))
+		return value.Interface().(gob.GobDecoder).GobDecode(encoded)
+	}
+	switch value.Kind() {
+	case reflect.Bool:
+		b, err := decoder.bytes(1)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b[0] != 0)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, n := binary.Varint(decoder.data)
+		if n <= 0 {
+			return decoder.errTruncated()
+		}
+		decoder.data = decoder.data[n:]
+		value.SetInt(v)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := decoder.uvarint()
+		if err != nil {
+			return err
+		}
+		value.SetUint(v)
+		return nil
+	case reflect.Float32:
+		b, err := decoder.bytes(4)
+		if err != nil {
+			return err
+		}
+		value.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+		return nil
+	case reflect.Float64:
+		b, err := decoder.bytes(8)
+		if err != nil {
+			return err
+		}
+		value.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		return nil
+	case reflect.String:
+		size, err := decoder.uvarint()
+		if err != nil {
+			return err
+		}
+		b, err := decoder.bytes(int(min(size, uint64(len(decoder.data)+1))))
+		if err != nil {
+			return err
+		}
+		value.SetString(string(b))
+		return nil
+	case reflect.Slice:
+		elem := value.Type().Elem()
+		minSize := 1
+		if elem == float64Type {
+			minSize = 8
+		} else if elem.Kind() == reflect.Struct && elem.NumField() == 0 {
+			minSize = 0
+		}
+		n, isNil, err := decoder.length(minSize)
+		if err != nil {
+			return err
+		}
+		if isNil {
+			value.SetZero()
+			return nil
+		}
+		if elem == float64Type {
+			b, err := decoder.bytes(n * 8)
+			if err != nil {
+				return err
+			}
+			floats := make([]float64, n)
+			for i := range floats {
+				floats[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[i*8:]))
+			}
+			value.Set(reflect.ValueOf(floats).Convert(value.Type()))
+			return nil
+		}
+		if elem.Kind() == reflect.Uint8 {
+			b, err := decoder.bytes(n)
+			if err != nil {
+				return err
+			}
+			value.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		slice := reflect.MakeSlice(value.Type(), n, n)
+		for i := 0; i < n; i++ {
+			err = decoder.decode(slice.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		value.Set(slice)
+		return nil
+	case reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			err := decoder.decode(value.Index(i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Pointer:
+		present, err := decoder.bytes(1)
+		if err != nil {
+			return err
+		}
+		if present[0] == 0 {
+			value.SetZero()
+			return nil
+		}
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+		return decoder.decode(value.Elem())
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if !value.Type().Field(i).IsExported() {
+				continue
+			}
+			err := decoder.decode(value.Field(i))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		n, isNil, err := decoder.length(2)
+		if err != nil {
+			return err
+		}
+		if isNil {
+			value.SetZero()
+			return nil
+		}
+		result := reflect.MakeMapWithSize(value.Type(), n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(value.Type().Key()).Elem()
+			err = decoder.decode(key)
+			if err != nil {
+				return err
+			}
+			elem := reflect.New(value.Type().Elem()).Elem()
+			err = decoder.decode(elem)
+			if err != nil {
+				return err
+			}
+			result.SetMapIndex(key, elem)
+		}
+		value.Set(result)
+		return nil
+	}
+	return fmt.Errorf("binaryCodec: Unsupported type %s", value.Type())
+}
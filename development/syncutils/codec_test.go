@@ -0,0 +1,225 @@
+package syncutils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"recommendation-service/model"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// codecMessages devuelve un ejemplo de cada mensaje con todos sus campos
+// completos. No lleva slices vacíos: JSON y gob no los distinguen de nil.
+func codecMessages() []any {
+	return []any{
+		&MasterSyncRequest{
+			MasterIp:      "10.0.0.1",
+			MovieGenreIds: [][]int{{0, 3}, {1}, {2, 5, 7}},
+			ModelConfig: model.ModelConfig{
+				NumFeatures:    2,
+				Epochs:         10,
+				LearningRate:   0.01,
+				Regularization: 0.02,
+				Algorithm:      model.AlgorithmSGD,
+				Biased:         true,
+				GlobalMean:     3.5,
+				ItemBias:       []float64{0.1, -0.2, 0.3},
+				P:              [][]float64{{0.5, -1.5}},
+				Q:              [][]float64{{1, 2}, {-3, 4.25}, {0, 1e-9}},
+				ItemIds:        []string{"10", "20", "30"},
+			},
+			Ann:          &model.AnnConfig{Enabled: true, NumLists: 4},
+			ModelVersion: 7,
+			Delta:        true,
+			BaseVersion:  6,
+			ItemUpdates:  []ItemUpdate{{MovieId: 2, Factors: []float64{0.5, 0.25}, Bias: -0.5}},
+			Transfer:     &ModelTransfer{Codec: CodecBinary, Size: 2500, ChunkSize: 1000, NumChunks: 3, Digest: "abcd"},
+			Shard:        &Shard{Mode: ShardingHash, Index: 1, NumShards: 2, NumMovies: 6},
+		},
+		&MasterRecRequest{
+			UserId:       42,
+			UserRatings:  model.SparseVector{Indices: []int{1, 5}, Values: []float64{4, 2.5}},
+			StartMovieId: 0,
+			EndMovieId:   100,
+			Quantity:     10,
+			GenreIds:     []int{3, 8},
+			UserFactors:  []float64{0.1, -0.7},
+			UserBias:     0.3,
+			Type:         RequestSimilar,
+			MovieId:      5,
+			MovieFactors: []float64{1, -1},
+		},
+		&SlaveRecResponse{
+			Predictions: []Prediction{{MovieId: 3, Rating: 4.5}, {MovieId: -1, Rating: -0.25}},
+			Sum:         12.5,
+			Max:         4.5,
+			Min:         -0.25,
+			Count:       -3,
+		},
+		&SyncChunk{Index: 2, Data: []byte("chunk\x00\xff data"), Checksum: 0xdeadbeef},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range codecs {
+		for _, message := range codecMessages() {
+			t.Run(fmt.Sprintf("%s/%T", codec.Name(), message), func(t *testing.T) {
+				data, err := codec.Marshal(message)
+				if err != nil {
+					t.Fatalf("Marshal: %v", err)
+				}
+				decoded := reflect.New(reflect.TypeOf(message).Elem()).Interface()
+				err = codec.Unmarshal(data, decoded)
+				if err != nil {
+					t.Fatalf("Unmarshal: %v", err)
+				}
+				if !reflect.DeepEqual(decoded, message) {
+					t.Errorf("got %+v, want %+v", decoded, message)
+				}
+			})
+		}
+	}
+}
+
+func TestCodecRatingsRoundTrip(t *testing.T) {
+	ratings := model.NewRatings(2, 3, []model.Rating{{UserId: 0, ItemId: 2, Value: 4}, {UserId: 1, ItemId: 0, Value: 1.5, Timestamp: 99}})
+	for _, codec := range []Codec{gobCodec{}, binaryCodec{}} {
+		data, err := codec.Marshal(&model.ModelConfig{Ratings: ratings})
+		if err != nil {
+			t.Fatalf("%s: Marshal: %v", codec.Name(), err)
+		}
+		var decoded model.ModelConfig
+		err = codec.Unmarshal(data, &decoded)
+		if err != nil {
+			t.Fatalf("%s: Unmarshal: %v", codec.Name(), err)
+		}
+		if decoded.Ratings == nil || !reflect.DeepEqual(decoded.Ratings.Entries(), ratings.Entries()) {
+			t.Errorf("%s: got %v, want %v", codec.Name(), decoded.Ratings, ratings)
+		}
+	}
+}
+
+func TestCodecTruncated(t *testing.T) {
+	for _, codec := range codecs {
+		for _, message := range codecMessages() {
+			data, err := codec.Marshal(message)
+			if err != nil {
+				t.Fatalf("%s: Marshal %T: %v", codec.Name(), message, err)
+			}
+			for n := 0; n < len(data); n++ {
+				decoded := reflect.New(reflect.TypeOf(message).Elem()).Interface()
+				if codec.Unmarshal(data[:n], decoded) == nil {
+					t.Errorf("%s: %T truncated to %d of %d bytes decoded without error", codec.Name(), message, n, len(data))
+				}
+			}
+		}
+	}
+}
+
+func TestBinaryCodecInvalidLengths(t *testing.T) {
+	huge := binary.AppendUvarint(nil, 1<<62)
+	tests := []struct {
+		name   string
+		data   []byte
+		object any
+	}{
+		// Después del prefijo viene el largo del primer campo de largo variable
+		{"slice", huge, &SlaveRecResponse{}},
+		{"string", huge, &MasterSyncRequest{}},
+		{"bytes", append([]byte{0}, huge...), &SyncChunk{}},
+		{"floats", append([]byte{0}, huge...), &SlavePartialUserFactors{}},
+		{"overflow", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, &SlaveRecResponse{}},
+	}
+	for _, test := range tests {
+		err := binaryCodec{}.Unmarshal(test.data, test.object)
+		if err == nil {
+			t.Errorf("%s: decoded without error", test.name)
+		}
+	}
+
+	data, err := binaryCodec{}.Marshal(&SyncChunk{Index: 1, Data: []byte{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = binaryCodec{}.Unmarshal(append(data, 0), &SyncChunk{})
+	if err == nil || !strings.Contains(err.Error(), "trailing") {
+		t.Errorf("trailing byte: got %v", err)
+	}
+}
+
+// TestConnHello hace el hello de DialNode contra AcceptConn sobre net.Pipe con
+// cada codec y un mensaje que se comprime.
+func TestConnHello(t *testing.T) {
+	for _, name := range DefaultCodecs {
+		t.Run(name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			request := codecMessages()[1].(*MasterRecRequest)
+			request.UserRatings.Values = make([]float64, 500)
+			request.UserRatings.Indices = make([]int, 500)
+			response := codecMessages()[2].(*SlaveRecResponse)
+
+			type slaveResult struct {
+				conn    *Conn
+				request MasterRecRequest
+				err     error
+			}
+			done := make(chan slaveResult)
+			go func() {
+				conn := AcceptConn(server, NewHello("slave"))
+				var received MasterRecRequest
+				err := conn.Receive(&received)
+				if err == nil {
+					err = conn.Send(response)
+				}
+				done <- slaveResult{conn, received, err}
+			}()
+
+			local := NewHello("master")
+			local.Codecs = []string{name}
+			local.MaxFrameSize = 64 << 10
+			conn := NewConn(client)
+			conn.SetMaxFrameSize(local.MaxFrameSize)
+			err := conn.sendHello(&local)
+			if err != nil {
+				t.Fatalf("sendHello: %v", err)
+			}
+			if conn.Codec().Name() != name || conn.Compressor() != CompressionDeflate {
+				t.Errorf("negotiated %s/%s, want %s/%s", conn.Codec().Name(), conn.Compressor(), name, CompressionDeflate)
+			}
+			if conn.Peer().NodeId != "slave" || conn.Peer().Version() != ProtocolVersion {
+				t.Errorf("peer %s", conn.Peer())
+			}
+			err = conn.Send(request)
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			var received SlaveRecResponse
+			err = conn.Receive(&received)
+			if err != nil {
+				t.Fatalf("Receive: %v", err)
+			}
+			if !reflect.DeepEqual(&received, response) {
+				t.Errorf("master got %+v, want %+v", received, response)
+			}
+
+			slave := <-done
+			if slave.err != nil {
+				t.Fatalf("slave: %v", slave.err)
+			}
+			if !reflect.DeepEqual(&slave.request, request) {
+				t.Errorf("slave got %+v, want %+v", slave.request, request)
+			}
+			if slave.conn.Codec().Name() != name || slave.conn.Peer().NodeId != "master" {
+				t.Errorf("slave negotiated %s with %s", slave.conn.Codec().Name(), slave.conn.Peer())
+			}
+			if slave.conn.maxFrameSize != local.MaxFrameSize {
+				t.Errorf("slave max frame size %d, want %d", slave.conn.maxFrameSize, local.MaxFrameSize)
+			}
+		})
+	}
+}
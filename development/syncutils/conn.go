@@ -0,0 +1,273 @@
+package syncutils
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Cada frame es un encabezado de 5 bytes, el largo del payload (uint32 big
+// endian) y su tipo, seguido del payload.
+const frameHeaderSize = 5
+
+// Tipos de frame
+const (
+	FrameJSON  byte = 1
+	FrameHello byte = 2
+	// FrameCompressed se combina con el tipo del codec cuando el payload va
+	// comprimido.
+	FrameCompressed byte = 0x80
+)
+
+// Compresiones que se negocian en el hello
+const CompressionDeflate = "deflate"
+
+var DefaultCompression = []string{CompressionDeflate}
+
+// Los payloads más chicos que esto no se comprimen
+const compressThreshold = 1024
+
+func knownCompression(name string) bool {
+	return name == CompressionDeflate
+}
+
+// DefaultMaxFrameSize acota el payload que se acepta de un frame. La
+// sincronización completa viaja en chunks y el resto de los mensajes entra
+// en unos pocos MiB. El master lo configura y lo envía en el hello.
+const DefaultMaxFrameSize = 8<<20 + 2*DefaultChunkSize
+
+// legacyMaxFrameSize es el límite con los nodos sin sincronización en chunks,
+// que envían el modelo completo en un solo frame. Un master de protocolo 1 no
+// envía hello, así que con él rige el límite por defecto.
+const legacyMaxFrameSize = 1 << 30
+
+var (
+	ErrFrameTooLarge    = errors.New("frame too large")
+	ErrUnknownFrameType = errors.New("unknown frame type")
+	ErrNoCommonCodec    = errors.New("no common codec")
+)
+
+// Conn es una conexión entre master y slave con mensajes en frames. Tiene un
+// único lector y escritor con buffer, así los bytes leídos de más quedan para
+// el mensaje siguiente. No es segura para uso concurrente.
+type Conn struct {
+	conn         net.Conn
+	reader       *bufio.Reader
+	writer       *bufio.Writer
+	maxFrameSize int
+	// codec es el que se usa para enviar; hasta negociar otro es JSON, que
+	// es lo que hablan los nodos anteriores al hello.
+	codec      Codec
+	compressor string
+	// local es el hello de este nodo y peer el del otro extremo, nil hasta
+	// el primer mensaje.
+	local Hello
+	peer  *Hello
+}
+
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{
+		conn:         conn,
+		reader:       bufio.NewReader(conn),
+		writer:       bufio.NewWriter(conn),
+		maxFrameSize: DefaultMaxFrameSize,
+		codec:        jsonCodec{},
+		local:        NewHello(""),
+	}
+}
+
+// AcceptConn envuelve una conexión aceptada; local es el hello con el que se
+// responde al nodo que conectó.
+func AcceptConn(conn net.Conn, local Hello) *Conn {
+	result := NewConn(conn)
+	result.local = local
+	return result
+}
+
+// Dial abre una conexión TCP con el nodo en address.
+func Dial(address string) (*Conn, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn), nil
+}
+
+// SetMaxFrameSize cambia el límite de los frames que se envían y reciben;
+// 0 deja el actual.
+func (conn *Conn) SetMaxFrameSize(size int) {
+	if size > 0 {
+		conn.maxFrameSize = size
+	}
+}
+
+func (conn *Conn) SetDeadline(deadline time.Time) error {
+	return conn.conn.SetDeadline(deadline)
+}
+
+func (conn *Conn) RemoteAddr() net.Addr {
+	return conn.conn.RemoteAddr()
+}
+
+func (conn *Conn) Close() error {
+	return conn.conn.Close()
+}
+
+// WriteFrame escribe un frame completo y vacía el buffer.
+func (conn *Conn) WriteFrame(frameType byte, payload []byte) error {
+	if len(payload) > conn.maxFrameSize {
+		return fmt.Errorf("frameWrite: %w: %d bytes, max %d", ErrFrameTooLarge, len(payload), conn.maxFrameSize)
+	}
+	var header [frameHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	header[4] = frameType
+	_, err := conn.writer.Write(header[:])
+	if err != nil {
+		return fmt.Errorf("frameWrite: Error writing header: %v", err)
+	}
+	_, err = conn.writer.Write(payload)
+	if err != nil {
+		return fmt.Errorf("frameWrite: Error writing payload: %v", err)
+	}
+	err = conn.writer.Flush()
+	if err != nil {
+		return fmt.Errorf("frameWrite: Error flushing writer: %v", err)
+	}
+	return nil
+}
+
+// ReadFrame lee el próximo frame. Los frames de más de maxFrameSize se
+// rechazan antes de leer el payload, y el buffer crece a medida que llegan
+// los bytes en vez de reservar de entrada el largo del encabezado.
+func (conn *Conn) ReadFrame() (byte, []byte, error) {
+	var header [frameHeaderSize]byte
+	_, err := io.ReadFull(conn.reader, header[:])
+	if err != nil {
+		return 0, nil, fmt.Errorf("frameRead: Error reading header: %w", err)
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if uint64(size) > uint64(conn.maxFrameSize) {
+		return 0, nil, fmt.Errorf("frameRead: %w: %d bytes, max %d", ErrFrameTooLarge, size, conn.maxFrameSize)
+	}
+	var payload bytes.Buffer
+	_, err = io.CopyN(&payload, conn.reader, int64(size))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("frameRead: Error reading payload: %w", err)
+	}
+	return header[4], payload.Bytes(), nil
+}
+
+// Codec devuelve el codec con el que se envían los mensajes.
+func (conn *Conn) Codec() Codec {
+	return conn.codec
+}
+
+// Compressor devuelve la compresión negociada, vacía si no hay.
+func (conn *Conn) Compressor() string {
+	return conn.compressor
+}
+
+// Peer devuelve el hello del otro extremo. Los nodos de protocolo 1 no
+// envían hello y se describen solo con su versión.
+func (conn *Conn) Peer() *Hello {
+	return conn.peer
+}
+
+// Send envía object con el codec de la conexión.
+func (conn *Conn) Send(object any) error {
+	payload, err := conn.codec.Marshal(object)
+	if err != nil {
+		return fmt.Errorf("mssgSend: Error encoding %s: %v", conn.codec.Name(), err)
+	}
+	frameType := conn.codec.FrameType()
+	if conn.compressor != "" && len(payload) >= compressThreshold {
+		payload, err = deflate(payload)
+		if err != nil {
+			return fmt.Errorf("mssgSend: Error compressing: %v", err)
+		}
+		frameType |= FrameCompressed
+	}
+	return conn.WriteFrame(frameType, payload)
+}
+
+// Receive lee el próximo mensaje en object, decodificándolo según el tipo de
+// frame. Si el otro extremo abre con un hello se responde con el codec
+// elegido y se sigue leyendo.
+func (conn *Conn) Receive(object any) error {
+	frameType, payload, err := conn.ReadFrame()
+	if err != nil {
+		return err
+	}
+	if conn.peer == nil {
+		if frameType != FrameHello {
+			// Nodo de protocolo 1, sin hello
+			conn.peer = &Hello{ProtocolVersion: 1}
+			err = checkVersions(&conn.local, conn.peer)
+			if err != nil {
+				return fmt.Errorf("helloError: Rejected %s: %w", conn.peer, err)
+			}
+		} else {
+			err = conn.acceptHello(payload)
+			if err != nil {
+				return err
+			}
+			frameType, payload, err = conn.ReadFrame()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if frameType&FrameCompressed != 0 {
+		payload, err = inflate(payload, conn.maxFrameSize)
+		if err != nil {
+			return fmt.Errorf("mssgReceive: Error decompressing: %w", err)
+		}
+		frameType &^= FrameCompressed
+	}
+	codec := codecByFrameType(frameType)
+	if codec == nil {
+		return fmt.Errorf("mssgReceive: %w %d", ErrUnknownFrameType, frameType)
+	}
+	err = codec.Unmarshal(payload, object)
+	if err != nil {
+		return fmt.Errorf("mssgReceive: Error decoding %s: %v", codec.Name(), err)
+	}
+	return nil
+}
+
+func deflate(payload []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer, err := flate.NewWriter(&buffer, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	_, err = writer.Write(payload)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	return buffer.Bytes(), err
+}
+
+// inflate descomprime un payload sin pasar de maxSize bytes.
+func inflate(payload []byte, maxSize int) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(payload))
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes decompressed", ErrFrameTooLarge, maxSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,374 @@
+package syncutils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Versiones del protocolo entre master y slave:
+//
+//	1: frames JSON sin hello
+//	2: hello con la negociación de codecs
+//	3: hello con versión, id del nodo, versión del modelo y features
+//	4: sincronización completa en chunks
+//	5: sharding de las películas entre los slaves
+const (
+	ProtocolVersion = 5
+	// MinProtocolVersion es la versión más vieja con la que se habla por
+	// defecto; los nodos pueden exigir una mayor.
+	MinProtocolVersion = 1
+)
+
+// Features que un nodo declara en el hello
+const (
+	FeatureDelta       = "delta"
+	FeatureSimilar     = "similar"
+	FeatureGenreFilter = "genreFilter"
+	FeatureChunkedSync = "chunkedSync"
+	FeatureSharding    = "sharding"
+)
+
+// Features de este código
+var DefaultFeatures = []string{FeatureDelta, FeatureSimilar, FeatureGenreFilter, FeatureChunkedSync, FeatureSharding}
+
+// Los nodos de protocolo 1 y 2 no declaran features pero ya soportaban estas.
+var legacyFeatures = []string{FeatureDelta, FeatureSimilar, FeatureGenreFilter}
+
+var (
+	ErrProtocolVersion = errors.New("unsupported protocol version")
+	ErrHelloRejected   = errors.New("hello rejected")
+	ErrMissingFeature  = errors.New("feature not supported")
+)
+
+// Hello es el primer frame de una conexión. Quien conecta envía lo que
+// soporta en orden de preferencia y el otro extremo responde con lo suyo,
+// el codec y la compresión elegidos, o el motivo del rechazo en Error.
+type Hello struct {
+	ProtocolVersion    int      `json:"protocolVersion,omitempty"`
+	MinProtocolVersion int      `json:"minProtocolVersion,omitempty"`
+	NodeId             string   `json:"nodeId,omitempty"`
+	ModelVersion       int      `json:"modelVersion"`
+	HasModel           bool     `json:"hasModel,omitempty"`
+	Codecs             []string `json:"codecs,omitempty"`
+	Compression        []string `json:"compression,omitempty"`
+	Features           []string `json:"features,omitempty"`
+	// MaxFrameSize es el frame más grande que envía el nodo que conecta; el
+	// que acepta lo adopta como límite de la conexión. 0 es el por defecto.
+	MaxFrameSize int `json:"maxFrameSize,omitempty"`
+	// Respuesta
+	Codec      string `json:"codec,omitempty"`
+	Compressor string `json:"compressor,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// NewHello devuelve el hello de un nodo con los valores por defecto.
+func NewHello(nodeId string) Hello {
+	return Hello{
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: MinProtocolVersion,
+		NodeId:             nodeId,
+		Codecs:             DefaultCodecs,
+		Compression:        DefaultCompression,
+		Features:           DefaultFeatures,
+	}
+}
+
+// Version normaliza la versión de un hello recibido: el de protocolo 2 no
+// la incluía.
+func (hello *Hello) Version() int {
+	if hello.ProtocolVersion == 0 {
+		return 2
+	}
+	return hello.ProtocolVersion
+}
+
+// Supports indica si el nodo declaró la feature.
+func (hello *Hello) Supports(feature string) bool {
+	if hello.Version() < 3 {
+		return slices.Contains(legacyFeatures, feature)
+	}
+	return slices.Contains(hello.Features, feature)
+}
+
+func (hello *Hello) name() string {
+	if hello.NodeId == "" {
+		return "unknown node"
+	}
+	return hello.NodeId
+}
+
+// String describe al nodo para los logs.
+func (hello *Hello) String() string {
+	return fmt.Sprintf("%s (protocol %d)", hello.name(), hello.Version())
+}
+
+// CheckFeatures verifica que el nodo del otro extremo soporte features.
+func (conn *Conn) CheckFeatures(features []string) error {
+	for _, feature := range features {
+		if conn.peer != nil && !conn.peer.Supports(feature) {
+			return fmt.Errorf("%w: %s does not support %s", ErrMissingFeature, conn.peer, feature)
+		}
+	}
+	return nil
+}
+
+// checkVersions rechaza al par si alguno de los dos no acepta la versión
+// del otro.
+func checkVersions(local, peer *Hello) error {
+	if peer.Version() < local.MinProtocolVersion {
+		return fmt.Errorf("%w: %s speaks protocol %d, minimum is %d", ErrProtocolVersion, peer.name(), peer.Version(), local.MinProtocolVersion)
+	}
+	if local.ProtocolVersion < peer.MinProtocolVersion {
+		return fmt.Errorf("%w: %s requires protocol %d, this node speaks %d", ErrProtocolVersion, peer.name(), peer.MinProtocolVersion, local.ProtocolVersion)
+	}
+	return nil
+}
+
+// El codec binary no lleva nombres de campos, solo se puede usar si los dos
+// nodos tienen los mismos mensajes, es decir la misma versión.
+func codecAllowed(name string, peer *Hello) bool {
+	return name != CodecBinary || peer.Version() == ProtocolVersion
+}
+
+const helloTimeout = 5 * time.Second
+
+const dialNodePrefix = "dialNode"
+
+// peerVersions guarda la versión de los nodos de protocolo 1 y 2, para
+// hablarles directamente como corresponde. Vence a los peerVersionTTL y se
+// vuelve a probar con hello, por si el nodo se actualizó.
+var peerVersions sync.Map
+
+const peerVersionTTL = 5 * time.Minute
+
+type peerVersion struct {
+	version int
+	expires time.Time
+}
+
+func storePeerVersion(address string, version int) {
+	peerVersions.Store(address, peerVersion{version: version, expires: time.Now().Add(peerVersionTTL)})
+}
+
+// loadPeerVersion devuelve la versión guardada de address, 0 si no hay o venció.
+func loadPeerVersion(address string) int {
+	value, ok := peerVersions.Load(address)
+	if !ok {
+		return 0
+	}
+	cached := value.(peerVersion)
+	if time.Now().After(cached.expires) {
+		peerVersions.CompareAndDelete(address, value)
+		return 0
+	}
+	return cached.version
+}
+
+// DialNode conecta con el nodo en address y hace el hello con local. Los
+// nodos de protocolo 1 cierran la conexión al recibir un frame desconocido;
+// si local los acepta se reconecta y se habla JSON sin hello.
+func DialNode(address string, local Hello) (*Conn, error) {
+	if len(local.Codecs) == 0 {
+		local.Codecs = DefaultCodecs
+	}
+	switch loadPeerVersion(address) {
+	case 1:
+		return dialLegacy(address, &local)
+	case 2:
+		local.Codecs = withoutBinary(local.Codecs)
+	}
+	conn, err := dialHello(address, &local)
+	if err != nil {
+		return nil, err
+	}
+	err = conn.sendHello(&local)
+	if errors.Is(err, errBinaryMismatch) {
+		// Un nodo de protocolo 2 eligió binary sin saber nuestra versión
+		conn.Close()
+		storePeerVersion(address, 2)
+		local.Codecs = withoutBinary(local.Codecs)
+		conn, err = dialHello(address, &local)
+		if err != nil {
+			return nil, err
+		}
+		err = conn.sendHello(&local)
+	}
+	if err == nil {
+		return conn, nil
+	}
+	conn.Close()
+	if !errors.Is(err, errNoHelloReply) {
+		return nil, err
+	}
+	log.Printf("INFO: %s: %s does not support hello, using protocol 1", dialNodePrefix, address)
+	storePeerVersion(address, 1)
+	return dialLegacy(address, &local)
+}
+
+func withoutBinary(codecs []string) []string {
+	return slices.DeleteFunc(slices.Clone(codecs), func(name string) bool { return name == CodecBinary })
+}
+
+func dialLegacy(address string, local *Hello) (*Conn, error) {
+	peer := Hello{ProtocolVersion: 1}
+	err := checkVersions(local, &peer)
+	if err != nil {
+		return nil, fmt.Errorf("helloError: %s: %w", address, err)
+	}
+	conn, err := Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetMaxFrameSize(legacyMaxFrameSize)
+	conn.local = *local
+	conn.peer = &peer
+	return conn, nil
+}
+
+func dialHello(address string, local *Hello) (*Conn, error) {
+	conn, err := Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetMaxFrameSize(local.MaxFrameSize)
+	return conn, nil
+}
+
+var errBinaryMismatch = errors.New("binary codec with a different protocol version")
+
+// errNoHelloReply es un cierre limpio del otro extremo sin responder el
+// hello, lo que hacen los nodos de protocolo 1. Un reset o un cierre a mitad
+// de un frame no cuentan: pueden ser un nodo actual que se reinicia.
+var errNoHelloReply = errors.New("connection closed without a hello reply")
+
+func (conn *Conn) sendHello(local *Hello) error {
+	for _, name := range local.Codecs {
+		if CodecByName(name) == nil {
+			return fmt.Errorf("helloError: Unknown codec %s", name)
+		}
+	}
+	payload, err := json.Marshal(local)
+	if err != nil {
+		return fmt.Errorf("helloError: Error encoding hello: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(helloTimeout))
+	defer conn.SetDeadline(time.Time{})
+	err = conn.WriteFrame(FrameHello, payload)
+	if err != nil {
+		return err
+	}
+	_, err = conn.reader.Peek(1)
+	if err == io.EOF {
+		return fmt.Errorf("helloError: %w", errNoHelloReply)
+	}
+	frameType, payload, err := conn.ReadFrame()
+	if err != nil {
+		return err
+	}
+	if frameType != FrameHello {
+		return fmt.Errorf("helloError: %w %d waiting for hello", ErrUnknownFrameType, frameType)
+	}
+	var reply Hello
+	err = json.Unmarshal(payload, &reply)
+	if err != nil {
+		return fmt.Errorf("helloError: Error decoding hello: %v", err)
+	}
+	if reply.Error != "" {
+		return fmt.Errorf("helloError: %w by %s: %s", ErrHelloRejected, &reply, reply.Error)
+	}
+	err = checkVersions(local, &reply)
+	if err != nil {
+		return fmt.Errorf("helloError: %w", err)
+	}
+	codec := CodecByName(reply.Codec)
+	if codec == nil || !slices.Contains(local.Codecs, reply.Codec) {
+		return fmt.Errorf("helloError: %w: offered %v, got %q", ErrNoCommonCodec, local.Codecs, reply.Codec)
+	}
+	if !codecAllowed(reply.Codec, &reply) {
+		return errBinaryMismatch
+	}
+	if reply.Compressor != "" && (!slices.Contains(local.Compression, reply.Compressor) || !knownCompression(reply.Compressor)) {
+		return fmt.Errorf("helloError: Unknown compression %q", reply.Compressor)
+	}
+	conn.local = *local
+	conn.peer = &reply
+	conn.codec = codec
+	conn.compressor = reply.Compressor
+	conn.adjustMaxFrameSize(0)
+	return nil
+}
+
+// adjustMaxFrameSize adopta el límite que envió el nodo que conectó, o el de
+// los nodos que envían el modelo completo en un frame si el otro extremo no
+// soporta la sincronización en chunks.
+func (conn *Conn) adjustMaxFrameSize(peerMaxFrameSize int) {
+	if peerMaxFrameSize > 0 {
+		conn.maxFrameSize = min(peerMaxFrameSize, legacyMaxFrameSize)
+	}
+	if !conn.peer.Supports(FeatureChunkedSync) {
+		conn.maxFrameSize = legacyMaxFrameSize
+	}
+}
+
+// acceptHello responde el hello del nodo que conectó con el hello local y
+// el primer codec y compresión ofrecidos que se soportan. Si se rechaza al
+// nodo el motivo viaja en la respuesta y se devuelve el error.
+func (conn *Conn) acceptHello(payload []byte) error {
+	var hello Hello
+	err := json.Unmarshal(payload, &hello)
+	if err != nil {
+		return fmt.Errorf("helloError: Error decoding hello: %v", err)
+	}
+	conn.peer = &hello
+	conn.adjustMaxFrameSize(hello.MaxFrameSize)
+	reply := conn.local
+	reply.ProtocolVersion = ProtocolVersion
+
+	err = checkVersions(&conn.local, &hello)
+	if err == nil {
+		for _, name := range hello.Codecs {
+			codec := CodecByName(name)
+			if codec != nil && slices.Contains(conn.local.Codecs, name) && codecAllowed(name, &hello) {
+				reply.Codec = name
+				conn.codec = codec
+				break
+			}
+		}
+		if reply.Codec == "" {
+			err = fmt.Errorf("%w: offered %v, supported %v", ErrNoCommonCodec, hello.Codecs, conn.local.Codecs)
+		}
+	}
+	if err == nil {
+		for _, name := range hello.Compression {
+			if knownCompression(name) && slices.Contains(conn.local.Compression, name) {
+				reply.Compressor = name
+				break
+			}
+		}
+	}
+	if err != nil {
+		reply.Error = err.Error()
+		reply.Codec = ""
+		conn.codec = jsonCodec{}
+	}
+
+	payload, marshalErr := json.Marshal(&reply)
+	if marshalErr != nil {
+		return fmt.Errorf("helloError: Error encoding hello: %v", marshalErr)
+	}
+	writeErr := conn.WriteFrame(FrameHello, payload)
+	if err != nil {
+		return fmt.Errorf("helloError: Rejected %s: %w", &hello, err)
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	conn.compressor = reply.Compressor
+	return nil
+}
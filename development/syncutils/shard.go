@@ -0,0 +1,100 @@
+package syncutils
+
+import (
+	"fmt"
+	"recommendation-service/model"
+)
+
+// Modos de sharding de las películas
+const (
+	ShardingRange = "range"
+	ShardingHash  = "hash"
+)
+
+// Shard son las películas que tiene un slave en modo sharding: el rango
+// contiguo Index de NumShards, o las películas cuyo hash cae en Index. Los
+// slaves guardan las filas de Q en el orden de MovieIds.
+type Shard struct {
+	Mode      string `json:"mode"`
+	Index     int    `json:"index"`
+	NumShards int    `json:"numShards"`
+	NumMovies int    `json:"numMovies"`
+}
+
+func (shard *Shard) Validate() error {
+	if shard.Mode != ShardingRange && shard.Mode != ShardingHash {
+		return fmt.Errorf("shardError: Unknown sharding mode %q", shard.Mode)
+	}
+	if shard.NumShards <= 0 || shard.Index < 0 || shard.Index >= shard.NumShards || shard.NumMovies < 0 {
+		return fmt.Errorf("shardError: Invalid shard %d of %d over %d movies", shard.Index, shard.NumShards, shard.NumMovies)
+	}
+	return nil
+}
+
+// ShardOf devuelve el shard de movieId. Los rangos se reparten como los
+// batches: todos del mismo tamaño y el resto en el último.
+func ShardOf(mode string, movieId, numShards, numMovies int) int {
+	if mode == ShardingHash {
+		return int(movieHash(movieId) % uint64(numShards))
+	}
+	size := numMovies / numShards
+	if size == 0 {
+		return numShards - 1
+	}
+	return min(movieId/size, numShards-1)
+}
+
+// movieHash es el finalizador de splitmix64, para que ids consecutivos
+// queden repartidos entre los shards.
+func movieHash(movieId int) uint64 {
+	x := uint64(movieId) + 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+func (shard *Shard) Contains(movieId int) bool {
+	return movieId >= 0 && movieId < shard.NumMovies && ShardOf(shard.Mode, movieId, shard.NumShards, shard.NumMovies) == shard.Index
+}
+
+// Bounds devuelve el rango de ids que cubre el shard: el suyo en modo range
+// y el catálogo completo en modo hash.
+func (shard *Shard) Bounds() (int, int) {
+	if shard.Mode == ShardingHash {
+		return 0, shard.NumMovies
+	}
+	size := shard.NumMovies / shard.NumShards
+	start := shard.Index * size
+	end := start + size
+	if shard.Index == shard.NumShards-1 {
+		end = shard.NumMovies
+	}
+	return start, end
+}
+
+// MovieIds devuelve los ids globales del shard en orden ascendente.
+func (shard *Shard) MovieIds() []int {
+	start, end := shard.Bounds()
+	var movieIds []int
+	if shard.Mode == ShardingRange {
+		movieIds = make([]int, 0, end-start)
+	}
+	for movieId := start; movieId < end; movieId++ {
+		if shard.Contains(movieId) {
+			movieIds = append(movieIds, movieId)
+		}
+	}
+	return movieIds
+}
+
+// Ratings devuelve los ratings de las películas del shard.
+func (shard *Shard) Ratings(ratings model.SparseVector) model.SparseVector {
+	result := model.SparseVector{Indices: []int{}, Values: []float64{}}
+	for n, movieId := range ratings.Indices {
+		if shard.Contains(movieId) {
+			result.Indices = append(result.Indices, movieId)
+			result.Values = append(result.Values, ratings.Values[n])
+		}
+	}
+	return result
+}
@@ -1,13 +1,13 @@
 package syncutils
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"os"
 	"recommendation-service/model"
+	"strconv"
 	"strings"
 )
 
@@ -21,39 +21,106 @@ type MasterSyncRequest struct {
 	MasterIp      string            `json:"masterIp"`
 	MovieGenreIds [][]int           `json:"movieGenreIds"`
 	ModelConfig   model.ModelConfig `json:"modelConfig"`
+	// Ann, si está habilitado, hace que el slave arme un índice aproximado
+	// sobre Q para las recomendaciones.
+	Ann *model.AnnConfig `json:"ann,omitempty"`
+	// Versión del modelo enviado. Con Delta solo viajan las películas
+	// actualizadas, que el slave aplica si tiene BaseVersion.
+	ModelVersion int          `json:"modelVersion"`
+	Delta        bool         `json:"delta,omitempty"`
+	BaseVersion  int          `json:"baseVersion,omitempty"`
+	ItemUpdates  []ItemUpdate `json:"itemUpdates,omitempty"`
+	// Transfer anuncia una sincronización completa en chunks; el resto del
+	// pedido llega codificado en ellos.
+	Transfer *ModelTransfer `json:"transfer,omitempty"`
+	// Shard, en modo sharding, indica qué películas tiene el slave: Q,
+	// ItemBias, MovieGenreIds e ItemUpdates traen solo las suyas, en el orden
+	// de Shard.MovieIds.
+	Shard *Shard `json:"shard,omitempty"`
+}
+
+type ItemUpdate struct {
+	MovieId int       `json:"movieId"`
+	Factors []float64 `json:"factors"`
+	Bias    float64   `json:"bias"`
 }
 
+const (
+	SyncStatusOk = iota
+	// El slave no tiene la versión base de una actualización parcial
+	SyncStatusNeedFull
+	// El slave espera el chunk NextChunk de la transferencia
+	SyncStatusNextChunk
+	// El modelo recibido no coincide con el digest anunciado
+	SyncStatusDigestMismatch
+)
+
 type SlaveSyncResponse struct {
-	Status int `json:"status"`
+	Status    int `json:"status"`
+	NextChunk int `json:"nextChunk,omitempty"`
 }
 
 // Recommendation Communication
 type ClientRecRequest struct {
-	UserId   int       `json:"userId"`
-	Ratings  []float64 `json:"ratings"`
-	Quantity int       `json:"quantity"`
-	GenreIds []int     `json:"genreIds"`
+	UserId   int                `json:"userId"`
+	Ratings  model.SparseVector `json:"ratings"`
+	Quantity int                `json:"quantity"`
+	GenreIds []int              `json:"genreIds"`
+	// Factores de partida del fold-in (aleatorios si están vacíos); al
+	// terminar la recomendación quedan los calculados.
+	UserFactors []float64 `json:"userFactors,omitempty"`
+	UserBias    float64   `json:"userBias,omitempty"`
 }
 
+// Tipos de pedido que el master envía al puerto de recomendaciones
+const (
+	RequestRecommendation = iota
+	RequestSimilar
+)
+
 type MasterRecRequest struct {
-	UserId       int       `json:"userId"`
-	UserRatings  []float64 `json:"userRatings"`
-	StartMovieId int       `json:"startMovieId"`
-	EndMovieId   int       `json:"endMovieId"`
-	Quantity     int       `json:"quantity"`
-	GenreIds     []int     `json:"genreIds"`
-	UserFactors  []float64 `json:"userFactors"`
+	UserId       int                `json:"userId"`
+	UserRatings  model.SparseVector `json:"userRatings"`
+	StartMovieId int                `json:"startMovieId"`
+	EndMovieId   int                `json:"endMovieId"`
+	Quantity     int                `json:"quantity"`
+	GenreIds     []int              `json:"genreIds"`
+	UserFactors  []float64          `json:"userFactors"`
+	UserBias     float64            `json:"userBias"`
+	// Los pedidos RequestSimilar responden directamente con un SlaveRecResponse
+	// cuyos Rating son la similitud coseno con MovieFactors.
+	Type         int       `json:"type,omitempty"`
+	MovieId      int       `json:"movieId,omitempty"`
+	MovieFactors []float64 `json:"movieFactors,omitempty"`
+}
+
+// RequiredFeatures devuelve las features que necesita el slave para
+// atender el pedido.
+func (request *MasterRecRequest) RequiredFeatures() []string {
+	var features []string
+	if request.Type == RequestSimilar {
+		features = append(features, FeatureSimilar)
+	}
+	if len(request.GenreIds) > 0 {
+		features = append(features, FeatureGenreFilter)
+	}
+	return features
 }
 
 type SlavePartialUserFactors struct {
-	UserId       int       `json:"userId"`
-	WeightedGrad []float64 `json:"userFactors"`
-	Count        int       `json:"count"`
+	UserId           int       `json:"userId"`
+	WeightedGrad     []float64 `json:"userFactors"`
+	WeightedBiasGrad float64   `json:"weightedBiasGrad"`
+	Count            int       `json:"count"`
+	// Ecuaciones normales parciales, solo para modelos ALS
+	Gram []float64 `json:"gram,omitempty"`
+	Rhs  []float64 `json:"rhs,omitempty"`
 }
 
 type MasterUserFactors struct {
 	UserId      int       `json:"userId"`
 	UserFactors []float64 `json:"userLatentFactors"`
+	UserBias    float64   `json:"userBias"`
 }
 
 type SlaveRecResponse struct {
@@ -83,42 +150,9 @@ type Recommendation struct {
 }
 
 func JoinAddress(ip string, port int) string {
-	return fmt.Sprintf("%s:%d", ip, port)
+	return net.JoinHostPort(ip, strconv.Itoa(port))
 }
 
-func ReceiveJsonMessageAsObject(object any, conn *net.Conn) error {
-	reader := bufio.NewReader(*conn)
-	bytes, err := reader.ReadBytes('\n')
-	if err != nil {
-		return fmt.Errorf("jsonMssgReceive: Error reading bytes: %v", err)
-	}
-	err = json.Unmarshal(bytes, object)
-	if err != nil {
-		return fmt.Errorf("jsonMssgReceive: Error unmarshalling JSON: %v", err)
-	}
-	return nil
-}
-
-func SendObjectAsJsonMessage(object any, conn *net.Conn) error {
-	bytes, err := json.Marshal(object)
-	if err != nil {
-		return fmt.Errorf("jsonMssgSend: Error marshalling JSON: %v", err)
-	}
-	writer := bufio.NewWriter(*conn)
-	_, err = writer.Write(bytes)
-	if err != nil {
-		return fmt.Errorf("jsonMssgSend: Error writing bytes: %v", err)
-	}
-	err = writer.WriteByte('\n')
-	if err != nil {
-		return fmt.Errorf("jsonMssgSend: Error writing newline: %v", err)
-	}
-	err = writer.Flush()
-	if err != nil {
-		return fmt.Errorf("jsonMssgSend: Error flushing writer: %v", err)
-	}
-	return nil
-}
 func LoadJsonFile(filename string, object interface{}) error {
 	file, err := os.Open(filename)
 	if err != nil {
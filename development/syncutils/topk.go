@@ -0,0 +1,102 @@
+package syncutils
+
+import "container/heap"
+
+// ranksBefore ordena por rating descendente y, a igual rating, por id de
+// película, para que el resultado no dependa del orden de llegada.
+func ranksBefore(a, b Prediction) bool {
+	if a.Rating != b.Rating {
+		return a.Rating > b.Rating
+	}
+	return a.MovieId < b.MovieId
+}
+
+// predictionHeap es un min-heap: la raíz es la peor de las k retenidas.
+type predictionHeap []Prediction
+
+func (h predictionHeap) Len() int           { return len(h) }
+func (h predictionHeap) Less(i, j int) bool { return ranksBefore(h[j], h[i]) }
+func (h predictionHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *predictionHeap) Push(x any)        { *h = append(*h, x.(Prediction)) }
+func (h *predictionHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// TopK retiene las k mejores predicciones de las que recibe en O(n log k).
+type TopK struct {
+	k    int
+	heap predictionHeap
+}
+
+func NewTopK(k int) *TopK {
+	if k < 0 {
+		k = 0
+	}
+	return &TopK{k: k, heap: make(predictionHeap, 0, k)}
+}
+
+func (top *TopK) Push(prediction Prediction) {
+	if len(top.heap) < top.k {
+		heap.Push(&top.heap, prediction)
+	} else if top.k > 0 && ranksBefore(prediction, top.heap[0]) {
+		top.heap[0] = prediction
+		heap.Fix(&top.heap, 0)
+	}
+}
+
+// Sorted devuelve las predicciones retenidas de mejor a peor y vacía el TopK.
+func (top *TopK) Sorted() []Prediction {
+	sorted := make([]Prediction, len(top.heap))
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = heap.Pop(&top.heap).(Prediction)
+	}
+	return sorted
+}
+
+// cursor apunta a la próxima predicción de una de las listas de MergeTopK.
+type cursor struct {
+	list []Prediction
+	next int
+}
+
+type cursorHeap []cursor
+
+func (h cursorHeap) Len() int { return len(h) }
+func (h cursorHeap) Less(i, j int) bool {
+	return ranksBefore(h[i].list[h[i].next], h[j].list[h[j].next])
+}
+func (h cursorHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *cursorHeap) Push(x any)   { *h = append(*h, x.(cursor)) }
+func (h *cursorHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// MergeTopK junta listas ya ordenadas de mejor a peor (como las respuestas
+// de los slaves) y devuelve las k mejores en O(k log listas).
+func MergeTopK(lists [][]Prediction, k int) []Prediction {
+	k = max(k, 0)
+	h := make(cursorHeap, 0, len(lists))
+	for _, list := range lists {
+		if len(list) > 0 {
+			h = append(h, cursor{list: list})
+		}
+	}
+	heap.Init(&h)
+	merged := make([]Prediction, 0, k)
+	for len(merged) < k && len(h) > 0 {
+		merged = append(merged, h[0].list[h[0].next])
+		h[0].next++
+		if h[0].next == len(h[0].list) {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return merged
+}
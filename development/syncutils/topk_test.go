@@ -0,0 +1,194 @@
+package syncutils
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// sortedTopK es la referencia de los tests: ordena todo por rating
+// descendente y, a igual rating, por id, y corta en k.
+func sortedTopK(predictions []Prediction, k int) []Prediction {
+	sorted := append([]Prediction{}, predictions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].MovieId < sorted[j].MovieId
+	})
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Rating > sorted[j].Rating
+	})
+	return sorted[:min(max(k, 0), len(sorted))]
+}
+
+// tiedPredictions repite pocos ratings para que el orden dependa del id.
+func tiedPredictions(n int) []Prediction {
+	random := rand.New(rand.NewSource(2))
+	predictions := make([]Prediction, n)
+	for i, movieId := range random.Perm(n) {
+		predictions[i] = Prediction{MovieId: movieId, Rating: float64(random.Intn(3))}
+	}
+	return predictions
+}
+
+func TestTopK(t *testing.T) {
+	tests := []struct {
+		name        string
+		predictions []Prediction
+		k           int
+	}{
+		{"nil", nil, 5},
+		{"empty", []Prediction{}, 5},
+		{"k=0", randomPredictions(10), 0},
+		{"k<0", randomPredictions(10), -1},
+		{"k<n", randomPredictions(100), 10},
+		{"k=n", randomPredictions(10), 10},
+		{"k>n", randomPredictions(3), 10},
+		{"ties", tiedPredictions(50), 10},
+		{"all equal", []Prediction{{MovieId: 4, Rating: 1}, {MovieId: 2, Rating: 1}, {MovieId: 9, Rating: 1}, {MovieId: 0, Rating: 1}}, 2},
+	}
+	for _, test := range tests {
+		top := NewTopK(test.k)
+		for _, prediction := range test.predictions {
+			top.Push(prediction)
+		}
+		got := top.Sorted()
+		want := sortedTopK(test.predictions, test.k)
+		if len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Errorf("%s: got %v, want %v", test.name, got, want)
+		}
+	}
+}
+
+// splitSorted reparte las predicciones en listas ordenadas, como las
+// respuestas de los slaves.
+func splitSorted(predictions []Prediction, numLists int) [][]Prediction {
+	lists := make([][]Prediction, numLists)
+	for i, prediction := range predictions {
+		lists[i%numLists] = append(lists[i%numLists], prediction)
+	}
+	for i, list := range lists {
+		lists[i] = sortedTopK(list, len(list))
+	}
+	return lists
+}
+
+func TestMergeTopK(t *testing.T) {
+	tests := []struct {
+		name  string
+		lists [][]Prediction
+		k     int
+	}{
+		{"nil", nil, 5},
+		{"empty lists", [][]Prediction{{}, nil, {}}, 5},
+		{"k=0", splitSorted(randomPredictions(20), 3), 0},
+		{"k<0", splitSorted(randomPredictions(20), 3), -1},
+		{"k<n", splitSorted(randomPredictions(100), 4), 10},
+		{"k>n", splitSorted(randomPredictions(5), 2), 10},
+		{"some empty", [][]Prediction{nil, sortedTopK(randomPredictions(8), 8), {}}, 5},
+		{"ties", splitSorted(tiedPredictions(60), 4), 15},
+		{"ties across lists", [][]Prediction{{{MovieId: 7, Rating: 2}, {MovieId: 1, Rating: 1}}, {{MovieId: 3, Rating: 2}, {MovieId: 0, Rating: 1}}}, 3},
+	}
+	for _, test := range tests {
+		var all []Prediction
+		for _, list := range test.lists {
+			all = append(all, list...)
+		}
+		got := MergeTopK(test.lists, test.k)
+		want := sortedTopK(all, test.k)
+		if len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+			t.Errorf("%s: got %v, want %v", test.name, got, want)
+		}
+	}
+}
+
+// Comparan la selección por heap con el ordenamiento completo que se usaba
+// antes, en el slave (un rango de películas) y en el master (las respuestas
+// de los slaves). Correr con: go test ./syncutils -bench . -benchmem
+
+var (
+	benchmarkCatalogs = []int{10_000, 100_000, 1_000_000}
+	benchmarkSink     []Prediction
+)
+
+const (
+	benchmarkQuantity = 10
+	benchmarkSlaves   = 4
+)
+
+func randomPredictions(n int) []Prediction {
+	random := rand.New(rand.NewSource(1))
+	predictions := make([]Prediction, n)
+	for i := range predictions {
+		predictions[i] = Prediction{MovieId: i, Rating: random.NormFloat64()}
+	}
+	return predictions
+}
+
+func BenchmarkSlaveTopK(b *testing.B) {
+	for _, n := range benchmarkCatalogs {
+		predictions := randomPredictions(n)
+		b.Run(fmt.Sprintf("movies=%d/heap", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				top := NewTopK(benchmarkQuantity)
+				for _, prediction := range predictions {
+					top.Push(prediction)
+				}
+				benchmarkSink = top.Sorted()
+			}
+		})
+		b.Run(fmt.Sprintf("movies=%d/sort", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				pred := append([]Prediction(nil), predictions...)
+				sort.Slice(pred, func(i, j int) bool {
+					return pred[i].Rating > pred[j].Rating
+				})
+				benchmarkSink = pred[:benchmarkQuantity]
+			}
+		})
+	}
+}
+
+// slaveResponses arma las respuestas ordenadas de cada slave para un catálogo
+// de n películas repartido en rangos.
+func slaveResponses(n int) [][]Prediction {
+	predictions := randomPredictions(n)
+	responses := make([][]Prediction, benchmarkSlaves)
+	rangeSize := n / benchmarkSlaves
+	for slave := range responses {
+		top := NewTopK(benchmarkQuantity)
+		for _, prediction := range predictions[slave*rangeSize : (slave+1)*rangeSize] {
+			top.Push(prediction)
+		}
+		responses[slave] = top.Sorted()
+	}
+	return responses
+}
+
+func BenchmarkMasterMerge(b *testing.B) {
+	for _, n := range benchmarkCatalogs {
+		responses := slaveResponses(n)
+		b.Run(fmt.Sprintf("movies=%d/merge", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				benchmarkSink = MergeTopK(responses, benchmarkQuantity)
+			}
+		})
+		b.Run(fmt.Sprintf("movies=%d/resort", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var predictions []Prediction
+				for j, response := range responses {
+					predictions = append(predictions, response...)
+					if j > 0 {
+						sort.Slice(predictions, func(i, j int) bool {
+							return predictions[i].Rating > predictions[j].Rating
+						})
+						if len(predictions) > benchmarkQuantity {
+							predictions = predictions[:benchmarkQuantity]
+						}
+					}
+				}
+				benchmarkSink = predictions
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package syncutils
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// DefaultChunkSize es el tamaño de los chunks de la sincronización completa.
+const DefaultChunkSize = 1 << 20
+
+var (
+	ErrChunkChecksum  = errors.New("chunk checksum mismatch")
+	ErrDigestMismatch = errors.New("model digest mismatch")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChunkFrameSize es el frame más grande que ocupa un chunk de chunkSize
+// bytes: en JSON los datos van en base64.
+func ChunkFrameSize(chunkSize int) int {
+	return base64.StdEncoding.EncodedLen(chunkSize) + 256
+}
+
+// ModelTransfer describe una sincronización completa en chunks: el
+// MasterSyncRequest codificado con Codec y partido en NumChunks de
+// ChunkSize bytes (el último puede ser menor). Digest es el SHA-256 del
+// modelo codificado e identifica la transferencia para retomarla.
+type ModelTransfer struct {
+	Codec     string `json:"codec"`
+	Size      int    `json:"size"`
+	ChunkSize int    `json:"chunkSize"`
+	NumChunks int    `json:"numChunks"`
+	Digest    string `json:"digest"`
+}
+
+type SyncChunk struct {
+	Index    int    `json:"index"`
+	Data     []byte `json:"data"`
+	Checksum uint32 `json:"checksum"`
+}
+
+// EncodedModel es un MasterSyncRequest completo listo para enviarse en chunks.
+type EncodedModel struct {
+	Transfer ModelTransfer
+	data     []byte
+}
+
+// EncodeModel codifica request con el codec codecName y lo prepara para
+// enviarlo en chunks de chunkSize bytes.
+func EncodeModel(request *MasterSyncRequest, codecName string, chunkSize int) (*EncodedModel, error) {
+	codec := CodecByName(codecName)
+	if codec == nil {
+		return nil, fmt.Errorf("transferError: Unknown codec %s", codecName)
+	}
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("transferError: Invalid chunk size %d", chunkSize)
+	}
+	data, err := codec.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("transferError: Error encoding model: %v", err)
+	}
+	digest := sha256.Sum256(data)
+	return &EncodedModel{
+		Transfer: ModelTransfer{
+			Codec:     codecName,
+			Size:      len(data),
+			ChunkSize: chunkSize,
+			NumChunks: (len(data) + chunkSize - 1) / chunkSize,
+			Digest:    hex.EncodeToString(digest[:]),
+		},
+		data: data,
+	}, nil
+}
+
+// Chunk devuelve el chunk index con su checksum.
+func (encoded *EncodedModel) Chunk(index int) SyncChunk {
+	start := index * encoded.Transfer.ChunkSize
+	end := min(start+encoded.Transfer.ChunkSize, len(encoded.data))
+	data := encoded.data[start:end]
+	return SyncChunk{Index: index, Data: data, Checksum: crc32.Checksum(data, castagnoli)}
+}
+
+// ModelReceiver junta los chunks de una transferencia en orden. Sobrevive a
+// la conexión, así una transferencia cortada se retoma desde Next.
+type ModelReceiver struct {
+	transfer ModelTransfer
+	data     []byte
+	next     int
+}
+
+// Buffer inicial máximo; el resto crece a medida que llegan los chunks
+const maxInitialBuffer = 64 << 20
+
+func NewModelReceiver(transfer ModelTransfer) (*ModelReceiver, error) {
+	if CodecByName(transfer.Codec) == nil {
+		return nil, fmt.Errorf("transferError: Unknown codec %s", transfer.Codec)
+	}
+	if transfer.ChunkSize <= 0 || transfer.Size < 0 || transfer.NumChunks != (transfer.Size+transfer.ChunkSize-1)/transfer.ChunkSize {
+		return nil, fmt.Errorf("transferError: Invalid transfer: %d bytes in %d chunks of %d", transfer.Size, transfer.NumChunks, transfer.ChunkSize)
+	}
+	return &ModelReceiver{
+		transfer: transfer,
+		data:     make([]byte, 0, min(transfer.Size, maxInitialBuffer)),
+	}, nil
+}
+
+func (receiver *ModelReceiver) Transfer() ModelTransfer {
+	return receiver.transfer
+}
+
+// Next es el próximo chunk que se espera.
+func (receiver *ModelReceiver) Next() int {
+	return receiver.next
+}
+
+func (receiver *ModelReceiver) Done() bool {
+	return receiver.next == receiver.transfer.NumChunks
+}
+
+// Add agrega el chunk si es el esperado y su checksum coincide.
+func (receiver *ModelReceiver) Add(chunk *SyncChunk) error {
+	if chunk.Index != receiver.next {
+		return fmt.Errorf("transferError: Expected chunk %d, got %d", receiver.next, chunk.Index)
+	}
+	size := receiver.transfer.ChunkSize
+	if chunk.Index == receiver.transfer.NumChunks-1 {
+		size = receiver.transfer.Size - chunk.Index*receiver.transfer.ChunkSize
+	}
+	if len(chunk.Data) != size {
+		return fmt.Errorf("transferError: Chunk %d has %d bytes, expected %d", chunk.Index, len(chunk.Data), size)
+	}
+	if crc32.Checksum(chunk.Data, castagnoli) != chunk.Checksum {
+		return fmt.Errorf("transferError: %w in chunk %d", ErrChunkChecksum, chunk.Index)
+	}
+	receiver.data = append(receiver.data, chunk.Data...)
+	receiver.next++
+	return nil
+}
+
+// Decode verifica el digest del modelo completo y lo decodifica en request.
+func (receiver *ModelReceiver) Decode(request *MasterSyncRequest) error {
+	if !receiver.Done() {
+		return fmt.Errorf("transferError: Transfer incomplete, %d of %d chunks", receiver.next, receiver.transfer.NumChunks)
+	}
+	digest := sha256.Sum256(receiver.data)
+	if hex.EncodeToString(digest[:]) != receiver.transfer.Digest {
+		return fmt.Errorf("transferError: %w", ErrDigestMismatch)
+	}
+	err := CodecByName(receiver.transfer.Codec).Unmarshal(receiver.data, request)
+	if err != nil {
+		return fmt.Errorf("transferError: Error decoding model: %v", err)
+	}
+	return nil
+}
+
+// Progress devuelve el porcentaje recibido o enviado hasta el chunk next.
+func (transfer *ModelTransfer) Progress(next int) int {
+	if transfer.NumChunks == 0 {
+		return 100
+	}
+	return next * 100 / transfer.NumChunks
+}
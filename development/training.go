@@ -1,30 +1,601 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"recommendation-service/master"
 	"recommendation-service/model"
+	"recommendation-service/syncutils"
+	"strconv"
+	"strings"
 )
 
+const usage = `Usage: go run training.go <command> [flags]
+
+Commands:
+  train      train a model and save it
+  search     hyperparameter search, saves the best model
+  evaluate   error and ranking metrics of a saved model
+  inspect    factor statistics, health checks and movie neighbors of a saved model
+
+Run "go run training.go <command> -h" for the flags of each command.
+`
+
 func main() {
-	R, err := model.LoadTrainData("./dataset/clean_ratings.csv")
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	command, args := os.Args[1], os.Args[2:]
+	var err error
+	switch command {
+	case "train":
+		err = runTrain(args)
+	case "search":
+		err = runSearch(args)
+	case "evaluate":
+		err = runEvaluate(args)
+	case "inspect":
+		err = runInspect(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("ERROR: %s: %v", command, err)
+	}
+}
+
+// Flags del dataset y de la partición, comunes a train, search y evaluate.
+type dataFlags struct {
+	path        string
+	delimiter   string
+	implicit    bool
+	mapIds      bool
+	skipInvalid bool
+	split       string
+	validation  float64
+	test        float64
+	leaveK      int
+	// userIds e itemIds son los ids guardados con el modelo que se evalúa.
+	userIds *model.IdMap
+	itemIds *model.IdMap
+}
+
+func addDataFlags(flags *flag.FlagSet, validation, test float64) *dataFlags {
+	data := &dataFlags{}
+	flags.StringVar(&data.path, "data", "./dataset/clean_ratings.csv", "ratings file (user;item;rating[;timestamp])")
+	flags.StringVar(&data.delimiter, "delimiter", ";", "field delimiter of the ratings file")
+	flags.BoolVar(&data.implicit, "implicit", false, "read the file as implicit feedback events (user;item[;weight[;timestamp]])")
+	flags.BoolVar(&data.mapIds, "map-ids", false, "map external ids to dense indices and store them with the model")
+	flags.BoolVar(&data.skipInvalid, "skip-invalid", false, "skip malformed rows instead of failing")
+	flags.StringVar(&data.split, "split", model.SplitRandom, "split method: random, temporal or leave-k-out")
+	flags.Float64Var(&data.validation, "validation", validation, "fraction of ratings held out for validation")
+	flags.Float64Var(&data.test, "test", test, "fraction of ratings held out for test")
+	flags.IntVar(&data.leaveK, "leave-k", 1, "ratings per user held out by the leave-k-out split")
+	return data
+}
+
+func (data *dataFlags) load() (*model.Dataset, error) {
+	config := model.DefaultDatasetConfig()
+	config.Delimiter = data.delimiter
+	config.Implicit = data.implicit
+	config.MapIds = data.mapIds
+	config.SkipInvalidRows = data.skipInvalid
+	config.Users = data.userIds
+	config.Items = data.itemIds
+	dataset, err := model.LoadDataset(data.path, config)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("INFO: Loaded %d ratings, %d users, %d items from %s", dataset.Ratings.Len(), dataset.Ratings.NumUsers(), dataset.Ratings.NumItems(), data.path)
+	return dataset, nil
+}
+
+// splitRatings devuelve la partición; sin fracciones todo queda en Train. La
+// partición solo depende de la semilla, así que evaluate reproduce la de train.
+func (data *dataFlags) splitRatings(ratings *model.Ratings, seed int) (model.DataSplit, error) {
+	if data.split != model.SplitLeaveKOut && data.validation == 0 && data.test == 0 {
+		return model.DataSplit{Train: ratings}, nil
+	}
+	return model.SplitRatings(ratings, model.SplitConfig{
+		Method:             data.split,
+		ValidationFraction: data.validation,
+		TestFraction:       data.test,
+		K:                  data.leaveK,
+		RandomState:        seed,
+	})
+}
+
+type modelFlags struct {
+	algorithm      string
+	numFeatures    int
+	epochs         int
+	learningRate   float64
+	regularization float64
+	biased         bool
+	alpha          float64
+	workers        int
+	deterministic  bool
+	seed           int
+}
+
+func addModelFlags(flags *flag.FlagSet) *modelFlags {
+	hyper := &modelFlags{}
+	flags.StringVar(&hyper.algorithm, "algorithm", model.AlgorithmSGD, "sgd, als or implicit-als")
+	flags.IntVar(&hyper.numFeatures, "features", 100, "number of latent factors")
+	flags.IntVar(&hyper.epochs, "epochs", 500, "number of epochs")
+	flags.Float64Var(&hyper.learningRate, "lr", 0.001, "SGD learning rate")
+	flags.Float64Var(&hyper.regularization, "reg", 0.0001, "regularization")
+	flags.BoolVar(&hyper.biased, "biased", false, "learn global, user and item biases")
+	flags.Float64Var(&hyper.alpha, "alpha", 0, "confidence scale of implicit-als (0 = default)")
+	flags.IntVar(&hyper.workers, "workers", 0, "training workers (0 = one per CPU)")
+	flags.BoolVar(&hyper.deterministic, "deterministic", false, "bit-identical SGD results for the same seed on any machine")
+	flags.IntVar(&hyper.seed, "seed", 1, "random seed for initialization, shuffling and splits")
+	return hyper
+}
+
+func (hyper *modelFlags) config() model.ModelConfig {
+	return model.ModelConfig{
+		NumFeatures:    hyper.numFeatures,
+		Epochs:         hyper.epochs,
+		LearningRate:   hyper.learningRate,
+		Regularization: hyper.regularization,
+		Algorithm:      hyper.algorithm,
+		NumWorkers:     hyper.workers,
+		Biased:         hyper.biased,
+		Alpha:          hyper.alpha,
+		Deterministic:  hyper.deterministic,
+	}
+}
+
+type outputFlags struct {
+	path   string
+	format string
+	report string
+}
+
+func addOutputFlags(flags *flag.FlagSet) *outputFlags {
+	output := &outputFlags{}
+	flags.StringVar(&output.path, "out", "./model/model.json", "output model file")
+	flags.StringVar(&output.format, "format", "json", "output format: json, binary or binary32")
+	flags.StringVar(&output.report, "report", "", "training report file (JSON), by default <model>.report.json next to the model")
+	return output
+}
+
+// reportPath devuelve el archivo del reporte: el de -report o, si no se
+// indicó, uno al lado del modelo.
+func (output *outputFlags) reportPath() string {
+	if output.report != "" {
+		return output.report
+	}
+	return strings.TrimSuffix(output.path, filepath.Ext(output.path)) + ".report.json"
+}
+
+func (output *outputFlags) save(trained *model.Model) error {
+	var err error
+	switch output.format {
+	case "json":
+		err = trained.ParamsToJson(output.path)
+	case "binary":
+		err = trained.ParamsToBinary(output.path, false)
+	case "binary32":
+		err = trained.ParamsToBinary(output.path, true)
+	default:
+		return fmt.Errorf("Unknown output format %q", output.format)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("INFO: Model saved to %s", output.path)
+	return nil
+}
+
+func runTrain(args []string) error {
+	flags := flag.NewFlagSet("train", flag.ExitOnError)
+	data := addDataFlags(flags, 0, 0)
+	hyper := addModelFlags(flags)
+	output := addOutputFlags(flags)
+	patience := flags.Int("patience", 0, "stop after this many epochs without validation improvement (0 = never)")
+	restoreBest := flags.Bool("restore-best", false, "keep the factors of the best validation epoch")
+	schedule := flags.String("schedule", model.ScheduleConstant, "learning rate schedule: constant, step, exponential or bold-driver")
+	stepSize := flags.Int("step-size", 0, "epochs between decays of the step schedule")
+	factor := flags.Float64("factor", 0, "decay factor of the step schedule")
+	rate := flags.Float64("decay-rate", 0, "rate of the exponential schedule")
+	checkpointDir := flags.String("checkpoint-dir", "", "write checkpoints to this directory")
+	checkpointEvery := flags.Int("checkpoint-every", 10, "epochs between checkpoints")
+	resume := flags.Bool("resume", false, "resume from the checkpoint in -checkpoint-dir")
+	k := flags.Int("k", 10, "cutoff of the ranking metrics on the test split")
+	threshold := flags.Float64("threshold", 4, "minimum rating of a relevant item")
+	flags.Parse(args)
+
+	dataset, err := data.load()
+	if err != nil {
+		return err
+	}
+	split, err := data.splitRatings(dataset.Ratings, hyper.seed)
+	if err != nil {
+		return err
+	}
+	modelConfig := hyper.config()
+	trained, err := model.NewModelFromConfig(&modelConfig, split.Train, hyper.seed)
+	if err != nil {
+		return err
+	}
+	err = trained.SetIdMaps(dataset.Users, dataset.Items)
+	if err != nil {
+		return err
+	}
+	learningRateSchedule, err := model.NewSchedule(model.ScheduleConfig{Type: *schedule, StepSize: *stepSize, Factor: *factor, Rate: *rate})
+	if err != nil {
+		return err
+	}
+
+	options := model.TrainOptions{
+		Patience:        *patience,
+		RestoreBest:     *restoreBest,
+		Schedule:        learningRateSchedule,
+		CheckpointDir:   *checkpointDir,
+		CheckpointEvery: *checkpointEvery,
+		Resume:          *resume,
+		OnEpoch: []model.EpochCallback{func(trained *model.Model, stats model.EpochStats) error {
+			log.Printf("INFO: Epoch %d: train RMSE %.4f, validation RMSE %.4f (%.1fs)", stats.Epoch, stats.TrainRMSE, stats.ValidationRMSE, stats.Seconds)
+			return nil
+		}},
+	}
+	if split.Validation != nil && split.Validation.Len() > 0 {
+		options.Validation = split.Validation
+	}
+	log.Printf("INFO: Training %s model with %d features", trained.Algorithm(), trained.NumFeatures())
+	report, err := trained.TrainWithOptions(options)
+	if err != nil {
+		return err
+	}
+	if split.Test != nil && split.Test.Len() > 0 {
+		metrics := trained.Evaluate(split.Test, split.Train, model.EvaluationConfig{K: *k, RelevanceThreshold: *threshold, RandomState: hyper.seed})
+		log.Printf("INFO: Test metrics: %+v", metrics)
+	}
+
+	err = output.save(&trained)
+	if err != nil {
+		return err
+	}
+	err = report.ToJson(output.reportPath())
+	if err != nil {
+		return err
+	}
+	log.Printf("INFO: Training report saved to %s", output.reportPath())
+	return nil
+}
+
+func runSearch(args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	data := addDataFlags(flags, 0.1, 0.1)
+	hyper := addModelFlags(flags)
+	output := addOutputFlags(flags)
+	features := flags.String("grid-features", "100", "comma separated numbers of factors")
+	epochs := flags.String("grid-epochs", "100,500", "comma separated numbers of epochs")
+	learningRates := flags.String("grid-lr", "0.01,0.001,0.0001", "comma separated learning rates")
+	regularizations := flags.String("grid-reg", "0.01,0.001,0.0001", "comma separated regularizations")
+	strategy := flags.String("strategy", model.StrategyGrid, "grid, random or halving")
+	trials := flags.Int("trials", 0, "sampled candidates for random and halving (0 = whole grid)")
+	searchWorkers := flags.Int("search-workers", 1, "models trained concurrently")
+	minEpochs := flags.Int("min-epochs", 10, "epochs of the first halving rung")
+	eta := flags.Int("eta", 3, "halving reduction factor")
+	results := flags.String("results", "", "JSON lines file with the finished trials, used to resume")
+	retryFailed := flags.Bool("retry-failed", false, "train again the trials that failed in the results file")
+	k := flags.Int("k", 10, "cutoff of the ranking metrics")
+	threshold := flags.Float64("threshold", 4, "minimum rating of a relevant item")
+	flags.Parse(args)
+
+	grid := model.ModelGrid{}
+	var err error
+	grid.NumFeatures, err = parseInts(*features)
+	if err != nil {
+		return err
+	}
+	grid.Epochs, err = parseInts(*epochs)
+	if err != nil {
+		return err
+	}
+	grid.LearningRate, err = parseFloats(*learningRates)
+	if err != nil {
+		return err
+	}
+	grid.Regularization, err = parseFloats(*regularizations)
+	if err != nil {
+		return err
+	}
+
+	dataset, err := data.load()
+	if err != nil {
+		return err
+	}
+	split, err := data.splitRatings(dataset.Ratings, hyper.seed)
+	if err != nil {
+		return err
+	}
+	if split.Validation == nil || split.Validation.Len() == 0 {
+		return fmt.Errorf("Search requires a validation split")
+	}
+	evaluation := model.EvaluationConfig{K: *k, RelevanceThreshold: *threshold, RandomState: hyper.seed}
+	result, err := model.RunSearch(grid, split, model.SearchConfig{
+		Strategy:    *strategy,
+		Workers:     *searchWorkers,
+		RandomState: hyper.seed,
+		NumTrials:   *trials,
+		MinEpochs:   *minEpochs,
+		Eta:         *eta,
+		ResultsFile: *results,
+		RetryFailed: *retryFailed,
+		Evaluation:  evaluation,
+		Base:        hyper.config(),
+	})
 	if err != nil {
-		log.Println(err)
+		return err
+	}
+	best := result.BestModel
+	if split.Test != nil && split.Test.Len() > 0 {
+		log.Printf("INFO: Best model test metrics: %+v", best.Evaluate(split.Test, split.Train, evaluation))
+	}
+	err = best.SetIdMaps(dataset.Users, dataset.Items)
+	if err != nil {
+		return err
+	}
+	err = output.save(&best)
+	if err != nil {
+		return err
+	}
+	err = writeJson(output.reportPath(), result.Trials)
+	if err != nil {
+		return err
+	}
+	log.Printf("INFO: Search report saved to %s", output.reportPath())
+	return nil
+}
+
+func runEvaluate(args []string) error {
+	flags := flag.NewFlagSet("evaluate", flag.ExitOnError)
+	data := addDataFlags(flags, 0.1, 0.1)
+	modelFile := flags.String("model", "./model/model.json", "model file (JSON or binary)")
+	heldOutFile := flags.String("heldout", "", "evaluate on this ratings file, with -data as the already seen ratings, instead of splitting -data")
+	seed := flags.Int("seed", 1, "seed of the split, the same used for training")
+	k := flags.Int("k", 10, "cutoff of the ranking metrics")
+	threshold := flags.Float64("threshold", 4, "minimum rating of a relevant item")
+	maxUsers := flags.Int("max-users", 0, "evaluate the ranking on a sample of users (0 = all)")
+	flags.Parse(args)
+
+	modelConfig, err := model.LoadModelFile(*modelFile)
+	if err != nil {
+		return err
+	}
+	trained := model.LoadModel(&modelConfig)
+	// Con ids guardados los dos archivos se traducen a las filas de P y Q del
+	// modelo; un IdMap nuevo por archivo no coincidiría con ellas.
+	data.userIds, data.itemIds = trained.UserIdMap(), trained.ItemIdMap()
+	if (data.userIds == nil) != (data.itemIds == nil) {
+		return fmt.Errorf("Model %s has ids for only users or items", *modelFile)
+	}
+	if data.userIds != nil {
+		data.mapIds = true
+	} else if data.mapIds {
+		return fmt.Errorf("Model %s has no ids, -map-ids requires a model trained with it", *modelFile)
+	}
+
+	dataset, err := data.load()
+	if err != nil {
+		return err
+	}
+	var heldOut, seen *model.Ratings
+	if *heldOutFile != "" {
+		heldOutData := *data
+		heldOutData.path = *heldOutFile
+		heldOutDataset, err := heldOutData.load()
+		if err != nil {
+			return err
+		}
+		heldOut, seen = heldOutDataset.Ratings, dataset.Ratings
+	} else {
+		split, err := data.splitRatings(dataset.Ratings, *seed)
+		if err != nil {
+			return err
+		}
+		heldOut, seen = split.Test, split.Train
+		if heldOut == nil || heldOut.Len() == 0 {
+			heldOut = split.Validation
+		}
+		if heldOut == nil || heldOut.Len() == 0 {
+			return fmt.Errorf("Nothing to evaluate, use -test, -validation or -heldout")
+		}
+	}
+	if len(modelConfig.P) == 0 {
+		return fmt.Errorf("Model %s has no user factors", *modelFile)
+	}
+	if heldOut.NumUsers() > len(modelConfig.P) || heldOut.NumItems() > len(modelConfig.Q) {
+		return fmt.Errorf("Ratings have %d users and %d items but the model has %d and %d", heldOut.NumUsers(), heldOut.NumItems(), len(modelConfig.P), len(modelConfig.Q))
+	}
+
+	metrics := trained.Evaluate(heldOut, seen, model.EvaluationConfig{K: *k, RelevanceThreshold: *threshold, MaxUsers: *maxUsers, RandomState: *seed})
+	return printJson(metrics)
+}
+
+func runInspect(args []string) error {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	modelFile := flags.String("model", "./model/model.json", "model file (JSON or binary)")
+	configFile := flags.String("config", "", "master config with the movie titles (optional)")
+	movie := flags.String("movie", "", "list the nearest neighbors of this movie (the dataset id if the model was trained with -map-ids)")
+	k := flags.Int("k", 10, "number of neighbors")
+	asJson := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	modelConfig, err := model.LoadModelFile(*modelFile)
+	if err != nil {
+		return err
+	}
+	inspected := model.LoadModel(&modelConfig)
+	var titles []string
+	if *configFile != "" {
+		var config master.MasterConfig
+		err = syncutils.LoadJsonFile(*configFile, &config)
+		if err != nil {
+			return err
+		}
+		if len(config.MovieTitles) != len(modelConfig.Q) {
+			return fmt.Errorf("Config has %d movie titles but the model has %d items", len(config.MovieTitles), len(modelConfig.Q))
+		}
+		titles = config.MovieTitles
+	}
+
+	report := inspected.Inspect()
+	// Con ids guardados -movie es un id del dataset y los vecinos se muestran
+	// con el suyo; sin ellos es directamente la fila de Q.
+	itemIds := inspected.ItemIdMap()
+	movieRow := -1
+	var neighbors []inspectedNeighbor
+	if *movie != "" {
+		movieRow, err = itemRow(itemIds, *movie)
+		if err != nil {
+			return err
+		}
+		similar, err := inspected.SimilarItems(movieRow, *k)
+		if err != nil {
+			return err
+		}
+		neighbors = make([]inspectedNeighbor, len(similar))
+		for i, neighbor := range similar {
+			neighbors[i] = inspectedNeighbor{Neighbor: neighbor, Id: itemId(itemIds, neighbor.ItemId)}
+		}
+	}
+	if *asJson {
+		return printJson(struct {
+			Model     model.InspectionReport `json:"model"`
+			Neighbors []inspectedNeighbor    `json:"neighbors,omitempty"`
+		}{report, neighbors})
+	}
+
+	fmt.Printf("Model:          %s\n", *modelFile)
+	fmt.Printf("Algorithm:      %s\n", report.Algorithm)
+	fmt.Printf("Features:       %d\n", report.NumFeatures)
+	fmt.Printf("Biased:         %v\n", report.Biased)
+	fmt.Printf("Epochs:         %d\n", modelConfig.Epochs)
+	fmt.Printf("Learning rate:  %g\n", modelConfig.LearningRate)
+	fmt.Printf("Regularization: %g\n", modelConfig.Regularization)
+	printMatrixStats("P", &report.P)
+	printMatrixStats("Q", &report.Q)
+	if report.Biased {
+		printMatrixStats("User bias", report.UserBias)
+		printMatrixStats("Item bias", report.ItemBias)
+	}
+	if report.Healthy() {
+		fmt.Println("\nNo problems found")
+	} else {
+		fmt.Println("\nWarnings:")
+		for _, warning := range report.Warnings {
+			fmt.Printf("  - %s\n", warning)
+		}
+	}
+
+	if movieRow >= 0 {
+		fmt.Printf("\nNearest neighbors of %s:\n", movieName(titles, itemIds, movieRow))
+		for rank, neighbor := range neighbors {
+			fmt.Printf("  %2d. %.4f  %s\n", rank+1, neighbor.Similarity, movieName(titles, itemIds, neighbor.ItemId))
+		}
+	}
+	return nil
+}
+
+func printMatrixStats(name string, stats *model.MatrixStats) {
+	fmt.Printf("\n%s: %d x %d\n", name, stats.Rows, stats.Cols)
+	if stats.Rows == 0 {
 		return
 	}
-	fmt.Println(len(R[0]))
-	/*
-		grids := model.ModelGrid{
-			NumFeatures:    []int{100},
-			Epochs:         []int{100, 500},
-			LearningRate:   []float64{0.01, 0.001, 0.0001},
-			Regularization: []float64{0.01, 0.001, 0.0001},
-		}
-		model.SearchGrid(grids, R)
-	*/
-	log.Println("Model")
-	model := model.NewModel(100, 500, 0.001, 0.0001, R, 1)
-	model.Train()
-	log.Println(model.CalculateRMSE())
-	model.ParamsToJson("./model/model.json")
+	fmt.Printf("  norms:  min %.4f  mean %.4f  max %.4f  p10 %.4f  p50 %.4f  p90 %.4f  p99 %.4f\n",
+		stats.NormMin, stats.NormMean, stats.NormMax, stats.NormQuantile[0], stats.NormQuantile[1], stats.NormQuantile[2], stats.NormQuantile[3])
+	fmt.Printf("  values: min %.4f  mean %.4f  std %.4f  max %.4f\n", stats.ValueMin, stats.ValueMean, stats.ValueStd, stats.ValueMax)
+	fmt.Printf("  NaN rows %d, Inf rows %d, zero rows %d, exploded rows %d\n", stats.NaNRows, stats.InfRows, stats.ZeroRows, len(stats.ExplodedRows))
+}
+
+// inspectedNeighbor agrega al vecino (ItemId es la fila de Q) su id del
+// dataset.
+type inspectedNeighbor struct {
+	model.Neighbor
+	Id string `json:"id"`
+}
+
+// itemRow traduce el id de -movie a la fila de Q.
+func itemRow(itemIds *model.IdMap, id string) (int, error) {
+	if itemIds != nil {
+		row, ok := itemIds.Index(id)
+		if !ok {
+			return -1, fmt.Errorf("Movie %q is not in the model", id)
+		}
+		return row, nil
+	}
+	row, err := strconv.Atoi(id)
+	if err != nil || row < 0 {
+		return -1, fmt.Errorf("Invalid movie %q, the model has no ids so it must be a row of Q", id)
+	}
+	return row, nil
+}
+
+func itemId(itemIds *model.IdMap, row int) string {
+	if itemIds == nil {
+		return strconv.Itoa(row)
+	}
+	return itemIds.Id(row)
+}
+
+func movieName(titles []string, itemIds *model.IdMap, row int) string {
+	if titles == nil {
+		return fmt.Sprintf("movie %s", itemId(itemIds, row))
+	}
+	return fmt.Sprintf("%s (%s)", titles[row], itemId(itemIds, row))
+}
+
+func printJson(object any) error {
+	bytes, err := json.MarshalIndent(object, "", "\t")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(bytes))
+	return nil
+}
+
+func parseInts(list string) ([]int, error) {
+	var values []int
+	for _, field := range strings.Split(list, ",") {
+		value, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid integer %q in %q", field, list)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func parseFloats(list string) ([]float64, error) {
+	var values []float64
+	for _, field := range strings.Split(list, ",") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number %q in %q", field, list)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func writeJson(filename string, object any) error {
+	bytes, err := json.MarshalIndent(object, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, bytes, 0644)
 }